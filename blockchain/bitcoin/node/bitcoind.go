@@ -0,0 +1,198 @@
+package node
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/bitcoin/tx"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var ErrBitcoindNotFound = errors.New("bitcoind not found in PATH")
+
+const (
+	bitcoindStartTimeout = 30 * time.Second
+	bitcoindStopTimeout  = 10 * time.Second
+	// CoinbaseMaturity is the number of blocks before a coinbase is spendable.
+	CoinbaseMaturity = 100
+)
+
+// Bitcoind is a bitcoind -regtest node started by RunBitcoind. The node has
+// a wallet which holds the mined coins, Fund pays from it.
+type Bitcoind struct {
+	*BtcClient
+	DataDir string
+	cmd     *exec.Cmd
+	exited  chan error
+	miner   btcutil.Address
+}
+
+// RunBitcoind starts bitcoind -regtest in a temp datadir on a free rpc port
+// and waits until its rpc is ready, the returned func stops the node and
+// removes the datadir.
+func RunBitcoind() (*Bitcoind, func(), error) {
+	path, err := exec.LookPath("bitcoind")
+	if err != nil {
+		return nil, nil, ErrBitcoindNotFound
+	}
+	dataDir, err := os.MkdirTemp("", "bitcoind-regtest-")
+	if err != nil {
+		return nil, nil, err
+	}
+	rpcPort, err := freePort()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, nil, err
+	}
+	pass := make([]byte, 16)
+	if _, err = rand.Read(pass); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, nil, err
+	}
+	user, password := "regtest", hex.EncodeToString(pass)
+
+	cmd := exec.Command(path,
+		"-regtest",
+		"-datadir="+dataDir,
+		"-server",
+		"-listen=0",
+		"-txindex",
+		"-fallbackfee=0.0002",
+		"-printtoconsole=0",
+		"-rpcbind=127.0.0.1",
+		"-rpcallowip=127.0.0.1",
+		fmt.Sprintf("-rpcport=%d", rpcPort),
+		"-rpcuser="+user,
+		"-rpcpassword="+password,
+	)
+	if err = cmd.Start(); err != nil {
+		os.RemoveAll(dataDir)
+		return nil, nil, err
+	}
+	b := &Bitcoind{DataDir: dataDir, cmd: cmd, exited: make(chan error, 1)}
+	go func() {
+		b.exited <- cmd.Wait()
+	}()
+
+	b.BtcClient, err = NewBtcClient(fmt.Sprintf("http://127.0.0.1:%d", rpcPort), user, password, wallet.BtcChainRegtest)
+	if err == nil {
+		err = b.waitReady()
+	}
+	if err == nil {
+		err = b.createWallet()
+	}
+	if err != nil {
+		b.stop()
+		return nil, nil, err
+	}
+	return b, b.stop, nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func (b *Bitcoind) waitReady() error {
+	deadline := time.After(bitcoindStartTimeout)
+	for {
+		if _, err := b.RpcClient.GetBlockCount(); err == nil {
+			return nil
+		}
+		select {
+		case err := <-b.exited:
+			b.exited <- err
+			return fmt.Errorf("bitcoind exited: %v", err)
+		case <-deadline:
+			return errors.New("bitcoind rpc is not ready")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (b *Bitcoind) createWallet() error {
+	name, _ := json.Marshal("regtest")
+	if _, err := b.RpcClient.RawRequest("createwallet", []json.RawMessage{name}); err != nil {
+		return err
+	}
+	miner, err := b.RpcClient.GetNewAddress("")
+	if err != nil {
+		return err
+	}
+	b.miner = miner
+	return nil
+}
+
+func (b *Bitcoind) stop() {
+	if b.BtcClient != nil {
+		b.RpcClient.RawRequest("stop", nil)
+		b.RpcClient.Shutdown()
+	}
+	select {
+	case <-b.exited:
+	case <-time.After(bitcoindStopTimeout):
+		b.cmd.Process.Kill()
+		<-b.exited
+	}
+	os.RemoveAll(b.DataDir)
+}
+
+// Mine mines n blocks to address, to the node's wallet if address is nil.
+func (b *Bitcoind) Mine(n int, address btcutil.Address) ([]*chainhash.Hash, error) {
+	if address == nil {
+		address = b.miner
+	}
+	return b.RpcClient.GenerateToAddress(int64(n), address, nil)
+}
+
+// Fund sends amount btc from the node's wallet to address and mines it, the
+// returned unspent is ready for tx.NewBtcTransaction. The wallet mines the
+// coins it needs first.
+func (b *Bitcoind) Fund(address btcutil.Address, amount float64) (*tx.BtcUnspent, error) {
+	balance, err := b.RpcClient.GetBalance("*")
+	if err != nil {
+		return nil, err
+	}
+	for balance.ToBTC() <= amount {
+		if _, err = b.Mine(CoinbaseMaturity+1, nil); err != nil {
+			return nil, err
+		}
+		if balance, err = b.RpcClient.GetBalance("*"); err != nil {
+			return nil, err
+		}
+	}
+
+	sats := tx.BtcToSatoshi(amount)
+	txid, err := b.RpcClient.SendToAddress(address, btcutil.Amount(sats))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = b.Mine(1, nil); err != nil {
+		return nil, err
+	}
+
+	rawTx, err := b.RpcClient.GetRawTransactionVerbose(txid)
+	if err != nil {
+		return nil, err
+	}
+	for _, out := range rawTx.Vout {
+		if out.ScriptPubKey.Address == address.EncodeAddress() {
+			return &tx.BtcUnspent{TxID: txid.String(), Vout: out.N,
+				ScriptPubKey: out.ScriptPubKey.Hex, Amount: out.Value}, nil
+		}
+	}
+	return nil, fmt.Errorf("output to %s not found in %s", address, txid)
+}
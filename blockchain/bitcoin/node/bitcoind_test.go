@@ -0,0 +1,107 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/bitcoin/tx"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitcoind(t *testing.T) {
+	cli, stop, err := RunBitcoind()
+	if errors.Is(err, ErrBitcoindNotFound) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	defer stop()
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewSegWitWallet(0, 0, 1)
+	require.NoError(t, err)
+	bw0 := w0.(*wallet.BtcWallet)
+	bw1 := w1.(*wallet.BtcWallet)
+
+	unspent, err := cli.Fund(bw0.DeriveNativeAddress(), 3)
+	require.NoError(t, err)
+	fmt.Println("funded:", unspent.TxID, unspent.Vout)
+
+	// regtest has no fee history, the estimate is from the mempool
+	estimate, err := NewBtcFeeEstimator(cli.BtcClient).Estimate(2, btcjson.EstimateModeEconomical)
+	require.NoError(t, err)
+	require.Equal(t, FeeSourceMempool, estimate.Source)
+	require.GreaterOrEqual(t, estimate.FeePerKb, DefaultMinFeePerKb)
+	fmt.Println("fee rate:", estimate.FeePerVByte, "sat/vB")
+
+	// the address is not in the node's wallet, it is scanned
+	utxos, err := cli.ListUnspent([]btcutil.Address{bw0.DeriveNativeAddress()}, 1)
+	require.NoError(t, err)
+	require.Equal(t, []tx.BtcUnspent{*unspent}, ToUnspents(utxos))
+	require.Equal(t, bw0.DeriveNativeAddress().EncodeAddress(), utxos[0].Address)
+
+	btcTx, err := tx.NewBtcTransaction(ToUnspents(utxos),
+		[]tx.BtcOutput{{Address: bw1.DeriveNativeAddress(), Amount: tx.BtcToSatoshi(1)}},
+		bw0.DeriveNativeAddress(), estimate.FeePerKb, bw0.ChainParams(), nil)
+	require.NoError(t, err)
+	require.NoError(t, btcTx.Sign(bw0))
+
+	var buf bytes.Buffer
+	require.NoError(t, btcTx.Tx.Serialize(&buf))
+	txid, err := cli.SendRawTransaction(hex.EncodeToString(buf.Bytes()), false)
+	require.NoError(t, err)
+	_, err = cli.Mine(1, nil)
+	require.NoError(t, err)
+
+	hash, err := chainhash.NewHashFromStr(txid)
+	require.NoError(t, err)
+	result, err := cli.RpcClient.GetRawTransactionVerbose(hash)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), result.Confirmations)
+	fmt.Println("fee:", btcTx.GetFee())
+
+	balance, err := cli.GetAddressBalance([]btcutil.Address{bw0.DeriveNativeAddress(), bw1.DeriveNativeAddress()})
+	require.NoError(t, err)
+	require.Equal(t, tx.BtcToSatoshi(3)-btcTx.GetFee(), balance.Confirmed)
+	require.Zero(t, balance.Unconfirmed)
+
+	// a legacy input is exported with its previous transaction
+	w2, err := hdw.NewWallet(wallet.SymbolBtc, 0, 0, 2)
+	require.NoError(t, err)
+	bw2 := w2.(*wallet.BtcWallet)
+	legacy, err := cli.Fund(bw2.DeriveNativeAddress(), 1)
+	require.NoError(t, err)
+	legacyTx, err := tx.NewBtcTransaction([]tx.BtcUnspent{*legacy},
+		[]tx.BtcOutput{{Address: bw1.DeriveNativeAddress(), Amount: tx.BtcToSatoshi(0.5)}},
+		bw2.DeriveNativeAddress(), estimate.FeePerKb, bw2.ChainParams(), nil)
+	require.NoError(t, err)
+	p, err := cli.ToPsbt(legacyTx)
+	require.NoError(t, err)
+	require.NotNil(t, p.Inputs[0].NonWitnessUtxo)
+	_, err = p.Sign(bw2)
+	require.NoError(t, err)
+	require.NoError(t, p.Finalize())
+	msgTx, err := p.Extract()
+	require.NoError(t, err)
+	buf.Reset()
+	require.NoError(t, msgTx.Serialize(&buf))
+	_, err = cli.SendRawTransaction(hex.EncodeToString(buf.Bytes()), false)
+	require.NoError(t, err)
+
+	// the miner address is in the node's wallet, it is listed by listunspent
+	utxos, err = cli.ListUnspent([]btcutil.Address{cli.miner}, 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, utxos)
+	require.Equal(t, cli.miner.EncodeAddress(), utxos[0].Address)
+}
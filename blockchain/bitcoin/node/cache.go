@@ -0,0 +1,163 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/cache"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// DefaultCacheConfirmations is the depth of the data of CachedBtcClient.
+	DefaultCacheConfirmations = 6
+	// headRefresh is how often the block count is read to tell whether a
+	// block is final.
+	headRefresh = time.Second
+)
+
+// CachedBtcClient caches the chain data which can't change: blocks and
+// headers by hash, and block hashes and transactions once they're
+// Confirmations deep. Everything else goes to the BtcClient.
+type CachedBtcClient struct {
+	*BtcClient
+	cache *cache.Cache
+	// Confirmations is the number of blocks, including the block of the data,
+	// until it's cached. DefaultCacheConfirmations if 0.
+	Confirmations int64
+	// KeyPrefix is the prefix of the keys, "btc:" if empty. The chains of a
+	// shared Backend need their own prefixes.
+	KeyPrefix string
+
+	mu     sync.Mutex
+	height int64
+	readAt time.Time
+}
+
+func NewCachedBtcClient(client *BtcClient, c *cache.Cache) *CachedBtcClient {
+	return &CachedBtcClient{BtcClient: client, cache: c}
+}
+
+func (this *CachedBtcClient) Cache() *cache.Cache {
+	return this.cache
+}
+
+func (this *CachedBtcClient) key(kind string, id interface{}) string {
+	prefix := this.KeyPrefix
+	if prefix == "" {
+		prefix = "btc:"
+	}
+	return fmt.Sprintf("%s%s:%v", prefix, kind, id)
+}
+
+func (this *CachedBtcClient) confirmations() int64 {
+	if this.Confirmations <= 0 {
+		return DefaultCacheConfirmations
+	}
+	return this.Confirmations
+}
+
+// final reports whether the block at height is Confirmations deep. The block
+// count is read at most every headRefresh.
+func (this *CachedBtcClient) final(height int64) (bool, error) {
+	this.mu.Lock()
+	count, readAt := this.height, this.readAt
+	this.mu.Unlock()
+	if count-height+1 >= this.confirmations() {
+		return true, nil
+	}
+	if time.Since(readAt) < headRefresh {
+		return false, nil
+	}
+	latest, err := this.RpcClient.GetBlockCount()
+	if err != nil {
+		return false, err
+	}
+	this.mu.Lock()
+	this.height, this.readAt = max(this.height, latest), time.Now()
+	count = this.height
+	this.mu.Unlock()
+	return count-height+1 >= this.confirmations(), nil
+}
+
+func (this *CachedBtcClient) GetBlock(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	data, err := this.cache.Get(context.Background(), this.key("block", hash), func(context.Context) ([]byte, bool, error) {
+		block, err := this.RpcClient.GetBlock(hash)
+		if err != nil {
+			return nil, false, err
+		}
+		var buf bytes.Buffer
+		err = block.Serialize(&buf)
+		return buf.Bytes(), true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	block := new(wire.MsgBlock)
+	if err = block.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (this *CachedBtcClient) GetBlockHeader(hash *chainhash.Hash) (*wire.BlockHeader, error) {
+	data, err := this.cache.Get(context.Background(), this.key("header", hash), func(context.Context) ([]byte, bool, error) {
+		header, err := this.RpcClient.GetBlockHeader(hash)
+		if err != nil {
+			return nil, false, err
+		}
+		var buf bytes.Buffer
+		err = header.Serialize(&buf)
+		return buf.Bytes(), true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	header := new(wire.BlockHeader)
+	if err = header.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+func (this *CachedBtcClient) GetBlockHash(height int64) (*chainhash.Hash, error) {
+	data, err := this.cache.Get(context.Background(), this.key("block-hash", height), func(context.Context) ([]byte, bool, error) {
+		hash, err := this.RpcClient.GetBlockHash(height)
+		if err != nil {
+			return nil, false, err
+		}
+		final, err := this.final(height)
+		return hash[:], final, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chainhash.NewHash(data)
+}
+
+// GetRawTransactionVerbose returns a cached transaction with the
+// Confirmations it had when it was cached.
+func (this *CachedBtcClient) GetRawTransactionVerbose(txid *chainhash.Hash) (*btcjson.TxRawResult, error) {
+	data, err := this.cache.Get(context.Background(), this.key("tx", txid), func(context.Context) ([]byte, bool, error) {
+		result, err := this.RpcClient.GetRawTransactionVerbose(txid)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := json.Marshal(result)
+		return data, int64(result.Confirmations) >= this.confirmations(), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := new(btcjson.TxRawResult)
+	if err = json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
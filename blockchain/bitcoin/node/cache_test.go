@@ -0,0 +1,109 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/cache"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedBtcClient(t *testing.T) {
+	const height = 100
+	var mu sync.Mutex
+	calls := map[string]int{}
+	header := wire.BlockHeader{Version: 4, Bits: 0x1d00ffff, Nonce: 7}
+	var headerHex bytes.Buffer
+	require.NoError(t, header.Serialize(&headerHex))
+	blockHash := func(h int64) chainhash.Hash {
+		return chainhash.DoubleHashH([]byte(fmt.Sprint(h)))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		calls[req.Method]++
+		mu.Unlock()
+		var result interface{}
+		switch req.Method {
+		case "getblockcount":
+			result = height
+		case "getblockhash":
+			var h int64
+			require.NoError(t, json.Unmarshal(req.Params[0], &h))
+			hash := blockHash(h)
+			result = hash.String()
+		case "getblockheader":
+			result = hex.EncodeToString(headerHex.Bytes())
+		case "getrawtransaction":
+			var txid string
+			require.NoError(t, json.Unmarshal(req.Params[0], &txid))
+			// the txid is the number of confirmations
+			confirmations := 0
+			fmt.Sscanf(txid[len(txid)-2:], "%x", &confirmations)
+			result = btcjson.TxRawResult{Txid: txid, Confirmations: uint64(confirmations)}
+		}
+		data, _ := json.Marshal(result)
+		fmt.Fprintf(w, `{"result":%s,"error":null,"id":%s}`, data, req.ID)
+	}))
+	defer server.Close()
+
+	client, err := NewBtcClient(server.URL, "user", "pass", wallet.BtcChainMainNet)
+	require.NoError(t, err)
+	defer client.Shutdown()
+	memory := cache.NewMemory(100)
+	cached := NewCachedBtcClient(client, cache.New(cache.Options{Backend: memory}))
+
+	{ // block hashes, 95 is 6 deep
+		for i := 0; i < 2; i++ {
+			for _, h := range []int64{95, 96} {
+				hash, err := cached.GetBlockHash(h)
+				require.NoError(t, err)
+				require.Equal(t, blockHash(h), *hash)
+			}
+		}
+		require.Equal(t, 3, calls["getblockhash"])
+		require.Equal(t, 1, calls["getblockcount"])
+	}
+
+	{ // headers by hash
+		hash := blockHash(height)
+		for i := 0; i < 2; i++ {
+			cachedHeader, err := cached.GetBlockHeader(&hash)
+			require.NoError(t, err)
+			require.Equal(t, header.BlockHash(), cachedHeader.BlockHash())
+		}
+		require.Equal(t, 1, calls["getblockheader"])
+	}
+
+	{ // transactions
+		final, _ := chainhash.NewHashFromStr("06")
+		pending, _ := chainhash.NewHashFromStr("01")
+		for i := 0; i < 2; i++ {
+			result, err := cached.GetRawTransactionVerbose(final)
+			require.NoError(t, err)
+			require.Equal(t, uint64(6), result.Confirmations)
+			_, err = cached.GetRawTransactionVerbose(pending)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 3, calls["getrawtransaction"])
+	}
+
+	fmt.Println("stats:", cached.Cache().Stats())
+	require.Equal(t, 3, memory.Len())
+	require.Equal(t, cache.Stats{Hits: 3, Misses: 7}, cached.Cache().Stats())
+}
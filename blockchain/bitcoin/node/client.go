@@ -1,16 +1,32 @@
 package node
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
 	"net/url"
 
 	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/rpcclient"
 )
 
+var _ wallet.HistoryChecker = (*BtcClient)(nil)
+
 type BtcClient struct {
-	RpcClient *rpcclient.Client
+	RpcClient   *rpcclient.Client
+	chainParams *chaincfg.Params
+	server      *http.Server // of NewBtcClientWithTransport
 }
 
 func NewBtcClient(URL string, user string, pass string, chainId int) (*BtcClient, error) {
@@ -52,20 +68,93 @@ func NewBtcClient(URL string, user string, pass string, chainId int) (*BtcClient
 		return nil, err
 	}
 
-	return &BtcClient{RpcClient: client}, nil
+	return &BtcClient{RpcClient: client, chainParams: chainParams}, nil
 }
 
-func (this *BtcClient) EstimateFeePerKb() (int64, error) {
-	feeResult, err := this.RpcClient.EstimateSmartFee(6, nil)
+// NewBtcClientWithTransport returns a BtcClient which sends its requests with
+// transport, e.g. a limiter.Guard. rpcclient can't take an
+// http.RoundTripper, so it connects to a local listener which forwards to URL
+// with transport until Shutdown. The listener only serves the client, with
+// random credentials of its own, and the requests carry user and pass, if
+// any, to URL. The errors of transport reach the caller as the text of a 503
+// response.
+func NewBtcClientWithTransport(URL string, user string, pass string, chainId int, transport http.RoundTripper) (*BtcClient, error) {
+	target, err := url.Parse(URL)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, errors.New("transport needs an http(s) url")
+	}
+	target.User = nil
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	proxyUser, proxyPass := "btcclient", hex.EncodeToString(secret)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme, r.Out.URL.Host, r.Out.URL.Path = target.Scheme, target.Host, target.Path
+			r.Out.Host = target.Host
+			r.Out.Header.Del("Authorization")
+			if user != "" || pass != "" {
+				r.Out.SetBasicAuth(user, pass)
+			}
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		},
+	}
+	server := &http.Server{Addr: listener.Addr().String(), Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, gotPass, _ := r.BasicAuth()
+		if subtle.ConstantTimeCompare([]byte(gotUser+":"+gotPass), []byte(proxyUser+":"+proxyPass)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("btc client proxy: %v", err)
+		}
+	}()
+
+	client, err := NewBtcClient("http://"+listener.Addr().String(), proxyUser, proxyPass, chainId)
+	if err != nil {
+		server.Close()
+		return nil, err
 	}
+	client.server = server
+	return client, nil
+}
 
-	if feeResult.FeeRate != nil && *feeResult.FeeRate > 0 {
-		return BtcToSatoshi(*feeResult.FeeRate), nil
-	} else {
-		return 0, errors.New("Fee not available")
+// Shutdown shuts the rpc client down and stops the listener of
+// NewBtcClientWithTransport.
+func (this *BtcClient) Shutdown() {
+	this.RpcClient.Shutdown()
+	if this.server != nil {
+		this.server.Close()
+	}
+}
+
+func (this *BtcClient) ChainParams() *chaincfg.Params {
+	return this.chainParams
+}
+
+// EstimateFeePerKb is the fee rate to confirm within 6 blocks in sat/kvB, see
+// BtcFeeEstimator for other targets and modes.
+func (this *BtcClient) EstimateFeePerKb() (int64, error) {
+	estimate, err := NewBtcFeeEstimator(this).Estimate(6, btcjson.EstimateModeUnset)
+	if err != nil {
+		return 0, err
 	}
+	return estimate.FeePerKb, nil
 }
 
 // https://bitcoincore.org/en/doc/0.21.0/rpc/rawtransactions/sendrawtransaction/
@@ -92,3 +181,64 @@ func (this *BtcClient) SendRawTransaction(signedHex string, allowHighFees bool)
 	}
 	return txid, nil
 }
+
+// HasHistory reports whether address ever received coins. Addresses of the
+// node's wallet are checked with listreceivedbyaddress, which includes spent
+// outputs. bitcoind has no address index, so other addresses are checked with
+// scantxoutset, which only finds unspent outputs.
+func (this *BtcClient) HasHistory(ctx context.Context, address string) (bool, error) {
+	histories, err := this.HasHistories(ctx, []string{address})
+	if err != nil {
+		return false, err
+	}
+	return histories[0], nil
+}
+
+// HasHistories is HasHistory of many addresses with two calls: a single
+// listreceivedbyaddress for the addresses of the node's wallet and a single
+// scantxoutset, which reads the whole utxo set once, for the others.
+func (this *BtcClient) HasHistories(ctx context.Context, addresses []string) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	received, err := this.walletReceived()
+	if err != nil {
+		return nil, err
+	}
+
+	histories := make([]bool, len(addresses))
+	var descriptors []string
+	scanned := make(map[string][]int)
+	for i, address := range addresses {
+		addr, err := btcutil.DecodeAddress(address, this.chainParams)
+		if err != nil {
+			return nil, err
+		}
+		encoded := addr.EncodeAddress()
+		if amount, ok := received[encoded]; ok {
+			histories[i] = amount > 0
+			continue
+		}
+		if _, ok := scanned[encoded]; !ok {
+			descriptors = append(descriptors, fmt.Sprintf("addr(%s)", encoded))
+		}
+		scanned[encoded] = append(scanned[encoded], i)
+	}
+	if len(descriptors) == 0 {
+		return histories, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	utxos, err := this.ScanUnspent(descriptors...)
+	if err != nil {
+		return nil, err
+	}
+	for _, utxo := range utxos {
+		for _, i := range scanned[utxo.Address] {
+			histories[i] = true
+		}
+	}
+	return histories, nil
+}
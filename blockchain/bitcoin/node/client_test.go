@@ -0,0 +1,90 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBtcHasHistories(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+	var addresses []string
+	for i := 0; i < 5; i++ {
+		w, err := hdw.NewNativeSegWitWallet(0, 0, i)
+		require.NoError(t, err)
+		addresses = append(addresses, w.DeriveAddress())
+	}
+	// 0 and 3 are in the node's wallet, 2 and 4 have unspents
+	unspent := map[string]bool{addresses[2]: true, addresses[4]: true}
+
+	chainParams, err := wallet.GetBtcChainParams(wallet.BtcChainRegtest)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	var scanned []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mu.Lock()
+		calls[req.Method]++
+		mu.Unlock()
+		switch req.Method {
+		case "listreceivedbyaddress":
+			require.Equal(t, "true", string(req.Params[2]))
+			fmt.Fprintf(w, `{"result":[{"address":"%s","amount":0.5,"confirmations":3},{"address":"%s","amount":0,"confirmations":0}],"error":null,"id":%s}`,
+				addresses[0], addresses[3], req.ID)
+		case "scantxoutset":
+			require.NoError(t, json.Unmarshal(req.Params[1], &scanned))
+			var unspents []string
+			for _, descriptor := range scanned {
+				address := strings.TrimSuffix(strings.TrimPrefix(descriptor, "addr("), ")")
+				if !unspent[address] {
+					continue
+				}
+				addr, err := btcutil.DecodeAddress(address, chainParams)
+				require.NoError(t, err)
+				pkScript, err := txscript.PayToAddrScript(addr)
+				require.NoError(t, err)
+				unspents = append(unspents, fmt.Sprintf(`{"txid":"%064x","vout":0,"scriptPubKey":"%s","amount":0.1,"height":100}`,
+					len(unspents), hex.EncodeToString(pkScript)))
+			}
+			fmt.Fprintf(w, `{"result":{"success":true,"height":100,"unspents":[%s]},"error":null,"id":%s}`,
+				strings.Join(unspents, ","), req.ID)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewBtcClient(server.URL, "user", "pass", wallet.BtcChainRegtest)
+	require.NoError(t, err)
+	defer client.Shutdown()
+
+	histories, err := client.HasHistories(context.Background(), addresses)
+	require.NoError(t, err)
+	require.Equal(t, []bool{true, false, true, false, true}, histories)
+	require.Equal(t, map[string]int{"listreceivedbyaddress": 1, "scantxoutset": 1}, calls)
+	require.Len(t, scanned, 3)
+	fmt.Println("scanned:", scanned)
+
+	ok, err := client.HasHistory(context.Background(), addresses[1])
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,210 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/bitcoin/tx"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcwallet/wallet/txrules"
+)
+
+const (
+	// MaxBlockVSize is the virtual size of a full block, 4M weight units.
+	MaxBlockVSize = 1000000
+	// MaxConfTarget is the highest confirmation target of estimatesmartfee.
+	MaxConfTarget = 1008
+
+	DefaultMinFeePerKb = int64(txrules.DefaultRelayFeePerKb)
+	DefaultMaxFeePerKb = 500 * 1000
+)
+
+type FeeSource int
+
+const (
+	// FeeSourceNode is the estimatesmartfee of the node.
+	FeeSourceNode FeeSource = iota
+	// FeeSourceMempool is the fee rate to get into the next blocks from the
+	// mempool histogram, when the node has no estimate yet.
+	FeeSourceMempool
+	// FeeSourceFloor and FeeSourceCeiling mean the estimate was clamped to
+	// the configured MinFeePerKb or MaxFeePerKb.
+	FeeSourceFloor
+	FeeSourceCeiling
+)
+
+func (s FeeSource) String() string {
+	switch s {
+	case FeeSourceNode:
+		return "node"
+	case FeeSourceMempool:
+		return "mempool"
+	case FeeSourceFloor:
+		return "floor"
+	case FeeSourceCeiling:
+		return "ceiling"
+	}
+	return "unknown"
+}
+
+type BtcFeeEstimate struct {
+	// FeePerKb is in sat/kvB, the feePerKb of NewBtcTransaction.
+	FeePerKb    int64
+	FeePerVByte float64
+	// Blocks is the confirmation target the estimate is for, the node may
+	// answer for a different target than the requested one.
+	Blocks int64
+	Source FeeSource
+}
+
+// FeeHistogramBucket is the total virtual size of the mempool transactions
+// paying FeePerVByte sat/vB, rounded down.
+type FeeHistogramBucket struct {
+	FeePerVByte int64
+	VSize       int64
+}
+
+type MempoolInfo struct {
+	Size  int64 `json:"size"`
+	Bytes int64 `json:"bytes"`
+	Usage int64 `json:"usage"`
+	// MempoolMinFee and MinRelayTxFee are in BTC/kvB.
+	MempoolMinFee float64 `json:"mempoolminfee"`
+	MinRelayTxFee float64 `json:"minrelaytxfee"`
+}
+
+// MinFeePerKb is the lowest fee rate the mempool accepts, in sat/kvB.
+func (m *MempoolInfo) MinFeePerKb() int64 {
+	return max(tx.BtcToSatoshi(m.MempoolMinFee), tx.BtcToSatoshi(m.MinRelayTxFee))
+}
+
+// https://bitcoincore.org/en/doc/25.0.0/rpc/blockchain/getmempoolinfo/
+func (this *BtcClient) GetMempoolInfo() (*MempoolInfo, error) {
+	resp, err := this.RpcClient.RawRequest("getmempoolinfo", nil)
+	if err != nil {
+		return nil, err
+	}
+	var info MempoolInfo
+	if err = json.Unmarshal(resp, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+type mempoolEntry struct {
+	VSize int64 `json:"vsize"`
+	Fees  struct {
+		Base float64 `json:"base"`
+	} `json:"fees"`
+}
+
+// MempoolFeeHistogram returns the mempool by fee rate, highest first. It
+// reads the whole verbose mempool, which is large on mainnet.
+func (this *BtcClient) MempoolFeeHistogram() ([]FeeHistogramBucket, error) {
+	verbose, _ := json.Marshal(true)
+	resp, err := this.RpcClient.RawRequest("getrawmempool", []json.RawMessage{verbose})
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]mempoolEntry
+	if err = json.Unmarshal(resp, &entries); err != nil {
+		return nil, err
+	}
+	return feeHistogram(entries), nil
+}
+
+func feeHistogram(entries map[string]mempoolEntry) []FeeHistogramBucket {
+	sizes := map[int64]int64{}
+	for _, entry := range entries {
+		if entry.VSize <= 0 {
+			continue
+		}
+		sizes[tx.BtcToSatoshi(entry.Fees.Base)/entry.VSize] += entry.VSize
+	}
+	histogram := make([]FeeHistogramBucket, 0, len(sizes))
+	for feePerVByte, vsize := range sizes {
+		histogram = append(histogram, FeeHistogramBucket{FeePerVByte: feePerVByte, VSize: vsize})
+	}
+	sort.Slice(histogram, func(i, j int) bool { return histogram[i].FeePerVByte > histogram[j].FeePerVByte })
+	return histogram
+}
+
+// estimateFromHistogram returns the fee rate which outbids the mempool
+// transactions beyond confTarget full blocks, or minFeePerKb if they all fit.
+func estimateFromHistogram(histogram []FeeHistogramBucket, confTarget int64, minFeePerKb int64) int64 {
+	capacity := confTarget * MaxBlockVSize
+	var vsize int64
+	for _, bucket := range histogram {
+		vsize += bucket.VSize
+		if vsize >= capacity {
+			return max((bucket.FeePerVByte+1)*1000, minFeePerKb)
+		}
+	}
+	return minFeePerKb
+}
+
+// BtcFeeEstimator estimates fee rates with estimatesmartfee, falling back to
+// the mempool when the node has not seen enough blocks yet, e.g. after a
+// restart or on regtest.
+type BtcFeeEstimator struct {
+	client *BtcClient
+	// MinFeePerKb and MaxFeePerKb bound the estimates, 0 MaxFeePerKb is no
+	// ceiling.
+	MinFeePerKb int64
+	MaxFeePerKb int64
+}
+
+func NewBtcFeeEstimator(client *BtcClient) *BtcFeeEstimator {
+	return &BtcFeeEstimator{client: client, MinFeePerKb: DefaultMinFeePerKb, MaxFeePerKb: DefaultMaxFeePerKb}
+}
+
+// Estimate returns the fee rate to confirm within confTarget blocks. mode is
+// passed to estimatesmartfee, btcjson.EstimateModeUnset is the default of
+// the node.
+func (e *BtcFeeEstimator) Estimate(confTarget int64, mode btcjson.EstimateSmartFeeMode) (*BtcFeeEstimate, error) {
+	if confTarget < 1 || confTarget > MaxConfTarget {
+		return nil, errors.New("confirmation target out of range")
+	}
+	if e.MaxFeePerKb > 0 && e.MaxFeePerKb < e.MinFeePerKb {
+		return nil, errors.New("max fee rate is lower than the min fee rate")
+	}
+
+	var modeParam *btcjson.EstimateSmartFeeMode
+	if mode != "" && mode != btcjson.EstimateModeUnset {
+		modeParam = &mode
+	}
+	result, err := e.client.RpcClient.EstimateSmartFee(confTarget, modeParam)
+	if err != nil {
+		return nil, err
+	}
+
+	estimate := &BtcFeeEstimate{Blocks: confTarget, Source: FeeSourceNode}
+	if result.FeeRate != nil && *result.FeeRate > 0 {
+		estimate.FeePerKb = tx.BtcToSatoshi(*result.FeeRate)
+		if result.Blocks > 0 {
+			estimate.Blocks = result.Blocks
+		}
+	} else {
+		info, err := e.client.GetMempoolInfo()
+		if err != nil {
+			return nil, err
+		}
+		histogram, err := e.client.MempoolFeeHistogram()
+		if err != nil {
+			return nil, err
+		}
+		estimate.FeePerKb = estimateFromHistogram(histogram, confTarget, info.MinFeePerKb())
+		estimate.Source = FeeSourceMempool
+	}
+
+	if estimate.FeePerKb < e.MinFeePerKb {
+		estimate.FeePerKb = e.MinFeePerKb
+		estimate.Source = FeeSourceFloor
+	} else if e.MaxFeePerKb > 0 && estimate.FeePerKb > e.MaxFeePerKb {
+		estimate.FeePerKb = e.MaxFeePerKb
+		estimate.Source = FeeSourceCeiling
+	}
+	estimate.FeePerVByte = float64(estimate.FeePerKb) / 1000
+	return estimate, nil
+}
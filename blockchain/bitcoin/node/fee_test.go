@@ -0,0 +1,38 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeHistogram(t *testing.T) {
+	entry := func(sats float64, vsize int64) mempoolEntry {
+		var e mempoolEntry
+		e.VSize = vsize
+		e.Fees.Base = sats / 1e8
+		return e
+	}
+	histogram := feeHistogram(map[string]mempoolEntry{
+		"a": entry(20*400000, 400000),
+		"b": entry(20.5*200000, 200000),
+		"c": entry(10*900000, 900000),
+		"d": entry(2*1000000, 1000000),
+		"e": entry(100, 0),
+	})
+	fmt.Println("histogram:", histogram)
+	require.Equal(t, []FeeHistogramBucket{
+		{FeePerVByte: 20, VSize: 600000},
+		{FeePerVByte: 10, VSize: 900000},
+		{FeePerVByte: 2, VSize: 1000000},
+	}, histogram)
+
+	// the first block is full within the 10 sat/vB bucket
+	require.Equal(t, int64(11000), estimateFromHistogram(histogram, 1, 1000))
+	require.Equal(t, int64(3000), estimateFromHistogram(histogram, 2, 1000))
+	// everything fits, the mempool minimum
+	require.Equal(t, int64(1000), estimateFromHistogram(histogram, 3, 1000))
+	require.Equal(t, int64(5000), estimateFromHistogram(histogram, 2, 5000))
+	require.Equal(t, int64(1000), estimateFromHistogram(nil, 1, 1000))
+}
@@ -0,0 +1,37 @@
+package node
+
+import (
+	"encoding/json"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/rpcpool"
+)
+
+// BtcHeightProbe is the health check of bitcoind endpoints.
+var BtcHeightProbe = rpcpool.Probe{
+	Method: "getblockcount",
+	ParseHeight: func(result json.RawMessage) (uint64, error) {
+		var height uint64
+		err := json.Unmarshal(result, &height)
+		return height, err
+	},
+}
+
+// NewBtcPool returns a pool of bitcoind endpoints, opts.Probe is
+// BtcHeightProbe.
+func NewBtcPool(endpoints []rpcpool.EndpointConfig, opts rpcpool.Options) (*rpcpool.Pool, error) {
+	opts.Probe = BtcHeightProbe
+	return rpcpool.New(endpoints, opts)
+}
+
+// NewBtcPoolClient returns a BtcClient which sends its requests through pool.
+// rpcclient can't take an http.RoundTripper, so it connects to the local
+// listener of pool.Serve with its credentials. The credentials of the
+// endpoints are in their EndpointConfig.
+func NewBtcPoolClient(pool *rpcpool.Pool, chainId int) (*BtcClient, error) {
+	u, err := pool.Serve()
+	if err != nil {
+		return nil, err
+	}
+	pass, _ := u.User.Password()
+	return NewBtcClient("http://"+u.Host, u.User.Username(), pass, chainId)
+}
@@ -0,0 +1,104 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/limiter"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/rpcpool"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBtcPool(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	var path atomic.Value
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "getblockcount", req.Method)
+		path.Store(r.URL.Path)
+		fmt.Fprintf(w, `{"result":840000,"error":null,"id":%s}`, req.ID)
+	}))
+	defer up.Close()
+
+	pool, err := NewBtcPool([]rpcpool.EndpointConfig{{URL: down.URL}, {URL: up.URL, User: "user", Pass: "pass"}}, rpcpool.Options{})
+	require.NoError(t, err)
+	defer pool.Close()
+	// the probe authenticates too
+	pool.CheckHealth(context.Background())
+	status := pool.Status()
+	require.False(t, status[0].Healthy)
+	require.True(t, status[1].Healthy)
+	require.Equal(t, uint64(840000), status[1].Height)
+
+	client, err := NewBtcPoolClient(pool, wallet.BtcChainMainNet)
+	require.NoError(t, err)
+	defer client.RpcClient.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		height, err := client.RpcClient.GetBlockCount()
+		require.NoError(t, err)
+		require.Equal(t, int64(840000), height)
+	}
+	require.False(t, pool.Status()[0].Healthy)
+
+	{ // a guard
+		guard := limiter.NewGuard(limiter.GuardOptions{
+			Methods:  map[string]limiter.Limiter{"getblockcount": limiter.NewTokenBucket(1, 1)},
+			FailFast: true,
+			Breaker:  limiter.NewBreaker("btc", limiter.BreakerOptions{MaxFailures: 1}),
+		})
+		client, err := NewBtcClientWithTransport(up.URL+"/wallet/test", "user", "pass", wallet.BtcChainMainNet, guard)
+		require.NoError(t, err)
+		defer client.Shutdown()
+		height, err := client.RpcClient.GetBlockCount()
+		require.NoError(t, err)
+		require.Equal(t, int64(840000), height)
+		require.Equal(t, "/wallet/test", path.Load())
+		_, err = client.RpcClient.GetBlockCount()
+		require.ErrorContains(t, err, limiter.ErrLimited.Error())
+
+		_, err = NewBtcClientWithTransport("ws://localhost", "user", "pass", wallet.BtcChainMainNet, guard)
+		require.Error(t, err)
+
+		// a transport with the node credentials, the proxy takes its own
+		authGuard := limiter.NewGuard(limiter.GuardOptions{Transport: basicAuthTransport{"user", "pass"}})
+		client, err = NewBtcClientWithTransport(up.URL, "", "", wallet.BtcChainMainNet, authGuard)
+		require.NoError(t, err)
+		height, err = client.RpcClient.GetBlockCount()
+		require.NoError(t, err)
+		require.Equal(t, int64(840000), height)
+		resp, err := http.Post("http://"+client.server.Addr, "application/json",
+			strings.NewReader(`{"jsonrpc":"1.0","id":1,"method":"getblockcount","params":[]}`))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		client.Shutdown()
+	}
+}
+
+type basicAuthTransport struct {
+	user, pass string
+}
+
+func (b basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.SetBasicAuth(b.user, b.pass)
+	return http.DefaultTransport.RoundTrip(req)
+}
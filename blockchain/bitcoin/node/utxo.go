@@ -0,0 +1,220 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/bitcoin/tx"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// maxConfirmations is the default maxconf of listunspent.
+const maxConfirmations = 9999999
+
+type BtcUtxo struct {
+	tx.BtcUnspent
+	Address       string `json:"address"`
+	Confirmations int64  `json:"confirmations"`
+}
+
+type BtcBalance struct {
+	// Confirmed and Unconfirmed are in satoshi.
+	Confirmed   int64
+	Unconfirmed int64
+}
+
+func (b *BtcBalance) Total() int64 {
+	return b.Confirmed + b.Unconfirmed
+}
+
+// ToUnspents returns the inputs of tx.NewBtcTransaction.
+func ToUnspents(utxos []BtcUtxo) []tx.BtcUnspent {
+	unspents := make([]tx.BtcUnspent, 0, len(utxos))
+	for _, utxo := range utxos {
+		unspents = append(unspents, utxo.BtcUnspent)
+	}
+	return unspents
+}
+
+// ListUnspentResultToUnspent converts an unspent of the listunspent of
+// rpcclient.
+func ListUnspentResultToUnspent(result btcjson.ListUnspentResult) tx.BtcUnspent {
+	return tx.BtcUnspent{TxID: result.TxID, Vout: result.Vout,
+		ScriptPubKey: result.ScriptPubKey, RedeemScript: result.RedeemScript,
+		Amount: result.Amount}
+}
+
+// ListUnspent returns the unspents of addresses with at least minConf
+// confirmations, ordered by amount, largest first. Addresses of the node's
+// wallet, including watch-only ones, are listed with listunspent. Other
+// addresses are scanned with scantxoutset, so no keys or descriptors have to
+// be imported into bitcoind, but the scan only sees confirmed unspents
+// without their redeem scripts.
+func (this *BtcClient) ListUnspent(addresses []btcutil.Address, minConf int) ([]BtcUtxo, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no addresses")
+	}
+	received, err := this.walletReceived()
+	if err != nil {
+		return nil, err
+	}
+	var walletAddrs, scanAddrs []btcutil.Address
+	for _, addr := range addresses {
+		if !addr.IsForNet(this.chainParams) {
+			return nil, fmt.Errorf("address %s is not of %s", addr, this.chainParams.Name)
+		}
+		if _, mine := received[addr.EncodeAddress()]; mine {
+			walletAddrs = append(walletAddrs, addr)
+		} else {
+			scanAddrs = append(scanAddrs, addr)
+		}
+	}
+
+	var utxos []BtcUtxo
+	if len(walletAddrs) > 0 {
+		results, err := this.RpcClient.ListUnspentMinMaxAddresses(minConf, maxConfirmations, walletAddrs)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			utxos = append(utxos, BtcUtxo{BtcUnspent: ListUnspentResultToUnspent(result),
+				Address: result.Address, Confirmations: result.Confirmations})
+		}
+	}
+	if len(scanAddrs) > 0 {
+		descriptors := make([]string, 0, len(scanAddrs))
+		for _, addr := range scanAddrs {
+			descriptors = append(descriptors, fmt.Sprintf("addr(%s)", addr.EncodeAddress()))
+		}
+		scanned, err := this.ScanUnspent(descriptors...)
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range scanned {
+			if utxo.Confirmations >= int64(minConf) {
+				utxos = append(utxos, utxo)
+			}
+		}
+	}
+
+	sort.SliceStable(utxos, func(i, j int) bool { return utxos[i].Amount > utxos[j].Amount })
+	return utxos, nil
+}
+
+// walletReceived returns the amounts received by the addresses of the node's
+// wallet, watch-only and empty ones included, with one listreceivedbyaddress.
+// It's empty if the node has no wallet.
+func (this *BtcClient) walletReceived() (map[string]float64, error) {
+	minConf, _ := json.Marshal(0)
+	includeEmpty, _ := json.Marshal(true)
+	resp, err := this.RpcClient.RawRequest("listreceivedbyaddress", []json.RawMessage{minConf, includeEmpty, includeEmpty})
+	if err != nil {
+		var rpcErr *btcjson.RPCError
+		if errors.As(err, &rpcErr) {
+			return map[string]float64{}, nil
+		}
+		return nil, err
+	}
+	var results []btcjson.ListReceivedByAddressResult
+	if err := json.Unmarshal(resp, &results); err != nil {
+		return nil, err
+	}
+	received := make(map[string]float64, len(results))
+	for _, result := range results {
+		received[result.Address] = result.Amount
+	}
+	return received, nil
+}
+
+// ScanUnspent returns the confirmed unspents matching the output descriptors,
+// e.g. "addr(<address>)" or "wpkh(<xpub>/0/*)", with scantxoutset. Ranged
+// descriptors are scanned up to index 1000.
+// https://bitcoincore.org/en/doc/25.0.0/rpc/blockchain/scantxoutset/
+func (this *BtcClient) ScanUnspent(descriptors ...string) ([]BtcUtxo, error) {
+	action, _ := json.Marshal("start")
+	scanObjects, _ := json.Marshal(descriptors)
+	resp, err := this.RpcClient.RawRequest("scantxoutset", []json.RawMessage{action, scanObjects})
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Success  bool  `json:"success"`
+		Height   int64 `json:"height"`
+		Unspents []struct {
+			TxID         string  `json:"txid"`
+			Vout         uint32  `json:"vout"`
+			ScriptPubKey string  `json:"scriptPubKey"`
+			Amount       float64 `json:"amount"`
+			Height       int64   `json:"height"`
+		} `json:"unspents"`
+	}
+	if err = json.Unmarshal(resp, &result); err != nil {
+		return nil, err
+	}
+	if !result.Success {
+		return nil, errors.New("scantxoutset failed")
+	}
+
+	utxos := make([]BtcUtxo, 0, len(result.Unspents))
+	for _, unspent := range result.Unspents {
+		utxo := BtcUtxo{
+			BtcUnspent: tx.BtcUnspent{TxID: unspent.TxID, Vout: unspent.Vout,
+				ScriptPubKey: unspent.ScriptPubKey, Amount: unspent.Amount},
+			Confirmations: result.Height - unspent.Height + 1,
+		}
+		if script, err := hex.DecodeString(unspent.ScriptPubKey); err == nil {
+			_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, this.chainParams)
+			if err == nil && len(addrs) == 1 {
+				utxo.Address = addrs[0].EncodeAddress()
+			}
+		}
+		utxos = append(utxos, utxo)
+	}
+	return utxos, nil
+}
+
+// GetAddressBalance sums the unspents of addresses, unconfirmed ones are only
+// seen for addresses of the node's wallet.
+func (this *BtcClient) GetAddressBalance(addresses []btcutil.Address) (*BtcBalance, error) {
+	utxos, err := this.ListUnspent(addresses, 0)
+	if err != nil {
+		return nil, err
+	}
+	var balance BtcBalance
+	for _, utxo := range utxos {
+		if utxo.Confirmations > 0 {
+			balance.Confirmed += tx.BtcToSatoshi(utxo.Amount)
+		} else {
+			balance.Unconfirmed += tx.BtcToSatoshi(utxo.Amount)
+		}
+	}
+	return &balance, nil
+}
+
+// ToPsbt exports btcTx with the previous transactions of its P2PKH and P2SH
+// inputs, which are read with getrawtransaction, so the node needs -txindex
+// for confirmed ones outside its wallet.
+func (this *BtcClient) ToPsbt(btcTx *tx.BtcTransaction) (*tx.BtcPsbt, error) {
+	var prevTxs []*wire.MsgTx
+	seen := make(map[chainhash.Hash]bool)
+	for i, in := range btcTx.Tx.TxIn {
+		hash := in.PreviousOutPoint.Hash
+		if i >= len(btcTx.PrevScripts) || !tx.NeedsPrevTx(btcTx.PrevScripts[i]) || seen[hash] {
+			continue
+		}
+		prevTx, err := this.RpcClient.GetRawTransaction(&hash)
+		if err != nil {
+			return nil, fmt.Errorf("previous transaction of input %d: %w", i, err)
+		}
+		seen[hash] = true
+		prevTxs = append(prevTxs, prevTx.MsgTx())
+	}
+	return btcTx.ToPsbt(prevTxs...)
+}
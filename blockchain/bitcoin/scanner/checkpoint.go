@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type BlockRef struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// Checkpoint is the last scanned blocks, oldest first. The blocks before the
+// last one are kept to roll back a reorg.
+type Checkpoint struct {
+	Blocks []BlockRef `json:"blocks"`
+}
+
+// Last returns the last scanned block, nil if none.
+func (c *Checkpoint) Last() *BlockRef {
+	if len(c.Blocks) == 0 {
+		return nil
+	}
+	return &c.Blocks[len(c.Blocks)-1]
+}
+
+// CheckpointStore persists the checkpoint of a Scanner, Load returns nil if
+// nothing was saved yet.
+type CheckpointStore interface {
+	Load() (*Checkpoint, error)
+	Save(checkpoint *Checkpoint) error
+}
+
+type MemoryStore struct {
+	mu         sync.Mutex
+	checkpoint *Checkpoint
+}
+
+func (m *MemoryStore) Load() (*Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.checkpoint == nil {
+		return nil, nil
+	}
+	return &Checkpoint{Blocks: append([]BlockRef(nil), m.checkpoint.Blocks...)}, nil
+}
+
+func (m *MemoryStore) Save(checkpoint *Checkpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkpoint = &Checkpoint{Blocks: append([]BlockRef(nil), checkpoint.Blocks...)}
+	return nil
+}
+
+// FileStore keeps the checkpoint in a json file.
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (f *FileStore) Load() (*Checkpoint, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err = json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Save replaces the file by renaming a temporary file, so a crash never
+// leaves a partial checkpoint.
+func (f *FileStore) Save(checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
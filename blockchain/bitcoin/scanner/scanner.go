@@ -0,0 +1,349 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/bitcoin/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/bitcoin/tx"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var ErrReorgTooDeep = errors.New("reorg is deeper than the kept blocks")
+
+type DepositState int
+
+const (
+	DepositDetected DepositState = iota
+	// DepositReverted is a deposit of a block which was reorged out, the
+	// transaction may be detected again in another block.
+	DepositReverted
+)
+
+func (s DepositState) String() string {
+	switch s {
+	case DepositDetected:
+		return "detected"
+	case DepositReverted:
+		return "reverted"
+	}
+	return "unknown"
+}
+
+type Deposit struct {
+	TxID    string
+	Vout    uint32
+	Address string
+	// Amount is in satoshi.
+	Amount      int64
+	BlockHeight int64
+	BlockHash   string
+}
+
+type DepositEvent struct {
+	State DepositState
+	Deposit
+}
+
+const (
+	DefaultReorgDepth   = 100
+	DefaultPollInterval = 30 * time.Second
+)
+
+type ScannerOptions struct {
+	// StartHeight is the first block to scan when the store has no
+	// checkpoint.
+	StartHeight int64
+	// ReorgDepth is the number of scanned blocks kept to roll back.
+	ReorgDepth   int
+	PollInterval time.Duration
+	// OnEvent receives the events if set, otherwise they are sent to Events.
+	OnEvent func(DepositEvent)
+	// OnError receives the errors of the polls of Run, they're logged if nil.
+	OnError func(error)
+}
+
+// BlockSource is the part of rpcclient.Client the Scanner reads.
+type BlockSource interface {
+	GetBlockCount() (int64, error)
+	GetBlockHash(blockHeight int64) (*chainhash.Hash, error)
+	GetBlock(blockHash *chainhash.Hash) (*wire.MsgBlock, error)
+}
+
+// Scanner walks the blocks from the checkpoint of its store and emits the
+// outputs paying to the watched addresses. When the parent of the next block
+// is not the last scanned block, the last block is rolled back, its deposits
+// are reverted, and the blocks of the new chain are scanned again. Events are
+// emitted before the checkpoint is saved, so after a crash a block may be
+// emitted twice, deposits are identified by TxID and Vout.
+type Scanner struct {
+	source      BlockSource
+	chainParams *chaincfg.Params
+	store       CheckpointStore
+	opts        ScannerOptions
+	events      chan DepositEvent
+
+	// scanMu serializes Poll
+	scanMu     sync.Mutex
+	checkpoint *Checkpoint
+
+	mu      sync.Mutex
+	watched map[string]struct{}
+}
+
+func NewScanner(client *node.BtcClient, store CheckpointStore, opts *ScannerOptions) *Scanner {
+	return newScanner(client.RpcClient, client.ChainParams(), store, opts)
+}
+
+func newScanner(source BlockSource, chainParams *chaincfg.Params, store CheckpointStore, opts *ScannerOptions) *Scanner {
+	s := &Scanner{source: source, chainParams: chainParams, store: store, watched: make(map[string]struct{})}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.ReorgDepth <= 0 {
+		s.opts.ReorgDepth = DefaultReorgDepth
+	}
+	if s.opts.PollInterval <= 0 {
+		s.opts.PollInterval = DefaultPollInterval
+	}
+	if s.opts.OnEvent == nil {
+		s.events = make(chan DepositEvent, 64)
+	}
+	if s.opts.OnError == nil {
+		s.opts.OnError = func(err error) { log.Printf("btc scanner: %v", err) }
+	}
+	return s
+}
+
+// Events returns the event channel, nil with OnEvent. The channel must be
+// drained or Poll blocks until its ctx is done.
+func (s *Scanner) Events() <-chan DepositEvent {
+	return s.events
+}
+
+// Watch watches addresses in any case, they're stored in the encoding of the
+// outputs, e.g. bech32 in lower case.
+func (s *Scanner) Watch(addresses ...string) error {
+	encoded := make([]string, len(addresses))
+	for i, address := range addresses {
+		addr, err := btcutil.DecodeAddress(address, s.chainParams)
+		if err != nil {
+			return fmt.Errorf("invalid address %s: %w", address, err)
+		}
+		encoded[i] = addr.EncodeAddress()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, address := range encoded {
+		s.watched[address] = struct{}{}
+	}
+	return nil
+}
+
+// WatchWallets watches the addresses of HDWallet derived wallets.
+func (s *Scanner) WatchWallets(wallets ...*wallet.BtcWallet) error {
+	addresses := make([]string, 0, len(wallets))
+	for _, w := range wallets {
+		addresses = append(addresses, w.DeriveAddress())
+	}
+	return s.Watch(addresses...)
+}
+
+func (s *Scanner) Unwatch(addresses ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, address := range addresses {
+		if addr, err := btcutil.DecodeAddress(address, s.chainParams); err == nil {
+			address = addr.EncodeAddress()
+		}
+		delete(s.watched, address)
+	}
+}
+
+// Height returns the height of the last scanned block, StartHeight-1 if none.
+func (s *Scanner) Height() (int64, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	return s.nextHeight() - 1, nil
+}
+
+// Run polls every PollInterval until ctx is done or the reorg is too deep,
+// the other errors of the polls go to OnError.
+func (s *Scanner) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Poll(ctx); errors.Is(err, ErrReorgTooDeep) {
+			return err
+		} else if err != nil && ctx.Err() == nil {
+			s.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll scans the blocks up to the tip of the node.
+func (s *Scanner) Poll(ctx context.Context) error {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	tip, err := s.source.GetBlockCount()
+	if err != nil {
+		return err
+	}
+	// the new chain is shorter than the scanned one
+	for last := s.checkpoint.Last(); last != nil && last.Height > tip; last = s.checkpoint.Last() {
+		if err = s.rollback(ctx); err != nil {
+			return err
+		}
+	}
+
+	for height := s.nextHeight(); height <= tip; height = s.nextHeight() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		hash, err := s.source.GetBlockHash(height)
+		if err != nil {
+			return err
+		}
+		block, err := s.source.GetBlock(hash)
+		if err != nil {
+			return err
+		}
+
+		if last := s.checkpoint.Last(); last != nil && block.Header.PrevBlock.String() != last.Hash {
+			if err = s.rollback(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, event := range s.deposits(block, height, DepositDetected) {
+			if err = s.emit(ctx, event); err != nil {
+				return err
+			}
+		}
+		s.checkpoint.Blocks = append(s.checkpoint.Blocks, BlockRef{Height: height, Hash: hash.String()})
+		if len(s.checkpoint.Blocks) > s.opts.ReorgDepth {
+			s.checkpoint.Blocks = s.checkpoint.Blocks[len(s.checkpoint.Blocks)-s.opts.ReorgDepth:]
+		}
+		if err = s.store.Save(s.checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) load() error {
+	if s.checkpoint != nil {
+		return nil
+	}
+	checkpoint, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{}
+	}
+	s.checkpoint = checkpoint
+	return nil
+}
+
+func (s *Scanner) nextHeight() int64 {
+	if last := s.checkpoint.Last(); last != nil {
+		return last.Height + 1
+	}
+	return s.opts.StartHeight
+}
+
+// rollback reverts the deposits of the last scanned block, which the node
+// still has although it's no longer in the main chain. The parent of the
+// oldest kept block is unknown, so it is only rolled back if it's the first
+// block scanned.
+func (s *Scanner) rollback(ctx context.Context) error {
+	last := s.checkpoint.Last()
+	if last == nil || (len(s.checkpoint.Blocks) == 1 && last.Height != s.opts.StartHeight) {
+		return ErrReorgTooDeep
+	}
+	hash, err := chainhash.NewHashFromStr(last.Hash)
+	if err != nil {
+		return err
+	}
+	block, err := s.source.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	for _, event := range s.deposits(block, last.Height, DepositReverted) {
+		if err = s.emit(ctx, event); err != nil {
+			return err
+		}
+	}
+	s.checkpoint.Blocks = s.checkpoint.Blocks[:len(s.checkpoint.Blocks)-1]
+	return s.store.Save(s.checkpoint)
+}
+
+func (s *Scanner) deposits(block *wire.MsgBlock, height int64, state DepositState) []DepositEvent {
+	s.mu.Lock()
+	watched := make(map[string]struct{}, len(s.watched))
+	for address := range s.watched {
+		watched[address] = struct{}{}
+	}
+	s.mu.Unlock()
+	// an empty filter passes every output
+	if len(watched) == 0 {
+		return nil
+	}
+
+	blockHash := block.BlockHash().String()
+	var events []DepositEvent
+	for _, msgTx := range block.Transactions {
+		for _, vout := range tx.FilterVouts(msgTx, s.chainParams, watched) {
+			for _, address := range vout.ScriptPubKey.Addresses {
+				if _, ok := watched[address]; !ok {
+					continue
+				}
+				events = append(events, DepositEvent{State: state, Deposit: Deposit{
+					TxID:        msgTx.TxHash().String(),
+					Vout:        vout.N,
+					Address:     address,
+					Amount:      msgTx.TxOut[vout.N].Value,
+					BlockHeight: height,
+					BlockHash:   blockHash,
+				}})
+				break
+			}
+		}
+	}
+	return events
+}
+
+func (s *Scanner) emit(ctx context.Context, event DepositEvent) error {
+	if s.opts.OnEvent != nil {
+		s.opts.OnEvent(event)
+		return nil
+	}
+	select {
+	case s.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
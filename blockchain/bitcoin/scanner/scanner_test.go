@@ -0,0 +1,212 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeChain is a main chain of blocks, the reorged out blocks are still
+// returned by GetBlock like bitcoind does.
+type fakeChain struct {
+	main   []*wire.MsgBlock
+	blocks map[chainhash.Hash]*wire.MsgBlock
+	// down fails GetBlockCount
+	down bool
+}
+
+func newFakeChain() *fakeChain {
+	genesis := wire.NewMsgBlock(&wire.BlockHeader{})
+	c := &fakeChain{blocks: map[chainhash.Hash]*wire.MsgBlock{}}
+	c.main = append(c.main, genesis)
+	c.blocks[genesis.BlockHash()] = genesis
+	return c
+}
+
+// mine adds a block on top of height, dropping the blocks above it. nonce
+// makes the hash of a block of the new chain different.
+func (c *fakeChain) mine(height int, nonce uint32, txs ...*wire.MsgTx) *wire.MsgBlock {
+	block := wire.NewMsgBlock(&wire.BlockHeader{PrevBlock: c.main[height].BlockHash(), Nonce: nonce})
+	for _, msgTx := range txs {
+		block.AddTransaction(msgTx)
+	}
+	c.main = append(c.main[:height+1], block)
+	c.blocks[block.BlockHash()] = block
+	return block
+}
+
+func (c *fakeChain) GetBlockCount() (int64, error) {
+	if c.down {
+		return 0, errors.New("node is down")
+	}
+	return int64(len(c.main) - 1), nil
+}
+
+func (c *fakeChain) GetBlockHash(blockHeight int64) (*chainhash.Hash, error) {
+	if blockHeight < 0 || blockHeight >= int64(len(c.main)) {
+		return nil, errors.New("block height out of range")
+	}
+	hash := c.main[blockHeight].BlockHash()
+	return &hash, nil
+}
+
+func (c *fakeChain) GetBlock(blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
+	block, ok := c.blocks[*blockHash]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return block, nil
+}
+
+func payTo(t *testing.T, address string, amounts ...int64) *wire.MsgTx {
+	addr, err := btcutil.DecodeAddress(address, &chaincfg.RegressionNetParams)
+	require.NoError(t, err)
+	script, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(len(amounts))}, nil, nil))
+	for _, amount := range amounts {
+		msgTx.AddTxOut(wire.NewTxOut(amount, script))
+	}
+	return msgTx
+}
+
+func TestScanner(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewSegWitWallet(0, 0, 1)
+	require.NoError(t, err)
+	other, err := hdw.NewNativeSegWitWallet(0, 0, 2)
+	require.NoError(t, err)
+	a0, a1 := w0.DeriveAddress(), w1.DeriveAddress()
+
+	chain := newFakeChain()
+	store := NewFileStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	var events []DepositEvent
+	opts := &ScannerOptions{StartHeight: 1, ReorgDepth: 3, OnEvent: func(event DepositEvent) {
+		fmt.Println("event:", event.State, event.TxID, event.Vout, event.Address, event.Amount, event.BlockHeight)
+		events = append(events, event)
+	}}
+	s := newScanner(chain, &chaincfg.RegressionNetParams, store, opts)
+	require.Error(t, s.Watch("not an address"))
+	require.NoError(t, s.WatchWallets(w0.(*wallet.BtcWallet), w1.(*wallet.BtcWallet)))
+	ctx := context.Background()
+
+	tx1 := payTo(t, a0, 1000, 2000)
+	chain.mine(0, 0, tx1)
+	chain.mine(1, 0, payTo(t, other.DeriveAddress(), 3000))
+	tx3 := payTo(t, a1, 4000)
+	b3 := chain.mine(2, 0, tx3)
+	require.NoError(t, s.Poll(ctx))
+	require.Len(t, events, 3)
+	require.Equal(t, Deposit{TxID: tx1.TxHash().String(), Vout: 1, Address: a0, Amount: 2000,
+		BlockHeight: 1, BlockHash: chain.main[1].BlockHash().String()}, events[1].Deposit)
+	require.Equal(t, DepositDetected, events[2].State)
+	require.Equal(t, a1, events[2].Address)
+	height, err := s.Height()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), height)
+
+	// nothing new
+	events = nil
+	require.NoError(t, s.Poll(ctx))
+	require.Empty(t, events)
+
+	// block 3 is replaced by 2 blocks, tx3 is mined again in block 4
+	chain.mine(2, 1)
+	chain.mine(3, 1, tx3)
+	require.NoError(t, s.Poll(ctx))
+	require.Len(t, events, 2)
+	require.Equal(t, DepositReverted, events[0].State)
+	require.Equal(t, b3.BlockHash().String(), events[0].BlockHash)
+	require.Equal(t, DepositDetected, events[1].State)
+	require.Equal(t, int64(4), events[1].BlockHeight)
+
+	// a new scanner continues from the saved checkpoint
+	checkpoint, err := store.Load()
+	require.NoError(t, err)
+	require.Len(t, checkpoint.Blocks, 3)
+	require.Equal(t, int64(4), checkpoint.Last().Height)
+	events = nil
+	s = newScanner(chain, &chaincfg.RegressionNetParams, store, opts)
+	// bech32 in upper case, as in qr codes
+	require.NoError(t, s.Watch(strings.ToUpper(a0)))
+	chain.mine(4, 0, payTo(t, a0, 5000))
+	require.NoError(t, s.Poll(ctx))
+	require.Len(t, events, 1)
+	require.Equal(t, int64(5), events[0].BlockHeight)
+
+	// the kept blocks are 3 to 5
+	chain.mine(1, 2)
+	chain.mine(2, 2)
+	chain.mine(3, 2)
+	chain.mine(4, 2)
+	chain.mine(5, 2)
+	chain.mine(6, 2)
+	require.ErrorIs(t, s.Poll(ctx), ErrReorgTooDeep)
+}
+
+func TestScannerRun(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainRegtest, wallet.ChainMainNet)
+	require.NoError(t, err)
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+
+	// more deposits than the buffer of the events
+	amounts := make([]int64, 100)
+	for i := range amounts {
+		amounts[i] = int64(1000 + i)
+	}
+	chain := newFakeChain()
+	chain.mine(0, 0, payTo(t, w0.DeriveAddress(), amounts...))
+
+	s := newScanner(chain, &chaincfg.RegressionNetParams, NewFileStore(filepath.Join(t.TempDir(), "checkpoint.json")), &ScannerOptions{StartHeight: 1})
+	require.NoError(t, s.Watch(w0.DeriveAddress()))
+	// nobody drains the events, the poll gives up with its ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Poll(ctx), context.DeadlineExceeded)
+	height, err := s.Height()
+	require.NoError(t, err)
+	require.Equal(t, int64(0), height)
+	for len(s.Events()) > 0 {
+		<-s.Events()
+	}
+
+	// the errors of the polls are reported and Run goes on
+	chain.down = true
+	errs := make(chan error, 1)
+	s = newScanner(chain, &chaincfg.RegressionNetParams, NewFileStore(filepath.Join(t.TempDir(), "checkpoint.json")), &ScannerOptions{StartHeight: 1,
+		PollInterval: 10 * time.Millisecond, OnEvent: func(DepositEvent) {},
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}})
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	require.ErrorContains(t, <-errs, "node is down")
+	<-errs
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
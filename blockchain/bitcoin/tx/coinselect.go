@@ -0,0 +1,506 @@
+package tx
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/btcsuite/btcwallet/wallet/txrules"
+	"github.com/btcsuite/btcwallet/wallet/txsizes"
+)
+
+type CoinSelectionStrategy int
+
+const (
+	// CoinSelectionInOrder spends the unspents in the given order, it is the
+	// default strategy.
+	CoinSelectionInOrder CoinSelectionStrategy = iota
+	// CoinSelectionBranchAndBound searches for a set of unspents which pays
+	// the outputs without a change output.
+	CoinSelectionBranchAndBound
+	CoinSelectionLargestFirst
+	// CoinSelectionSmallestFirst spends the small unspents first, which
+	// consolidates the utxo set while the fee is low.
+	CoinSelectionSmallestFirst
+	// CoinSelectionRandomImprove picks random unspents, then keeps adding
+	// random unspents while the change moves closer to the payment amount.
+	CoinSelectionRandomImprove
+	// CoinSelectionPrivacy never mixes unspents of different address types
+	// in one transaction.
+	CoinSelectionPrivacy
+)
+
+// DefaultLongTermFeePerKb is the fee rate assumed for spending an utxo later.
+const DefaultLongTermFeePerKb = 10 * 1000
+
+const bnbMaxTries = 100000
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNoExactMatch      = errors.New("no exact match found")
+)
+
+func (s CoinSelectionStrategy) String() string {
+	switch s {
+	case CoinSelectionInOrder:
+		return "in-order"
+	case CoinSelectionBranchAndBound:
+		return "branch-and-bound"
+	case CoinSelectionLargestFirst:
+		return "largest-first"
+	case CoinSelectionSmallestFirst:
+		return "smallest-first"
+	case CoinSelectionRandomImprove:
+		return "random-improve"
+	case CoinSelectionPrivacy:
+		return "privacy"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// CoinSelection is the result of a coin selection strategy.
+//
+// Waste is the metric Bitcoin Core uses to compare selections: the extra fee
+// paid for the inputs now compared to the long term fee rate, plus the cost of
+// creating and later spending the change output, or the excess given to the
+// miners when there is no change. Lower is better.
+type CoinSelection struct {
+	Strategy CoinSelectionStrategy
+	Unspents []BtcUnspent
+	Total    int64
+	Fee      int64
+	Change   int64
+	Waste    int64
+}
+
+type coinCandidate struct {
+	unspent     BtcUnspent
+	outPoint    wire.OutPoint
+	pkScript    []byte
+	value       btcutil.Amount
+	scripts     inputScripts
+	inputSize   int
+	effective   btcutil.Amount
+	scriptClass txscript.ScriptClass
+}
+
+type coinSelector struct {
+	candidates   []*coinCandidate
+	txOuts       []*wire.TxOut
+	changeScript []byte
+	target       btcutil.Amount
+	baseFee      btcutil.Amount
+	costOfChange btcutil.Amount
+	feeRate      btcutil.Amount
+	longTermRate btcutil.Amount
+}
+
+// SelectCoins runs the strategy over unspents to pay outputs, the selection is
+// not bound to a transaction, so strategies can be compared by their waste.
+func SelectCoins(strategy CoinSelectionStrategy, unspents []BtcUnspent, outputs []BtcOutput,
+	changeAddress btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params, opts *BtcTxOptions) (*CoinSelection, error) {
+
+	if changeAddress == nil || feePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	if len(outputs) == 0 {
+		return nil, errors.New("tx output is empty")
+	}
+	changeScript, err := txscript.PayToAddrScript(changeAddress)
+	if err != nil {
+		return nil, err
+	}
+	txOuts, err := makeTxOutputs(outputs, btcutil.Amount(feePerKb), chainCfg)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newCoinSelector(unspents, txOuts, changeScript, feePerKb, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.selectCoins(strategy)
+}
+
+// SelectCheapestCoins runs every strategy and returns the selection with the
+// lowest waste.
+func SelectCheapestCoins(strategies []CoinSelectionStrategy, unspents []BtcUnspent, outputs []BtcOutput,
+	changeAddress btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params, opts *BtcTxOptions) (*CoinSelection, error) {
+
+	var best *CoinSelection
+	var lastErr error = ErrInsufficientFunds
+	for _, strategy := range strategies {
+		selection, err := SelectCoins(strategy, unspents, outputs, changeAddress, feePerKb, chainCfg, opts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if best == nil || selection.Waste < best.Waste {
+			best = selection
+		}
+	}
+	if best == nil {
+		return nil, lastErr
+	}
+	return best, nil
+}
+
+func newCoinSelector(unspents []BtcUnspent, txOuts []*wire.TxOut, changeScript []byte,
+	feePerKb int64, opts *BtcTxOptions) (*coinSelector, error) {
+
+	longTermFeePerKb := int64(DefaultLongTermFeePerKb)
+	if opts != nil && opts.LongTermFeePerKb > 0 {
+		longTermFeePerKb = opts.LongTermFeePerKb
+	}
+
+	s := &coinSelector{
+		txOuts:       txOuts,
+		changeScript: changeScript,
+		target:       txauthor.SumOutputValues(txOuts),
+		feeRate:      btcutil.Amount(feePerKb),
+		longTermRate: btcutil.Amount(longTermFeePerKb),
+	}
+
+	// one more vbyte for the segwit marker and flag
+	s.baseFee = s.fee(txsizes.EstimateVirtualSize(0, 0, 0, 0, txOuts, 0) + 1)
+	changeOutputSize := 8 + wire.VarIntSerializeSize(uint64(len(changeScript))) + len(changeScript)
+	s.costOfChange = s.fee(changeOutputSize) +
+		txrules.FeeForSerializeSize(s.longTermRate, txsizes.GetMinInputVirtualSize(changeScript))
+
+	for _, u := range unspents {
+		c, err := newCoinCandidate(u)
+		if err != nil {
+			return nil, err
+		}
+		c.effective = c.value - s.fee(c.inputSize)
+		s.candidates = append(s.candidates, c)
+	}
+	return s, nil
+}
+
+func newCoinCandidate(u BtcUnspent) (*coinCandidate, error) {
+	hash, err := chainhash.NewHashFromStr(u.TxID)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := hex.DecodeString(u.ScriptPubKey)
+	if err != nil {
+		return nil, err
+	}
+	value, err := btcutil.NewAmount(u.Amount)
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := unspentInputScripts(u)
+	if err != nil {
+		return nil, err
+	}
+	return &coinCandidate{
+		unspent:     u,
+		outPoint:    wire.OutPoint{Hash: *hash, Index: u.Vout},
+		pkScript:    pkScript,
+		value:       value,
+		scripts:     scripts,
+		inputSize:   inputVirtualSize(pkScript, scripts),
+		scriptClass: txscript.GetScriptClass(pkScript),
+	}, nil
+}
+
+func (s *coinSelector) fee(size int) btcutil.Amount {
+	return s.feeRate * btcutil.Amount(size) / 1000
+}
+
+func (s *coinSelector) selectCoins(strategy CoinSelectionStrategy) (*CoinSelection, error) {
+	var selected []*coinCandidate
+	var err error
+
+	switch strategy {
+	case CoinSelectionInOrder:
+		selected, err = s.accumulate(s.candidates)
+	case CoinSelectionBranchAndBound:
+		selected, err = s.branchAndBound(s.candidates)
+	case CoinSelectionLargestFirst:
+		selected, err = s.accumulate(sortCandidates(s.candidates, true))
+	case CoinSelectionSmallestFirst:
+		selected, err = s.accumulate(sortCandidates(s.candidates, false))
+	case CoinSelectionRandomImprove:
+		selected, err = s.randomImprove(s.candidates)
+	case CoinSelectionPrivacy:
+		return s.privacy()
+	default:
+		return nil, fmt.Errorf("unknown coin selection strategy: %d", strategy)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.newSelection(strategy, selected)
+}
+
+// accumulate takes candidates in order until the outputs and fee are paid.
+func (s *coinSelector) accumulate(candidates []*coinCandidate) ([]*coinCandidate, error) {
+	var selected []*coinCandidate
+	var total btcutil.Amount
+	for _, c := range candidates {
+		if c.effective <= 0 {
+			continue
+		}
+		selected = append(selected, c)
+		total += c.effective
+		if total >= s.target+s.baseFee {
+			return selected, nil
+		}
+	}
+	return nil, ErrInsufficientFunds
+}
+
+// branchAndBound is the depth first search of Bitcoin Core, it looks for the
+// selection whose effective value falls into [target, target+costOfChange]
+// with the lowest waste.
+func (s *coinSelector) branchAndBound(candidates []*coinCandidate) ([]*coinCandidate, error) {
+	pool := make([]*coinCandidate, 0, len(candidates))
+	var available btcutil.Amount
+	for _, c := range sortCandidates(candidates, true) {
+		if c.effective > 0 {
+			pool = append(pool, c)
+			available += c.effective
+		}
+	}
+
+	target := s.target + s.baseFee
+	if available < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	var (
+		current, best []int
+		currentValue  btcutil.Amount
+		currentWaste  btcutil.Amount
+		bestWaste     = btcutil.Amount(btcutil.MaxSatoshi)
+		feeRateHigh   = s.feeRate > s.longTermRate
+	)
+	for tries, i := 0, 0; tries < bnbMaxTries; tries, i = tries+1, i+1 {
+		backtrack := false
+		if currentValue+available < target || currentValue > target+s.costOfChange ||
+			(currentWaste > bestWaste && feeRateHigh) {
+			backtrack = true
+		} else if currentValue >= target {
+			waste := currentWaste + currentValue - target
+			if waste <= bestWaste {
+				best = append(best[:0], current...)
+				bestWaste = waste
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			if len(current) == 0 {
+				break
+			}
+			// add the omitted candidates back, then try the branch
+			// without the last included one
+			last := current[len(current)-1]
+			for i--; i > last; i-- {
+				available += pool[i].effective
+			}
+			currentValue -= pool[i].effective
+			currentWaste -= pool[i].inputWaste(s)
+			current = current[:len(current)-1]
+			continue
+		}
+
+		c := pool[i]
+		available -= c.effective
+		// skip equivalent candidates when the previous one was omitted,
+		// the branch is the same
+		if len(current) == 0 || current[len(current)-1] == i-1 ||
+			c.effective != pool[i-1].effective || c.inputSize != pool[i-1].inputSize {
+			current = append(current, i)
+			currentValue += c.effective
+			currentWaste += c.inputWaste(s)
+		}
+	}
+
+	if best == nil {
+		return nil, ErrNoExactMatch
+	}
+	selected := make([]*coinCandidate, 0, len(best))
+	for _, i := range best {
+		selected = append(selected, pool[i])
+	}
+	return selected, nil
+}
+
+// randomImprove is the Cardano strategy: random candidates until the target
+// is reached, then more random candidates while the change gets closer to the
+// target, without going over three times the target.
+func (s *coinSelector) randomImprove(candidates []*coinCandidate) ([]*coinCandidate, error) {
+	pool := make([]*coinCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		if c.effective > 0 {
+			pool = append(pool, c)
+		}
+	}
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	target := s.target + s.baseFee
+	var selected []*coinCandidate
+	var total btcutil.Amount
+	for len(pool) > 0 && total < target {
+		selected = append(selected, pool[0])
+		total += pool[0].effective
+		pool = pool[1:]
+	}
+	if total < target {
+		return nil, ErrInsufficientFunds
+	}
+
+	ideal, limit := 2*target, 3*target
+	for _, c := range pool {
+		next := total + c.effective
+		if next > limit || absAmount(ideal-next) >= absAmount(ideal-total) {
+			continue
+		}
+		selected = append(selected, c)
+		total = next
+	}
+	return selected, nil
+}
+
+// privacy selects from a single script class, it tries branch and bound
+// first and falls back to largest first, the cheapest class wins.
+func (s *coinSelector) privacy() (*CoinSelection, error) {
+	classes := make(map[txscript.ScriptClass][]*coinCandidate)
+	var order []txscript.ScriptClass
+	for _, c := range s.candidates {
+		if _, ok := classes[c.scriptClass]; !ok {
+			order = append(order, c.scriptClass)
+		}
+		classes[c.scriptClass] = append(classes[c.scriptClass], c)
+	}
+
+	var best *CoinSelection
+	for _, class := range order {
+		candidates := classes[class]
+		selected, err := s.branchAndBound(candidates)
+		if err != nil {
+			selected, err = s.accumulate(sortCandidates(candidates, true))
+		}
+		if err != nil {
+			continue
+		}
+		selection, err := s.newSelection(CoinSelectionPrivacy, selected)
+		if err != nil {
+			continue
+		}
+		if best == nil || selection.Waste < best.Waste {
+			best = selection
+		}
+	}
+	if best == nil {
+		return nil, ErrInsufficientFunds
+	}
+	return best, nil
+}
+
+// newSelection calculates the exact fee, change and waste of the selected
+// candidates.
+func (s *coinSelector) newSelection(strategy CoinSelectionStrategy, selected []*coinCandidate) (*CoinSelection, error) {
+	var total, inputWaste btcutil.Amount
+	prevScripts := make([][]byte, 0, len(selected))
+	scripts := make([]inputScripts, 0, len(selected))
+	unspents := make([]BtcUnspent, 0, len(selected))
+	for _, c := range selected {
+		total += c.value
+		inputWaste += c.inputWaste(s)
+		prevScripts = append(prevScripts, c.pkScript)
+		scripts = append(scripts, c.scripts)
+		unspents = append(unspents, c.unspent)
+	}
+
+	feeNoChange := s.fee(estimateVirtualSize(prevScripts, scripts, s.txOuts, 0))
+	excess := total - s.target - feeNoChange
+	if excess < 0 {
+		return nil, ErrInsufficientFunds
+	}
+
+	selection := &CoinSelection{
+		Strategy: strategy,
+		Unspents: unspents,
+		Total:    int64(total),
+		Fee:      int64(total - s.target),
+		Waste:    int64(inputWaste + excess),
+	}
+
+	feeWithChange := s.fee(estimateVirtualSize(prevScripts, scripts, s.txOuts, len(s.changeScript)))
+	change := wire.NewTxOut(int64(total-s.target-feeWithChange), s.changeScript)
+	if excess >= s.costOfChange && !txrules.IsDustOutput(change, txrules.DefaultRelayFeePerKb) {
+		selection.Fee = int64(feeWithChange)
+		selection.Change = change.Value
+		selection.Waste = int64(inputWaste + s.costOfChange)
+	}
+	return selection, nil
+}
+
+// authoredTx builds the unsigned transaction of a selection.
+func (c *CoinSelection) authoredTx(txOuts []*wire.TxOut, changeScript []byte) (*txauthor.AuthoredTx, error) {
+	tx := &wire.MsgTx{Version: wire.TxVersion, TxOut: txOuts}
+	prevScripts := make([][]byte, 0, len(c.Unspents))
+	inputValues := make([]btcutil.Amount, 0, len(c.Unspents))
+	for _, u := range c.Unspents {
+		candidate, err := newCoinCandidate(u)
+		if err != nil {
+			return nil, err
+		}
+		tx.TxIn = append(tx.TxIn, wire.NewTxIn(&candidate.outPoint, nil, nil))
+		prevScripts = append(prevScripts, candidate.pkScript)
+		inputValues = append(inputValues, candidate.value)
+	}
+
+	changeIndex := -1
+	if c.Change > 0 {
+		l := len(txOuts)
+		tx.TxOut = append(txOuts[:l:l], wire.NewTxOut(c.Change, changeScript))
+		changeIndex = l
+	}
+
+	return &txauthor.AuthoredTx{
+		Tx:              tx,
+		PrevScripts:     prevScripts,
+		PrevInputValues: inputValues,
+		TotalInput:      btcutil.Amount(c.Total),
+		ChangeIndex:     changeIndex,
+	}, nil
+}
+
+// inputWaste is the fee paid for the input now minus the fee to spend it at
+// the long term fee rate.
+func (c *coinCandidate) inputWaste(s *coinSelector) btcutil.Amount {
+	return s.fee(c.inputSize) - txrules.FeeForSerializeSize(s.longTermRate, c.inputSize)
+}
+
+func sortCandidates(candidates []*coinCandidate, descending bool) []*coinCandidate {
+	sorted := make([]*coinCandidate, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if descending {
+			return sorted[i].effective > sorted[j].effective
+		}
+		return sorted[i].effective < sorted[j].effective
+	})
+	return sorted
+}
+
+func absAmount(a btcutil.Amount) btcutil.Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,108 @@
+package tx
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoinSelection(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewWallet(wallet.SymbolBtc, 0, 0, 0)
+	require.NoError(t, err)
+	bw0 := w0.(*wallet.BtcWallet)
+	bw1 := w1.(*wallet.BtcWallet)
+	addr0 := bw0.DeriveNativeAddress()
+	addr1 := bw1.DeriveNativeAddress()
+
+	unspents := []BtcUnspent{
+		fakeUnspent(t, addr0, 0.1, "coin0"),
+		fakeUnspent(t, addr0, 0.5, "coin1"),
+		fakeUnspent(t, addr0, 0.3, "coin2"),
+		fakeUnspent(t, addr0, 0.02, "coin3"),
+		fakeUnspent(t, addr1, 1.2, "coin4"),
+		fakeUnspent(t, addr1, 0.01, "coin5"),
+	}
+	feePerKb := int64(20 * 1000)
+
+	// the amount which 0.5 + 0.3 pays exactly, without change
+	changeScript, _ := txscript.PayToAddrScript(addr0)
+	txOuts, err := makeTxOutputs([]BtcOutput{{Address: addr1, Amount: 0}}, 0, chainParams)
+	require.NoError(t, err)
+	selector, err := newCoinSelector(unspents, txOuts, changeScript, feePerKb, nil)
+	require.NoError(t, err)
+	exact := selector.candidates[1].effective + selector.candidates[2].effective - selector.baseFee
+	outputs := []BtcOutput{{Address: addr1, Amount: int64(exact)}}
+
+	selections := make(map[CoinSelectionStrategy]*CoinSelection)
+	for _, strategy := range []CoinSelectionStrategy{CoinSelectionInOrder, CoinSelectionBranchAndBound,
+		CoinSelectionLargestFirst, CoinSelectionSmallestFirst, CoinSelectionRandomImprove, CoinSelectionPrivacy} {
+
+		selection, err := SelectCoins(strategy, unspents, outputs, addr0, feePerKb, chainParams, nil)
+		require.NoError(t, err, strategy.String())
+		require.GreaterOrEqual(t, selection.Total, int64(exact)+selection.Fee)
+		fmt.Printf("%s: inputs %d, fee %d, change %d, waste %d\n", strategy,
+			len(selection.Unspents), selection.Fee, selection.Change, selection.Waste)
+		selections[strategy] = selection
+	}
+
+	bnb := selections[CoinSelectionBranchAndBound]
+	require.Equal(t, int64(0), bnb.Change)
+	require.ElementsMatch(t, []BtcUnspent{unspents[1], unspents[2]}, bnb.Unspents)
+
+	largest := selections[CoinSelectionLargestFirst]
+	require.Equal(t, []BtcUnspent{unspents[4]}, largest.Unspents)
+
+	smallest := selections[CoinSelectionSmallestFirst]
+	require.Equal(t, unspents[5], smallest.Unspents[0])
+	require.Equal(t, unspents[3], smallest.Unspents[1])
+
+	// only native segwit or only p2pkh unspents
+	privacy := selections[CoinSelectionPrivacy]
+	class := txscript.GetScriptClass(mustDecodeHex(t, privacy.Unspents[0].ScriptPubKey))
+	for _, u := range privacy.Unspents {
+		require.Equal(t, class, txscript.GetScriptClass(mustDecodeHex(t, u.ScriptPubKey)))
+	}
+
+	cheapest, err := SelectCheapestCoins([]CoinSelectionStrategy{CoinSelectionLargestFirst,
+		CoinSelectionBranchAndBound, CoinSelectionSmallestFirst}, unspents, outputs, addr0, feePerKb, chainParams, nil)
+	require.NoError(t, err)
+	require.Equal(t, CoinSelectionBranchAndBound, cheapest.Strategy)
+	require.LessOrEqual(t, cheapest.Waste, largest.Waste)
+	require.LessOrEqual(t, cheapest.Waste, smallest.Waste)
+
+	// too much
+	_, err = SelectCoins(CoinSelectionLargestFirst, unspents, []BtcOutput{{Address: addr1, Amount: BtcToSatoshi(3)}},
+		addr0, feePerKb, chainParams, nil)
+	require.ErrorIs(t, err, ErrInsufficientFunds)
+	_, err = SelectCoins(CoinSelectionBranchAndBound, unspents, []BtcOutput{{Address: addr1, Amount: BtcToSatoshi(0.61)}},
+		addr0, feePerKb, chainParams, nil)
+	require.ErrorIs(t, err, ErrNoExactMatch)
+
+	// build and sign the branch and bound transaction
+	tx, err := NewBtcTransaction(unspents, outputs, addr0, feePerKb, chainParams,
+		&BtcTxOptions{CoinSelection: CoinSelectionBranchAndBound})
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxOut, 1)
+	require.Equal(t, -1, tx.ChangeIndex)
+	require.Equal(t, bnb.Fee, tx.GetFee())
+	require.Equal(t, bnb.Waste, tx.CoinSelection().Waste)
+	require.NoError(t, tx.Sign(bw0))
+
+	// the signed size is within the estimate
+	vsize := (tx.Tx.SerializeSizeStripped()*3 + tx.Tx.SerializeSize() + 3) / 4
+	require.GreaterOrEqual(t, tx.GetFee(), int64(btcutil.Amount(feePerKb)*btcutil.Amount(vsize)/1000))
+}
@@ -0,0 +1,87 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// CpfpParent is a stuck transaction with an output controlled by us.
+type CpfpParent struct {
+	RawTx string
+	// VirtualSize and Fee are the parent's size in vbytes and fee in satoshi,
+	// e.g. vsize and fees.base from getmempoolentry.
+	VirtualSize int64
+	Fee         int64
+	// Vout is the index of the output spent by the child.
+	Vout uint32
+}
+
+// NewCpfpTransaction builds and signs a child spending the parent's output to
+// the wallet's own address, paying the fee which brings the parent and the
+// child together to targetFeePerKb. The child pays at least targetFeePerKb
+// for itself.
+func NewCpfpTransaction(parent CpfpParent, w *wallet.BtcWallet, targetFeePerKb int64, opts *BtcTxOptions) (*BtcTransaction, error) {
+	if parent.VirtualSize <= 0 || parent.Fee < 0 || targetFeePerKb <= 0 {
+		return nil, errors.New("wrong params")
+	}
+	parentTx, err := decodeMsgTx(parent.RawTx)
+	if err != nil {
+		return nil, err
+	}
+	if int(parent.Vout) >= len(parentTx.TxOut) {
+		return nil, errors.New("parent output does not exist")
+	}
+	prevOut := parentTx.TxOut[parent.Vout]
+
+	addr := w.DeriveNativeAddress()
+	changeScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prevOut.PkScript, changeScript) {
+		return nil, errors.New("parent output is not controlled by the wallet")
+	}
+
+	// the wallet has a single key
+	childSize := int64(estimateVirtualSize([][]byte{prevOut.PkScript}, nil, nil, len(changeScript)))
+	childFee := targetFeePerKb*(parent.VirtualSize+childSize)/1000 - parent.Fee
+	// the fee rate makes NewBtcTransaction pay at least childFee
+	feePerKb := (childFee*1000 + childSize - 1) / childSize
+	if feePerKb < targetFeePerKb {
+		feePerKb = targetFeePerKb
+	}
+
+	unspent := BtcUnspent{
+		TxID:         parentTx.TxHash().String(),
+		Vout:         parent.Vout,
+		ScriptPubKey: hex.EncodeToString(prevOut.PkScript),
+		Amount:       btcutil.Amount(prevOut.Value).ToBTC(),
+	}
+	sweepOpts := BtcTxOptions{}
+	if opts != nil {
+		sweepOpts = *opts
+	}
+	sweepOpts.Sweep = true
+	child, err := NewBtcTransaction([]BtcUnspent{unspent}, nil, addr, feePerKb, w.ChainParams(), &sweepOpts)
+	if err != nil {
+		return nil, err
+	}
+	if child.ChangeIndex < 0 {
+		return nil, errors.New("parent output can not pay the child fee")
+	}
+	if err = child.Sign(w); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// PackageFeeRate is the fee rate in sat/kvB of a parent and its child.
+func PackageFeeRate(parentSize, parentFee int64, child *BtcTransaction) int64 {
+	childSize := int64(virtualSize(child.Tx, child.PrevScripts, child.inputScripts))
+	return (parentFee + child.GetFee()) * 1000 / (parentSize + childSize)
+}
@@ -0,0 +1,60 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCpfpTransaction(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewSegWitWallet(0, 0, 1)
+	require.NoError(t, err)
+	bw0 := w0.(*wallet.BtcWallet)
+	bw1 := w1.(*wallet.BtcWallet)
+	addr0 := bw0.DeriveNativeAddress()
+	addr1 := bw1.DeriveNativeAddress()
+
+	// a parent paying 1 sat/vB to w1
+	parentTx, err := NewBtcTransaction([]BtcUnspent{fakeUnspent(t, addr0, 0.5, "cpfp0")},
+		[]BtcOutput{{Address: addr1, Amount: BtcToSatoshi(0.2)}}, addr0, 1000, chainParams, nil)
+	require.NoError(t, err)
+	require.NoError(t, parentTx.Sign(bw0))
+
+	var buf bytes.Buffer
+	require.NoError(t, parentTx.Tx.Serialize(&buf))
+	parent := CpfpParent{
+		RawTx:       hex.EncodeToString(buf.Bytes()),
+		VirtualSize: int64(virtualSize(parentTx.Tx, parentTx.PrevScripts, nil)),
+		Fee:         parentTx.GetFee(),
+		Vout:        uint32(1 - parentTx.ChangeIndex),
+	}
+
+	targetFeePerKb := int64(50 * 1000)
+	child, err := NewCpfpTransaction(parent, bw1, targetFeePerKb, nil)
+	require.NoError(t, err)
+	require.Equal(t, parentTx.Tx.TxHash(), child.Tx.TxIn[0].PreviousOutPoint.Hash)
+	require.Greater(t, child.GetFee()*1000/int64(virtualSize(child.Tx, child.PrevScripts, nil)), targetFeePerKb)
+	packageFeeRate := PackageFeeRate(parent.VirtualSize, parent.Fee, child)
+	require.GreaterOrEqual(t, packageFeeRate, targetFeePerKb)
+	fmt.Println("child fee:", child.GetFee(), "package fee rate:", packageFeeRate)
+
+	{ // the change output is not controlled by w1
+		parent.Vout = uint32(parentTx.ChangeIndex)
+		_, err = NewCpfpTransaction(parent, bw1, targetFeePerKb, nil)
+		require.Error(t, err)
+	}
+}
@@ -21,6 +21,12 @@ func DecodeMsgTx(mtx *wire.MsgTx, chainParams *chaincfg.Params) *btcjson.TxRawDe
 	}
 }
 
+// FilterVouts decodes the outputs of mtx paying to an address of
+// filterAddrMap, all outputs if the map is empty.
+func FilterVouts(mtx *wire.MsgTx, chainParams *chaincfg.Params, filterAddrMap map[string]struct{}) []btcjson.Vout {
+	return createVoutList(mtx, chainParams, filterAddrMap)
+}
+
 func createVinList(mtx *wire.MsgTx) []btcjson.Vin {
 	// Coinbase transactions only have a single txin by definition.
 	vinList := make([]btcjson.Vin, len(mtx.TxIn))
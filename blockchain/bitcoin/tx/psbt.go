@@ -0,0 +1,541 @@
+package tx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+)
+
+var ErrPsbtMismatch = errors.New("psbt unsigned transactions do not match")
+
+// BtcPsbt is a BIP-174 partially signed bitcoin transaction.
+//
+// Native segwit inputs carry their previous output (script and value) as a
+// witness utxo. P2PKH and P2SH inputs carry the previous transaction, the
+// signer can't trust an amount it can't hash. A P2SH input which turns out to
+// be wrapped segwit gets its witness utxo when it's signed.
+type BtcPsbt struct {
+	*psbt.Packet
+	chainParams *chaincfg.Params
+}
+
+// ToPsbt exports the unsigned transaction. prevTxs are the previous
+// transactions of the P2PKH and P2SH inputs, see NeedsPrevTx.
+func (t *BtcTransaction) ToPsbt(prevTxs ...*wire.MsgTx) (*BtcPsbt, error) {
+	if len(t.PrevScripts) != len(t.Tx.TxIn) || len(t.PrevInputValues) != len(t.Tx.TxIn) {
+		return nil, errors.New("missing previous outputs")
+	}
+	byHash := make(map[chainhash.Hash]*wire.MsgTx, len(prevTxs))
+	for _, prevTx := range prevTxs {
+		byHash[prevTx.TxHash()] = prevTx
+	}
+
+	unsignedTx := t.Tx.Copy()
+	for _, in := range unsignedTx.TxIn {
+		in.SignatureScript = nil
+		in.Witness = nil
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(unsignedTx)
+	if err != nil {
+		return nil, err
+	}
+	for i, in := range unsignedTx.TxIn {
+		pkScript, value := t.PrevScripts[i], int64(t.PrevInputValues[i])
+		if !NeedsPrevTx(pkScript) {
+			packet.Inputs[i].WitnessUtxo = wire.NewTxOut(value, pkScript)
+			continue
+		}
+
+		prevTx, ok := byHash[in.PreviousOutPoint.Hash]
+		if !ok {
+			return nil, fmt.Errorf("missing previous transaction of input %d", i)
+		}
+		outIndex := in.PreviousOutPoint.Index
+		if int(outIndex) >= len(prevTx.TxOut) || prevTx.TxOut[outIndex].Value != value ||
+			!bytes.Equal(prevTx.TxOut[outIndex].PkScript, pkScript) {
+			return nil, fmt.Errorf("previous transaction of input %d doesn't match", i)
+		}
+		packet.Inputs[i].NonWitnessUtxo = prevTx
+	}
+
+	return &BtcPsbt{Packet: packet, chainParams: t.chainParams}, nil
+}
+
+// NeedsPrevTx reports whether a psbt input spending pkScript carries the
+// previous transaction: P2PKH and P2SH, which may be legacy.
+func NeedsPrevTx(pkScript []byte) bool {
+	return txscript.IsPayToPubKeyHash(pkScript) || txscript.IsPayToScriptHash(pkScript)
+}
+
+func NewBtcPsbtFromBytes(b []byte, chainParams *chaincfg.Params) (*BtcPsbt, error) {
+	packet, err := psbt.NewFromRawBytes(bytes.NewReader(b), false)
+	if err != nil {
+		return nil, err
+	}
+	return &BtcPsbt{Packet: packet, chainParams: chainParams}, nil
+}
+
+func NewBtcPsbtFromBase64(s string, chainParams *chaincfg.Params) (*BtcPsbt, error) {
+	packet, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(s)), true)
+	if err != nil {
+		return nil, err
+	}
+	return &BtcPsbt{Packet: packet, chainParams: chainParams}, nil
+}
+
+func (p *BtcPsbt) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (p *BtcPsbt) Base64() (string, error) {
+	return p.B64Encode()
+}
+
+// GetFee returns the fee in satoshi, calculated from the previous outputs.
+func (p *BtcPsbt) GetFee() (int64, error) {
+	_, inputValues, err := p.prevOutputs()
+	if err != nil {
+		return 0, err
+	}
+	var fee int64
+	for _, value := range inputValues {
+		fee += int64(value)
+	}
+	for _, out := range p.UnsignedTx.TxOut {
+		fee -= out.Value
+	}
+	return fee, nil
+}
+
+func (p *BtcPsbt) Sign(wallets ...*wallet.BtcWallet) (int, error) {
+	total := 0
+	for _, w := range wallets {
+		n, err := p.SignWithSecretsSource(w)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// SignWithSecretsSource adds a partial signature to every input the secrets
+// source owns a key for, and returns the number of signatures added. Inputs
+// of other owners are left untouched.
+func (p *BtcPsbt) SignWithSecretsSource(secrets txauthor.SecretsSource) (int, error) {
+	prevScripts, inputValues, err := p.prevOutputs()
+	if err != nil {
+		return 0, err
+	}
+	fetcher, err := txauthor.TXPrevOutFetcher(p.UnsignedTx, prevScripts, inputValues)
+	if err != nil {
+		return 0, err
+	}
+	hashCache := txscript.NewTxSigHashes(p.UnsignedTx, fetcher)
+
+	signed := 0
+	for i := range p.Inputs {
+		if p.Inputs[i].FinalScriptSig != nil || p.Inputs[i].FinalScriptWitness != nil {
+			continue
+		}
+
+		ok, err := p.signInput(i, prevScripts[i], int64(inputValues[i]), secrets, hashCache)
+		if err != nil {
+			return signed, fmt.Errorf("sign input %d: %w", i, err)
+		}
+		if ok {
+			signed++
+		}
+	}
+	return signed, nil
+}
+
+func (p *BtcPsbt) signInput(idx int, pkScript []byte, amount int64,
+	secrets txauthor.SecretsSource, hashCache *txscript.TxSigHashes) (bool, error) {
+
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, p.chainParams)
+	if err != nil {
+		return false, err
+	}
+	if len(addrs) != 1 {
+		return false, nil
+	}
+	if txscript.IsPayToScriptHash(pkScript) || txscript.IsPayToWitnessScriptHash(pkScript) {
+		if script, err := secrets.GetScript(addrs[0]); err == nil {
+			return p.signMultisigInput(idx, pkScript, script, amount, secrets, hashCache)
+		}
+	}
+	privKey, compressed, err := secrets.GetKey(addrs[0])
+	if err != nil {
+		// not one of ours
+		return false, nil
+	}
+	pubKey := serializePubKey(privKey.PubKey(), compressed)
+	tx := p.UnsignedTx
+	pInput := &p.Inputs[idx]
+
+	var sig []byte
+	switch {
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		sig, err = txscript.RawTxInWitnessSignature(tx, hashCache, idx, amount,
+			pkScript, txscript.SigHashAll, privKey)
+
+	case txscript.IsPayToScriptHash(pkScript):
+		// p2sh-p2wpkh, the redeem script is the witness program of the key
+		var redeemScript []byte
+		redeemScript, err = payToWitnessPubKeyHashScript(pubKey)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(btcutil.Hash160(redeemScript), pkScript[2:22]) {
+			return false, errors.New("redeem script does not match")
+		}
+		pInput.RedeemScript = redeemScript
+		pInput.WitnessUtxo = wire.NewTxOut(amount, pkScript)
+		sig, err = txscript.RawTxInWitnessSignature(tx, hashCache, idx, amount,
+			redeemScript, txscript.SigHashAll, privKey)
+
+	case txscript.IsPayToPubKeyHash(pkScript):
+		sig, err = txscript.RawTxInSignature(tx, idx, pkScript, txscript.SigHashAll, privKey)
+
+	case txscript.IsPayToTaproot(pkScript):
+		// BIP-86 key path spend, schnorr signature over the BIP-341 sighash
+		// with the key tweaked by an empty script root.
+		sig, err = txscript.RawTxInTaprootSignature(tx, hashCache, idx, amount,
+			pkScript, []byte{}, txscript.SigHashDefault, privKey)
+		if err != nil {
+			return false, err
+		}
+		pInput.TaprootInternalKey = schnorr.SerializePubKey(privKey.PubKey())
+		pInput.TaprootKeySpendSig = sig
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unsupported script type: %s", txscript.GetScriptClass(pkScript))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	addPartialSig(pInput, &psbt.PartialSig{PubKey: pubKey, Signature: sig})
+	return true, nil
+}
+
+// signMultisigInput adds the signatures of every key of the multisig script
+// known by secrets. script is the redeem script of a p2sh output or the
+// witness script of a p2wsh output.
+func (p *BtcPsbt) signMultisigInput(idx int, pkScript, script []byte, amount int64,
+	secrets txauthor.SecretsSource, hashCache *txscript.TxSigHashes) (bool, error) {
+
+	var redeemScript, witnessScript []byte
+	switch {
+	case txscript.IsPayToWitnessScriptHash(pkScript):
+		witnessScript = script
+	case txscript.IsPayToWitnessScriptHash(script):
+		// p2sh-p2wsh, the redeem script is the witness program
+		redeemScript = script
+		addr, err := btcutil.NewAddressWitnessScriptHash(script[2:], p.chainParams)
+		if err != nil {
+			return false, err
+		}
+		witnessScript, err = secrets.GetScript(addr)
+		if err != nil {
+			return false, err
+		}
+	default:
+		redeemScript = script
+	}
+
+	if redeemScript != nil && !bytes.Equal(btcutil.Hash160(redeemScript), pkScript[2:22]) {
+		return false, errors.New("redeem script does not match")
+	}
+	if witnessScript != nil {
+		program := pkScript
+		if redeemScript != nil {
+			program = redeemScript
+		}
+		scriptHash := sha256.Sum256(witnessScript)
+		if !bytes.Equal(scriptHash[:], program[2:]) {
+			return false, errors.New("witness script does not match")
+		}
+	}
+
+	signScript := redeemScript
+	if witnessScript != nil {
+		signScript = witnessScript
+	}
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(signScript, p.chainParams)
+	if err != nil {
+		return false, err
+	}
+	if class != txscript.MultiSigTy {
+		return false, fmt.Errorf("unsupported script type: %s", class)
+	}
+
+	tx := p.UnsignedTx
+	pInput := &p.Inputs[idx]
+	signed := false
+	for _, addr := range addrs {
+		privKey, compressed, err := secrets.GetKey(addr)
+		if err != nil {
+			continue
+		}
+
+		var sig []byte
+		if witnessScript != nil {
+			sig, err = txscript.RawTxInWitnessSignature(tx, hashCache, idx, amount,
+				witnessScript, txscript.SigHashAll, privKey)
+		} else {
+			sig, err = txscript.RawTxInSignature(tx, idx, redeemScript, txscript.SigHashAll, privKey)
+		}
+		if err != nil {
+			return false, err
+		}
+		addPartialSig(pInput, &psbt.PartialSig{PubKey: serializePubKey(privKey.PubKey(), compressed), Signature: sig})
+		signed = true
+	}
+	if signed {
+		pInput.RedeemScript = redeemScript
+		pInput.WitnessScript = witnessScript
+		if witnessScript != nil {
+			pInput.WitnessUtxo = wire.NewTxOut(amount, pkScript)
+		}
+	}
+	return signed, nil
+}
+
+// Combine merges the signatures and scripts of other copies of the same psbt.
+func (p *BtcPsbt) Combine(others ...*BtcPsbt) error {
+	for _, other := range others {
+		if other.UnsignedTx.TxHash() != p.UnsignedTx.TxHash() ||
+			len(other.Inputs) != len(p.Inputs) {
+			return ErrPsbtMismatch
+		}
+
+		for i := range p.Inputs {
+			dst, src := &p.Inputs[i], &other.Inputs[i]
+			if dst.NonWitnessUtxo == nil {
+				dst.NonWitnessUtxo = src.NonWitnessUtxo
+			}
+			if dst.WitnessUtxo == nil {
+				dst.WitnessUtxo = src.WitnessUtxo
+			}
+			if dst.RedeemScript == nil {
+				dst.RedeemScript = src.RedeemScript
+			}
+			if dst.WitnessScript == nil {
+				dst.WitnessScript = src.WitnessScript
+			}
+			if dst.TaprootKeySpendSig == nil {
+				dst.TaprootKeySpendSig = src.TaprootKeySpendSig
+			}
+			if dst.TaprootInternalKey == nil {
+				dst.TaprootInternalKey = src.TaprootInternalKey
+			}
+			if dst.FinalScriptSig == nil && dst.FinalScriptWitness == nil {
+				dst.FinalScriptSig = src.FinalScriptSig
+				dst.FinalScriptWitness = src.FinalScriptWitness
+			}
+			for _, sig := range src.PartialSigs {
+				addPartialSig(dst, sig)
+			}
+			for _, d := range src.Bip32Derivation {
+				if !hasBip32Derivation(dst.Bip32Derivation, d.PubKey) {
+					dst.Bip32Derivation = append(dst.Bip32Derivation, d)
+				}
+			}
+		}
+	}
+	return p.SanityCheck()
+}
+
+// Finalize builds the final script sig and witness of every input.
+func (p *BtcPsbt) Finalize() error {
+	for i := range p.Inputs {
+		pInput := &p.Inputs[i]
+		if pInput.FinalScriptSig != nil || pInput.FinalScriptWitness != nil {
+			continue
+		}
+		if isMultisigInput(pInput) {
+			if err := finalizeMultisigInput(pInput, p.chainParams); err != nil {
+				return fmt.Errorf("finalize input %d: %w", i, err)
+			}
+			continue
+		}
+		if _, err := psbt.MaybeFinalize(p.Packet, i); err != nil {
+			return fmt.Errorf("finalize input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Extract returns the network ready transaction, the psbt must be finalized.
+func (p *BtcPsbt) Extract() (*wire.MsgTx, error) {
+	msgTx, err := psbt.Extract(p.Packet)
+	if err != nil {
+		return nil, err
+	}
+
+	prevScripts, inputValues, err := p.prevOutputs()
+	if err != nil {
+		return nil, err
+	}
+	err = validateMsgTx(msgTx, prevScripts, inputValues)
+	if err != nil {
+		return nil, err
+	}
+	return msgTx, nil
+}
+
+// prevOutputs returns the previous outputs of the inputs. A previous
+// transaction must hash to the outpoint it's spent by, and agree with the
+// witness utxo set next to it.
+func (p *BtcPsbt) prevOutputs() ([][]byte, []btcutil.Amount, error) {
+	prevScripts := make([][]byte, len(p.Inputs))
+	inputValues := make([]btcutil.Amount, len(p.Inputs))
+	for i, in := range p.Inputs {
+		var prevOut *wire.TxOut
+		if in.NonWitnessUtxo != nil {
+			outPoint := p.UnsignedTx.TxIn[i].PreviousOutPoint
+			if in.NonWitnessUtxo.TxHash() != outPoint.Hash ||
+				int(outPoint.Index) >= len(in.NonWitnessUtxo.TxOut) {
+				return nil, nil, fmt.Errorf("previous transaction of input %d doesn't match", i)
+			}
+			prevOut = in.NonWitnessUtxo.TxOut[outPoint.Index]
+		}
+		switch {
+		case in.WitnessUtxo != nil:
+			if prevOut != nil && (prevOut.Value != in.WitnessUtxo.Value ||
+				!bytes.Equal(prevOut.PkScript, in.WitnessUtxo.PkScript)) {
+				return nil, nil, fmt.Errorf("witness utxo of input %d doesn't match its previous transaction", i)
+			}
+			prevOut = in.WitnessUtxo
+		case prevOut == nil:
+			return nil, nil, fmt.Errorf("input %d has no utxo information", i)
+		}
+		prevScripts[i] = prevOut.PkScript
+		inputValues[i] = btcutil.Amount(prevOut.Value)
+	}
+	return prevScripts, inputValues, nil
+}
+
+func isMultisigInput(pInput *psbt.PInput) bool {
+	script := pInput.RedeemScript
+	if pInput.WitnessScript != nil {
+		script = pInput.WitnessScript
+	}
+	if script == nil {
+		return false
+	}
+	ok, err := txscript.IsMultisigScript(script)
+	return err == nil && ok
+}
+
+// finalizeMultisigInput puts the required number of signatures in the order
+// of the keys in the script, the extra signatures are dropped.
+func finalizeMultisigInput(pInput *psbt.PInput, chainParams *chaincfg.Params) error {
+	script := pInput.RedeemScript
+	if pInput.WitnessScript != nil {
+		script = pInput.WitnessScript
+	}
+	_, addrs, required, err := txscript.ExtractPkScriptAddrs(script, chainParams)
+	if err != nil {
+		return err
+	}
+
+	sigs := make([][]byte, 0, required)
+	for _, addr := range addrs {
+		if len(sigs) == required {
+			break
+		}
+		pubKey := addr.(*btcutil.AddressPubKey).ScriptAddress()
+		for _, sig := range pInput.PartialSigs {
+			if bytes.Equal(sig.PubKey, pubKey) {
+				sigs = append(sigs, sig.Signature)
+				break
+			}
+		}
+	}
+	if len(sigs) < required {
+		return psbt.ErrNotFinalizable
+	}
+
+	if pInput.WitnessScript != nil {
+		// the extra item is popped by the OP_CHECKMULTISIG bug
+		witness := make(wire.TxWitness, 0, required+2)
+		witness = append(witness, nil)
+		witness = append(witness, sigs...)
+		witness = append(witness, pInput.WitnessScript)
+		var buf bytes.Buffer
+		if err = psbt.WriteTxWitness(&buf, witness); err != nil {
+			return err
+		}
+		pInput.FinalScriptWitness = buf.Bytes()
+		if pInput.RedeemScript != nil {
+			pInput.FinalScriptSig, err = txscript.NewScriptBuilder().AddData(pInput.RedeemScript).Script()
+		}
+	} else {
+		builder := txscript.NewScriptBuilder().AddOp(txscript.OP_FALSE)
+		for _, sig := range sigs {
+			builder.AddData(sig)
+		}
+		pInput.FinalScriptSig, err = builder.AddData(pInput.RedeemScript).Script()
+	}
+	if err != nil {
+		return err
+	}
+
+	pInput.PartialSigs = nil
+	pInput.SighashType = 0
+	pInput.RedeemScript = nil
+	pInput.WitnessScript = nil
+	pInput.Bip32Derivation = nil
+	return nil
+}
+
+func addPartialSig(pInput *psbt.PInput, sig *psbt.PartialSig) {
+	for i, s := range pInput.PartialSigs {
+		if bytes.Equal(s.PubKey, sig.PubKey) {
+			pInput.PartialSigs[i] = sig
+			return
+		}
+	}
+	pInput.PartialSigs = append(pInput.PartialSigs, sig)
+}
+
+func hasBip32Derivation(derivations []*psbt.Bip32Derivation, pubKey []byte) bool {
+	for _, d := range derivations {
+		if bytes.Equal(d.PubKey, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func serializePubKey(pubKey *btcec.PublicKey, compressed bool) []byte {
+	if compressed {
+		return pubKey.SerializeCompressed()
+	}
+	return pubKey.SerializeUncompressed()
+}
+
+func payToWitnessPubKeyHashScript(pubKey []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(btcutil.Hash160(pubKey)).Script()
+}
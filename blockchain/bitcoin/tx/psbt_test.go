@@ -0,0 +1,321 @@
+package tx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUnspent makes an unspent output paying to addr, the txid is derived from
+// the seed so the outputs are distinct.
+func fakeUnspent(t *testing.T, addr btcutil.Address, amount float64, seed string) BtcUnspent {
+	unspent, _ := fakePrevTx(t, addr, amount, seed)
+	return unspent
+}
+
+// fakePrevTx makes the transaction of a fakeUnspent, which is its output 0.
+func fakePrevTx(t *testing.T, addr btcutil.Address, amount float64, seed string) (BtcUnspent, *wire.MsgTx) {
+	pkScript, err := txscript.PayToAddrScript(addr)
+	require.NoError(t, err)
+
+	seedHash := chainhash.Hash(sha256.Sum256([]byte(seed)))
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&seedHash, 0), nil, nil))
+	prevTx.AddTxOut(wire.NewTxOut(BtcToSatoshi(amount), pkScript))
+	hash := prevTx.TxHash()
+	return BtcUnspent{TxID: hash.String(), Vout: 0,
+		ScriptPubKey: hex.EncodeToString(pkScript), Amount: amount}, prevTx
+}
+
+func TestPsbt(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w2, err := hdw.NewWallet(wallet.SymbolBtc, 0, 0, 0)
+	require.NoError(t, err)
+	w3, err := hdw.NewTaprootWallet(0, 0, 0)
+	require.NoError(t, err)
+
+	bw0 := w0.(*wallet.BtcWallet)
+	bw1 := w1.(*wallet.BtcWallet)
+	bw2 := w2.(*wallet.BtcWallet)
+	bw3 := w3.(*wallet.BtcWallet)
+
+	// p2sh-p2wpkh and p2pkh carry their previous transactions
+	unspent1, prevTx1 := fakePrevTx(t, bw1.DeriveNativeAddress(), 1, "psbt1")
+	unspent2, prevTx2 := fakePrevTx(t, bw2.DeriveNativeAddress(), 1, "psbt2")
+	unspents := []BtcUnspent{
+		fakeUnspent(t, bw0.DeriveNativeAddress(), 1, "psbt0"),
+		unspent1,
+		unspent2,
+		fakeUnspent(t, bw3.DeriveNativeAddress(), 1, "psbt3"),
+	}
+	outputs := []BtcOutput{{Address: bw2.DeriveNativeAddress(), Amount: BtcToSatoshi(3.5)}}
+
+	tx, err := NewBtcTransaction(unspents, outputs, bw0.DeriveNativeAddress(), 10*1000, chainParams, nil)
+	require.NoError(t, err)
+	require.Len(t, tx.Tx.TxIn, 4)
+
+	_, err = tx.ToPsbt(prevTx1)
+	require.ErrorContains(t, err, "missing previous transaction of input 2")
+	// the amount of an unspent is checked against its transaction
+	lying := append([]BtcUnspent{}, unspents...)
+	lying[2].Amount = 1.1
+	lyingTx, err := NewBtcTransaction(lying, outputs, bw0.DeriveNativeAddress(), 10*1000, chainParams, nil)
+	require.NoError(t, err)
+	_, err = lyingTx.ToPsbt(prevTx1, prevTx2)
+	require.ErrorContains(t, err, "previous transaction of input 2 doesn't match")
+
+	// export the unsigned transaction to two offline signers
+	p, err := tx.ToPsbt(prevTx1, prevTx2)
+	require.NoError(t, err)
+	for i, in := range p.Inputs {
+		legacy := i == 1 || i == 2
+		require.Equal(t, legacy, in.NonWitnessUtxo != nil)
+		require.Equal(t, legacy, in.WitnessUtxo == nil)
+	}
+	b64, err := p.Base64()
+	require.NoError(t, err)
+	raw, err := p.Bytes()
+	require.NoError(t, err)
+	fmt.Println("psbt:", b64)
+
+	p1, err := NewBtcPsbtFromBase64(b64, chainParams)
+	require.NoError(t, err)
+	p2, err := NewBtcPsbtFromBytes(raw, chainParams)
+	require.NoError(t, err)
+
+	fee, err := p1.GetFee()
+	require.NoError(t, err)
+	require.Equal(t, tx.GetFee(), fee)
+
+	// a previous transaction is checked against the outpoint it's spent by
+	tampered, err := NewBtcPsbtFromBytes(raw, chainParams)
+	require.NoError(t, err)
+	tamperedTx := prevTx2.Copy()
+	tamperedTx.TxOut[tx.Tx.TxIn[2].PreviousOutPoint.Index].Value += BtcToSatoshi(1)
+	tampered.Inputs[2].NonWitnessUtxo = tamperedTx
+	_, err = tampered.GetFee()
+	require.ErrorContains(t, err, "previous transaction of input 2 doesn't match")
+	_, err = tampered.Sign(bw2)
+	require.ErrorContains(t, err, "previous transaction of input 2 doesn't match")
+
+	n, err := p1.Sign(bw0)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	n, err = p2.Sign(bw1, bw2, bw3)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	// the wrapped segwit input turned out to be segwit
+	require.NotNil(t, p2.Inputs[1].WitnessUtxo)
+	require.Nil(t, p2.Inputs[2].WitnessUtxo)
+
+	// not enough signatures
+	require.Error(t, p1.Finalize())
+
+	// combine, finalize and extract
+	require.NoError(t, p.Combine(p1, p2))
+	combined, err := p.Bytes()
+	require.NoError(t, err)
+	require.NoError(t, p.Finalize())
+	msgTx, err := p.Extract()
+	require.NoError(t, err)
+	require.Equal(t, tx.Tx.TxOut, msgTx.TxOut)
+	require.Equal(t, tx.Tx.TxIn[0].PreviousOutPoint, msgTx.TxIn[0].PreviousOutPoint)
+
+	// other psbt implementations finalize it from the previous transactions
+	packet, err := psbt.NewFromRawBytes(bytes.NewReader(combined), false)
+	require.NoError(t, err)
+	require.Equal(t, prevTx2.TxHash(), packet.Inputs[2].NonWitnessUtxo.TxHash())
+	require.NoError(t, psbt.MaybeFinalizeAll(packet))
+	extracted, err := psbt.Extract(packet)
+	require.NoError(t, err)
+	require.Equal(t, msgTx.WitnessHash(), extracted.WitnessHash())
+
+	// psbt of another transaction
+	outputs[0].Amount = BtcToSatoshi(3.4)
+	other, err := NewBtcTransaction(unspents, outputs, bw0.DeriveNativeAddress(), 10*1000, chainParams, nil)
+	require.NoError(t, err)
+	otherPsbt, err := other.ToPsbt(prevTx1, prevTx2)
+	require.NoError(t, err)
+	require.ErrorIs(t, p.Combine(otherPsbt), ErrPsbtMismatch)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestMultisigPsbt(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	var signers []*wallet.BtcWallet
+	var keys []string
+	for i := 0; i < 3; i++ {
+		w, err := hdw.NewNativeSegWitWallet(i, 0, 0)
+		require.NoError(t, err)
+		signers = append(signers, w.(*wallet.BtcWallet))
+		keys = append(keys, w.DerivePublicKey())
+	}
+	to := signers[0].DeriveNativeAddress()
+
+	for _, segWitType := range []wallet.SegWitType{wallet.SegWitNone, wallet.SegWitScript, wallet.SegWitNative} {
+		treasury, err := wallet.NewMultisigWallet(2, keys, btcChainId, segWitType)
+		require.NoError(t, err)
+		addr := treasury.DeriveNativeAddress()
+
+		unspent, prevTx := fakePrevTx(t, addr, 1, fmt.Sprintf("multisig%d", segWitType))
+		outputs := []BtcOutput{{Address: to, Amount: BtcToSatoshi(0.5)}}
+		tx, err := NewBtcTransaction([]BtcUnspent{unspent}, outputs, addr, 10*1000, chainParams, nil)
+		require.NoError(t, err)
+		p, err := tx.ToPsbt(prevTx)
+		require.NoError(t, err)
+		b64, err := p.Base64()
+		require.NoError(t, err)
+
+		// every signer signs its own copy with its own key
+		var copies []*BtcPsbt
+		for _, signer := range signers {
+			cosigner, err := wallet.NewMultisigWallet(2, keys, btcChainId, segWitType)
+			require.NoError(t, err)
+			require.NoError(t, cosigner.AddSigner(signer))
+
+			c, err := NewBtcPsbtFromBase64(b64, chainParams)
+			require.NoError(t, err)
+			n, err := c.SignWithSecretsSource(cosigner)
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+			copies = append(copies, c)
+		}
+
+		// one signature is not enough
+		require.Error(t, copies[0].Finalize())
+
+		// all three signatures, only two are used
+		require.NoError(t, p.Combine(copies...))
+		require.Len(t, p.Inputs[0].PartialSigs, 3)
+		require.Equal(t, segWitType == wallet.SegWitNone, p.Inputs[0].WitnessUtxo == nil)
+		require.Equal(t, segWitType == wallet.SegWitNative, p.Inputs[0].NonWitnessUtxo == nil)
+		require.NoError(t, p.Finalize())
+		msgTx, err := p.Extract()
+		require.NoError(t, err)
+		require.Equal(t, tx.Tx.TxOut, msgTx.TxOut)
+	}
+}
+
+func TestMultisigFeeRate(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	var signers []*wallet.BtcWallet
+	var keys []string
+	for i := 0; i < 5; i++ {
+		w, err := hdw.NewNativeSegWitWallet(i, 0, 0)
+		require.NoError(t, err)
+		signers = append(signers, w.(*wallet.BtcWallet))
+		keys = append(keys, w.DerivePublicKey())
+	}
+	to := signers[0].DeriveNativeAddress()
+	const feePerKb = 20 * 1000
+
+	// signs with the first m signers and returns the network ready transaction
+	sign := func(tx *BtcTransaction, m int, segWitType wallet.SegWitType, prevTxs ...*wire.MsgTx) *wire.MsgTx {
+		p, err := tx.ToPsbt(prevTxs...)
+		require.NoError(t, err)
+		for _, signer := range signers[:m] {
+			cosigner, err := wallet.NewMultisigWallet(m, keys, btcChainId, segWitType)
+			require.NoError(t, err)
+			require.NoError(t, cosigner.AddSigner(signer))
+			_, err = p.SignWithSecretsSource(cosigner)
+			require.NoError(t, err)
+		}
+		require.NoError(t, p.Finalize())
+		msgTx, err := p.Extract()
+		require.NoError(t, err)
+		return msgTx
+	}
+	feeRate := func(tx *BtcTransaction, msgTx *wire.MsgTx) int64 {
+		return tx.GetFee() * 1000 / int64(virtualSize(msgTx, nil, nil))
+	}
+
+	for _, m := range []int{2, 3} {
+		for _, segWitType := range []wallet.SegWitType{wallet.SegWitNone, wallet.SegWitScript, wallet.SegWitNative} {
+			treasury, err := wallet.NewMultisigWallet(m, keys, btcChainId, segWitType)
+			require.NoError(t, err)
+			addr := treasury.DeriveNativeAddress()
+
+			var unspents []BtcUnspent
+			var prevTxs []*wire.MsgTx
+			for i := 0; i < 2; i++ {
+				unspent, prevTx := fakePrevTx(t, addr, 0.3, fmt.Sprintf("fee%d-%d-%d", m, segWitType, i))
+				unspent.RedeemScript = hex.EncodeToString(treasury.RedeemScript())
+				unspent.WitnessScript = hex.EncodeToString(treasury.WitnessScript())
+				unspents = append(unspents, unspent)
+				prevTxs = append(prevTxs, prevTx)
+			}
+			outputs := []BtcOutput{{Address: to, Amount: BtcToSatoshi(0.5)}}
+			tx, err := NewBtcTransaction(unspents, outputs, addr, feePerKb, chainParams, &BtcTxOptions{EnableRBF: true})
+			require.NoError(t, err)
+			require.Len(t, tx.Tx.TxIn, 2)
+
+			// the signed transaction pays the requested rate, the estimate
+			// takes the largest signatures
+			msgTx := sign(tx, m, segWitType, prevTxs...)
+			rate := feeRate(tx, msgTx)
+			fmt.Printf("%d-of-5, segwit type %d: %d sat/kvB\n", m, segWitType, rate)
+			require.GreaterOrEqual(t, rate, int64(feePerKb))
+			require.Less(t, rate, int64(feePerKb*103/100))
+			if segWitType != wallet.SegWitNative {
+				// sized as p2sh-p2wpkh the fee is short
+				require.Less(t, estimateVirtualSize(tx.PrevScripts, nil, tx.Tx.TxOut, 0), virtualSize(msgTx, nil, nil))
+			}
+
+			// the replacement pays the new rate too
+			bumped, err := BumpFee(tx, 2*feePerKb, nil)
+			require.NoError(t, err)
+			rate = feeRate(bumped, sign(bumped, m, segWitType, prevTxs...))
+			require.GreaterOrEqual(t, rate, int64(2*feePerKb))
+			require.Less(t, rate, int64(2*feePerKb*103/100))
+
+			var buf bytes.Buffer
+			require.NoError(t, msgTx.Serialize(&buf))
+			bumped, err = BumpFeeRawTx(hex.EncodeToString(buf.Bytes()), unspents, 2*feePerKb,
+				chainParams, &BumpFeeOptions{ChangeAddress: addr})
+			require.NoError(t, err)
+			rate = feeRate(bumped, sign(bumped, m, segWitType, prevTxs...))
+			require.GreaterOrEqual(t, rate, int64(2*feePerKb))
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txauthor"
+	"github.com/btcsuite/btcwallet/wallet/txrules"
+)
+
+const (
+	// RbfSequence is the input sequence which signals BIP-125 replaceability.
+	RbfSequence = wire.MaxTxInSequenceNum - 2
+
+	// DefaultIncrementalRelayFeePerKb is the minimum fee rate a replacement
+	// has to add on top of the original fee, as bitcoind's
+	// -incrementalrelayfee.
+	DefaultIncrementalRelayFeePerKb = 1000
+)
+
+var (
+	ErrNotReplaceable = errors.New("transaction does not signal replaceability")
+	ErrFeeRateTooLow  = errors.New("new fee rate must be higher than the original")
+)
+
+type BumpFeeOptions struct {
+	// ExtraUnspents are spent when the change can not pay the new fee. BIP-125
+	// does not allow new unconfirmed inputs, so they must be confirmed.
+	ExtraUnspents []BtcUnspent
+	// ChangeAddress receives the change of the extra inputs when the original
+	// transaction has no change. For BumpFeeRawTx it also identifies the
+	// change output of the original transaction.
+	ChangeAddress btcutil.Address
+	// IncrementalRelayFeePerKb is DefaultIncrementalRelayFeePerKb if 0.
+	IncrementalRelayFeePerKb int64
+}
+
+// IsReplaceable reports whether any input signals BIP-125 replaceability.
+func (t *BtcTransaction) IsReplaceable() bool {
+	return isReplaceable(t.Tx)
+}
+
+// BumpFee builds an unsigned replacement of orig paying feePerKb. The
+// replacement spends the same inputs and pays the same outputs, the higher fee
+// is taken from the change, and extra inputs are added when the change is not
+// enough. It meets the BIP-125 rules: a higher absolute fee which also pays
+// the incremental relay fee for its own size.
+func BumpFee(orig *BtcTransaction, feePerKb int64, opts *BumpFeeOptions) (*BtcTransaction, error) {
+	var changeScript []byte
+	if orig.ChangeIndex >= 0 {
+		changeScript = orig.Tx.TxOut[orig.ChangeIndex].PkScript
+	}
+	return bumpFee(orig.Tx, orig.PrevScripts, orig.inputScripts, orig.PrevInputValues, orig.ChangeIndex,
+		changeScript, feePerKb, orig.chainParams, opts)
+}
+
+// BumpFeeRawTx is BumpFee for a raw transaction, prevouts are the unspents
+// spent by it.
+func BumpFeeRawTx(rawHex string, prevouts []BtcUnspent, feePerKb int64,
+	chainCfg *chaincfg.Params, opts *BumpFeeOptions) (*BtcTransaction, error) {
+
+	msgTx, err := decodeMsgTx(rawHex)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make(map[wire.OutPoint]*coinCandidate, len(prevouts))
+	for _, u := range prevouts {
+		c, err := newCoinCandidate(u)
+		if err != nil {
+			return nil, err
+		}
+		candidates[c.outPoint] = c
+	}
+	prevScripts := make([][]byte, 0, len(msgTx.TxIn))
+	scripts := make([]inputScripts, 0, len(msgTx.TxIn))
+	inputValues := make([]btcutil.Amount, 0, len(msgTx.TxIn))
+	for _, in := range msgTx.TxIn {
+		c, ok := candidates[in.PreviousOutPoint]
+		if !ok {
+			return nil, fmt.Errorf("missing prevout %s", in.PreviousOutPoint)
+		}
+		prevScripts = append(prevScripts, c.pkScript)
+		scripts = append(scripts, c.scripts)
+		inputValues = append(inputValues, c.value)
+	}
+
+	changeIndex := -1
+	var changeScript []byte
+	if opts != nil && opts.ChangeAddress != nil {
+		changeScript, err = txscript.PayToAddrScript(opts.ChangeAddress)
+		if err != nil {
+			return nil, err
+		}
+		for i, out := range msgTx.TxOut {
+			if bytes.Equal(out.PkScript, changeScript) {
+				changeIndex = i
+				break
+			}
+		}
+	}
+
+	return bumpFee(msgTx, prevScripts, scripts, inputValues, changeIndex, changeScript, feePerKb, chainCfg, opts)
+}
+
+func bumpFee(origTx *wire.MsgTx, prevScripts [][]byte, scripts []inputScripts, inputValues []btcutil.Amount,
+	changeIndex int, changeScript []byte, feePerKb int64, chainCfg *chaincfg.Params, opts *BumpFeeOptions) (*BtcTransaction, error) {
+
+	if !isReplaceable(origTx) {
+		return nil, ErrNotReplaceable
+	}
+	if len(prevScripts) != len(origTx.TxIn) || len(inputValues) != len(origTx.TxIn) {
+		return nil, errors.New("missing previous outputs")
+	}
+	if opts == nil {
+		opts = &BumpFeeOptions{}
+	}
+	incrementalFeePerKb := btcutil.Amount(opts.IncrementalRelayFeePerKb)
+	if incrementalFeePerKb <= 0 {
+		incrementalFeePerKb = DefaultIncrementalRelayFeePerKb
+	}
+	if changeScript == nil && opts.ChangeAddress != nil {
+		var err error
+		changeScript, err = txscript.PayToAddrScript(opts.ChangeAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the payments stay the same, only the change can be reduced
+	var totalInput btcutil.Amount
+	for _, v := range inputValues {
+		totalInput += v
+	}
+	origFee := totalInput - txauthor.SumOutputValues(origTx.TxOut)
+	origSize := virtualSize(origTx, prevScripts, scripts)
+	if btcutil.Amount(feePerKb) <= origFee*1000/btcutil.Amount(origSize) {
+		return nil, ErrFeeRateTooLow
+	}
+
+	txOuts := make([]*wire.TxOut, 0, len(origTx.TxOut))
+	for i, out := range origTx.TxOut {
+		if i != changeIndex {
+			txOuts = append(txOuts, wire.NewTxOut(out.Value, out.PkScript))
+		}
+	}
+	targetAmount := txauthor.SumOutputValues(txOuts)
+
+	txIns := make([]*wire.TxIn, 0, len(origTx.TxIn))
+	for _, in := range origTx.TxIn {
+		txIn := wire.NewTxIn(&in.PreviousOutPoint, nil, nil)
+		txIn.Sequence = RbfSequence
+		txIns = append(txIns, txIn)
+	}
+	prevScripts = append([][]byte(nil), prevScripts...)
+	inputValues = append([]btcutil.Amount(nil), inputValues...)
+	scripts = append(make([]inputScripts, 0, len(prevScripts)), scripts...)
+	scripts = scripts[:len(prevScripts)]
+
+	requiredFee := func(size int) btcutil.Amount {
+		fee := txrules.FeeForSerializeSize(btcutil.Amount(feePerKb), size)
+		minFee := origFee + txrules.FeeForSerializeSize(incrementalFeePerKb, size)
+		if fee < minFee {
+			fee = minFee
+		}
+		return fee
+	}
+
+	extras := opts.ExtraUnspents
+	for {
+		newTx := &wire.MsgTx{Version: origTx.Version, TxIn: txIns, TxOut: txOuts, LockTime: origTx.LockTime}
+		authored := txauthor.AuthoredTx{
+			Tx:              newTx,
+			PrevScripts:     prevScripts,
+			PrevInputValues: inputValues,
+			TotalInput:      totalInput,
+			ChangeIndex:     -1,
+		}
+
+		if changeScript != nil {
+			fee := requiredFee(estimateVirtualSize(prevScripts, scripts, txOuts, len(changeScript)))
+			change := wire.NewTxOut(int64(totalInput-targetAmount-fee), changeScript)
+			if change.Value > 0 && !txrules.IsDustOutput(change, txrules.DefaultRelayFeePerKb) {
+				// keep the change at the position of the original change
+				pos := changeIndex
+				if pos < 0 || pos > len(txOuts) {
+					pos = len(txOuts)
+				}
+				outs := make([]*wire.TxOut, 0, len(txOuts)+1)
+				outs = append(outs, txOuts[:pos]...)
+				outs = append(outs, change)
+				newTx.TxOut = append(outs, txOuts[pos:]...)
+				authored.ChangeIndex = pos
+				return &BtcTransaction{authored, chainCfg, feePerKb, nil, scripts}, nil
+			}
+		}
+
+		// without change, the rest goes to the fee
+		fee := requiredFee(estimateVirtualSize(prevScripts, scripts, txOuts, 0))
+		if totalInput-targetAmount >= fee {
+			return &BtcTransaction{authored, chainCfg, feePerKb, nil, scripts}, nil
+		}
+
+		if len(extras) == 0 {
+			return nil, ErrInsufficientFunds
+		}
+		if changeScript == nil {
+			return nil, errors.New("change address is required to add inputs")
+		}
+		c, err := newCoinCandidate(extras[0])
+		if err != nil {
+			return nil, err
+		}
+		extras = extras[1:]
+
+		txIn := wire.NewTxIn(&c.outPoint, nil, nil)
+		txIn.Sequence = RbfSequence
+		txIns = append(txIns, txIn)
+		prevScripts = append(prevScripts, c.pkScript)
+		scripts = append(scripts, c.scripts)
+		inputValues = append(inputValues, c.value)
+		totalInput += c.value
+	}
+}
+
+func decodeMsgTx(rawHex string) (*wire.MsgTx, error) {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, err
+	}
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	if err = msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return msgTx, nil
+}
+
+func isReplaceable(tx *wire.MsgTx) bool {
+	for _, in := range tx.TxIn {
+		if in.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// virtualSize is the size of a signed transaction, or the estimated size of
+// an unsigned one.
+func virtualSize(tx *wire.MsgTx, prevScripts [][]byte, scripts []inputScripts) int {
+	for _, in := range tx.TxIn {
+		if len(in.SignatureScript) == 0 && len(in.Witness) == 0 {
+			return estimateVirtualSize(prevScripts, scripts, tx.TxOut, 0)
+		}
+	}
+	return (tx.SerializeSizeStripped()*3 + tx.SerializeSize() + 3) / 4
+}
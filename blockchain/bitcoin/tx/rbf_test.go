@@ -0,0 +1,76 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBumpFee(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := hdw.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewNativeSegWitWallet(0, 0, 1)
+	require.NoError(t, err)
+	bw0 := w0.(*wallet.BtcWallet)
+	addr0 := bw0.DeriveNativeAddress()
+	addr1 := w1.(*wallet.BtcWallet).DeriveNativeAddress()
+
+	unspents := []BtcUnspent{fakeUnspent(t, addr0, 0.5, "rbf0")}
+	extra := fakeUnspent(t, addr0, 0.3, "rbf1")
+	outputs := []BtcOutput{{Address: addr1, Amount: BtcToSatoshi(0.4)}}
+
+	orig, err := NewBtcTransaction(unspents, outputs, addr0, 10*1000, chainParams, &BtcTxOptions{EnableRBF: true})
+	require.NoError(t, err)
+	require.True(t, orig.IsReplaceable())
+	require.NoError(t, orig.Sign(bw0))
+
+	{ // pay the higher fee from the change
+		bumped, err := BumpFee(orig, 30*1000, nil)
+		require.NoError(t, err)
+		require.True(t, bumped.IsReplaceable())
+		require.Greater(t, bumped.GetFee(), orig.GetFee())
+		require.Len(t, bumped.Tx.TxIn, 1)
+		require.Less(t, bumped.Tx.TxOut[bumped.ChangeIndex].Value, orig.Tx.TxOut[orig.ChangeIndex].Value)
+		require.NoError(t, bumped.Sign(bw0))
+		fmt.Println("fee:", orig.GetFee(), "->", bumped.GetFee())
+	}
+
+	{ // the fee rate must increase
+		_, err := BumpFee(orig, 10*1000, nil)
+		require.ErrorIs(t, err, ErrFeeRateTooLow)
+	}
+
+	{ // add an input when the change is not enough
+		var buf bytes.Buffer
+		require.NoError(t, orig.Tx.Serialize(&buf))
+		_, err = BumpFeeRawTx(hex.EncodeToString(buf.Bytes()), unspents, 100000*1000, chainParams,
+			&BumpFeeOptions{ChangeAddress: addr0})
+		require.ErrorIs(t, err, ErrInsufficientFunds)
+
+		bumped, err := BumpFeeRawTx(hex.EncodeToString(buf.Bytes()), unspents, 100000*1000, chainParams,
+			&BumpFeeOptions{ChangeAddress: addr0, ExtraUnspents: []BtcUnspent{extra}})
+		require.NoError(t, err)
+		require.Len(t, bumped.Tx.TxIn, 2)
+		require.Greater(t, bumped.GetFee(), orig.GetFee())
+		require.NoError(t, bumped.Sign(bw0))
+	}
+
+	{ // not replaceable
+		final, err := NewBtcTransaction(unspents, outputs, addr0, 10*1000, chainParams, nil)
+		require.NoError(t, err)
+		_, err = BumpFee(final, 30*1000, nil)
+		require.ErrorIs(t, err, ErrNotReplaceable)
+	}
+}
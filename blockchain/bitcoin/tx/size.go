@@ -0,0 +1,169 @@
+package tx
+
+import (
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcwallet/wallet/txsizes"
+)
+
+// multisigSigSize is the push of a DER signature with its sighash byte at the
+// largest.
+const multisigSigSize = 1 + 73
+
+// inputScripts are the scripts an input reveals when it's signed: the redeem
+// script of a P2SH output and the witness script of a P2WSH output. Inputs
+// without them are sized as single key inputs.
+type inputScripts struct {
+	redeemScript  []byte
+	witnessScript []byte
+}
+
+func unspentInputScripts(u BtcUnspent) (inputScripts, error) {
+	var scripts inputScripts
+	var err error
+	if u.RedeemScript != "" {
+		if scripts.redeemScript, err = hex.DecodeString(u.RedeemScript); err != nil {
+			return scripts, err
+		}
+	}
+	if u.WitnessScript != "" {
+		if scripts.witnessScript, err = hex.DecodeString(u.WitnessScript); err != nil {
+			return scripts, err
+		}
+	}
+	return scripts, nil
+}
+
+// unspentsInputScripts returns the scripts of the inputs of tx, which spends
+// some of unspents, nil if none has scripts.
+func unspentsInputScripts(tx *wire.MsgTx, unspents []BtcUnspent) ([]inputScripts, error) {
+	byOutPoint := make(map[wire.OutPoint]inputScripts)
+	for _, u := range unspents {
+		if u.RedeemScript == "" && u.WitnessScript == "" {
+			continue
+		}
+		c, err := newCoinCandidate(u)
+		if err != nil {
+			return nil, err
+		}
+		byOutPoint[c.outPoint] = c.scripts
+	}
+	if len(byOutPoint) == 0 {
+		return nil, nil
+	}
+	scripts := make([]inputScripts, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		scripts[i] = byOutPoint[in.PreviousOutPoint]
+	}
+	return scripts, nil
+}
+
+// multisigInputSize returns the size without the witness and the witness
+// weight of an input spending a multisig script, ok is false for other
+// inputs: m signatures, the script, and the script hash of a nested P2WSH in
+// the signature script.
+func multisigInputSize(pkScript []byte, scripts inputScripts) (baseSize, witnessWeight int, ok bool) {
+	script := scripts.redeemScript
+	if scripts.witnessScript != nil {
+		script = scripts.witnessScript
+	}
+	if script == nil {
+		return 0, 0, false
+	}
+	if isMultisig, err := txscript.IsMultisigScript(script); err != nil || !isMultisig {
+		return 0, 0, false
+	}
+	_, required, err := txscript.CalcMultiSigStats(script)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var sigScriptSize int
+	switch {
+	case scripts.witnessScript != nil:
+		// the extra empty item is popped by the OP_CHECKMULTISIG bug
+		witnessWeight = wire.VarIntSerializeSize(uint64(required+2)) + 1 + required*multisigSigSize +
+			wire.VarIntSerializeSize(uint64(len(script))) + len(script)
+		if txscript.IsPayToScriptHash(pkScript) {
+			// the push of the P2WSH program
+			sigScriptSize = 1 + 34
+		}
+	case txscript.IsPayToScriptHash(pkScript):
+		sigScriptSize = 1 + required*multisigSigSize + pushDataSize(len(script))
+	default:
+		return 0, 0, false
+	}
+	baseSize = 32 + 4 + wire.VarIntSerializeSize(uint64(sigScriptSize)) + sigScriptSize + 4
+	return baseSize, witnessWeight, true
+}
+
+func pushDataSize(size int) int {
+	switch {
+	case size <= txscript.OP_DATA_75:
+		return 1 + size
+	case size <= 0xff:
+		return 2 + size
+	}
+	return 3 + size
+}
+
+// inputVirtualSize estimates the signed size of an input in vbytes.
+func inputVirtualSize(pkScript []byte, scripts inputScripts) int {
+	baseSize, witnessWeight, ok := multisigInputSize(pkScript, scripts)
+	if !ok {
+		return txsizes.GetMinInputVirtualSize(pkScript)
+	}
+	return baseSize + (witnessWeight+blockchain.WitnessScaleFactor-1)/blockchain.WitnessScaleFactor
+}
+
+// estimateVirtualSize estimates the signed size of a transaction spending
+// prevScripts, changeScriptSize is 0 if there is no change. scripts are the
+// inputScripts of prevScripts, nil if none has any. Single key inputs are
+// sized as txsizes.EstimateVirtualSize does.
+func estimateVirtualSize(prevScripts [][]byte, scripts []inputScripts, txOuts []*wire.TxOut, changeScriptSize int) int {
+	baseSize := 8 + wire.VarIntSerializeSize(uint64(len(prevScripts))) +
+		wire.VarIntSerializeSize(uint64(len(txOuts))) + txsizes.SumOutputSerializeSizes(txOuts)
+	if changeScriptSize > 0 {
+		baseSize += 8 + wire.VarIntSerializeSize(uint64(changeScriptSize)) + changeScriptSize
+	}
+
+	var witnessWeight, witnessInputs int
+	for i, pkScript := range prevScripts {
+		var inScripts inputScripts
+		if i < len(scripts) {
+			inScripts = scripts[i]
+		}
+		if size, weight, ok := multisigInputSize(pkScript, inScripts); ok {
+			baseSize += size
+			witnessWeight += weight
+			if weight > 0 {
+				witnessInputs++
+			}
+			continue
+		}
+
+		switch {
+		case txscript.IsPayToScriptHash(pkScript):
+			baseSize += txsizes.RedeemNestedP2WPKHInputSize
+			witnessWeight += txsizes.RedeemP2WPKHInputWitnessWeight
+		case txscript.IsPayToWitnessPubKeyHash(pkScript):
+			baseSize += txsizes.RedeemP2WPKHInputSize
+			witnessWeight += txsizes.RedeemP2WPKHInputWitnessWeight
+		case txscript.IsPayToTaproot(pkScript):
+			baseSize += txsizes.RedeemP2TRInputSize
+			witnessWeight += txsizes.RedeemP2TRInputWitnessWeight
+		default:
+			baseSize += txsizes.RedeemP2PKHInputSize
+			continue
+		}
+		witnessInputs++
+	}
+	if witnessInputs > 0 {
+		// marker, flag and, as txsizes counts it, the number of inputs
+		witnessWeight += 2 + wire.VarIntSerializeSize(uint64(witnessInputs))
+	}
+	return baseSize + (witnessWeight+blockchain.WitnessScaleFactor-1)/blockchain.WitnessScaleFactor
+}
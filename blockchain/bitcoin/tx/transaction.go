@@ -15,12 +15,16 @@ import (
 	"github.com/btcsuite/btcwallet/wallet/txauthor"
 )
 
+// BtcUnspent is an output to spend. The redeem script of a P2SH output and
+// the witness script of a P2WSH output, in hex as listunspent has them, size
+// the multisig inputs for the fee.
 type BtcUnspent struct {
-	TxID         string  `json:"txid"`
-	Vout         uint32  `json:"vout"`
-	ScriptPubKey string  `json:"scriptPubKey"`
-	RedeemScript string  `json:"redeemScript,omitempty"`
-	Amount       float64 `json:"amount"`
+	TxID          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	ScriptPubKey  string  `json:"scriptPubKey"`
+	RedeemScript  string  `json:"redeemScript,omitempty"`
+	WitnessScript string  `json:"witnessScript,omitempty"`
+	Amount        float64 `json:"amount"`
 }
 
 type BtcOutput struct {
@@ -28,14 +32,34 @@ type BtcOutput struct {
 	Amount  int64           `json:"amount"`
 }
 
+// BtcTxOptions are the optional parameters of NewBtcTransaction, nil means
+// the defaults.
+type BtcTxOptions struct {
+	// CoinSelection is the strategy to pick the unspents, CoinSelectionInOrder
+	// by default.
+	CoinSelection CoinSelectionStrategy
+	// LongTermFeePerKb is the fee rate expected for spending the unspents
+	// later, it is used by the waste metric. DefaultLongTermFeePerKb if 0.
+	LongTermFeePerKb int64
+	// EnableRBF signals BIP-125 replaceability, so the fee can be bumped
+	// later with BumpFee.
+	EnableRBF bool
+	// Sweep allows empty outputs, everything but the fee is sent to the
+	// change address.
+	Sweep bool
+}
+
 type BtcTransaction struct {
 	txauthor.AuthoredTx
 	chainParams *chaincfg.Params
 	feePerKb    int64
+	selection   *CoinSelection
+	// inputScripts are of the inputs, nil if none has any
+	inputScripts []inputScripts
 }
 
 func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
-	changeAddress btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params) (*BtcTransaction, error) {
+	changeAddress btcutil.Address, feePerKb int64, chainCfg *chaincfg.Params, opts *BtcTxOptions) (*BtcTransaction, error) {
 
 	if len(unspents) == 0 || changeAddress == nil || feePerKb <= 0 {
 		return nil, errors.New("wrong params")
@@ -48,6 +72,10 @@ func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 		return nil, err
 	}
 
+	if len(outputs) == 0 && (opts == nil || !opts.Sweep) {
+		return nil, errors.New("tx output is empty")
+	}
+
 	feeRatePerKb := btcutil.Amount(feePerKb)
 
 	txOuts, err := makeTxOutputs(outputs, feeRatePerKb, chainCfg)
@@ -62,9 +90,31 @@ func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 		ScriptSize: len(changeBytes),
 	}
 
-	unsignedTx, err := txauthor.NewUnsignedTransaction(txOuts, feeRatePerKb, makeInputSource(unspents), &changeSource)
-	if err != nil {
-		return nil, err
+	strategy := CoinSelectionInOrder
+	if opts != nil {
+		strategy = opts.CoinSelection
+	}
+	// txauthor sizes every input as a single key one
+	var selection *CoinSelection
+	var unsignedTx *txauthor.AuthoredTx
+	if strategy == CoinSelectionInOrder && !hasMultisigInputs(unspents) {
+		unsignedTx, err = txauthor.NewUnsignedTransaction(txOuts, feeRatePerKb, makeInputSource(unspents), &changeSource)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		selector, err := newCoinSelector(unspents, txOuts, changeBytes, feePerKb, opts)
+		if err != nil {
+			return nil, err
+		}
+		selection, err = selector.selectCoins(strategy)
+		if err != nil {
+			return nil, err
+		}
+		unsignedTx, err = selection.authoredTx(txOuts, changeBytes)
+		if err != nil {
+			return nil, err
+		}
 	}
 	// Randomize change position, if change exists, before signing.  This
 	// doesn't affect the serialize size, so the change amount will still
@@ -72,14 +122,41 @@ func NewBtcTransaction(unspents []BtcUnspent, outputs []BtcOutput,
 	if unsignedTx.ChangeIndex >= 0 {
 		unsignedTx.RandomizeChangePosition()
 	}
+	if opts != nil && opts.EnableRBF {
+		for _, in := range unsignedTx.Tx.TxIn {
+			in.Sequence = RbfSequence
+		}
+	}
+
+	scripts, err := unspentsInputScripts(unsignedTx.Tx, unspents)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BtcTransaction{*unsignedTx, chainCfg, feePerKb, selection, scripts}, nil
+}
 
-	return &BtcTransaction{*unsignedTx, chainCfg, feePerKb}, nil
+func hasMultisigInputs(unspents []BtcUnspent) bool {
+	for _, u := range unspents {
+		if u.RedeemScript == "" && u.WitnessScript == "" {
+			continue
+		}
+		if c, err := newCoinCandidate(u); err == nil {
+			if _, _, ok := multisigInputSize(c.pkScript, c.scripts); ok {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (t *BtcTransaction) Sign(wallet *wallet.BtcWallet) error {
 	return t.SignWithSecretsSource(wallet)
 }
 
+// SignWithSecretsSource signs every input with the keys of secretsSource. The
+// only script hash input it signs is p2sh-p2wpkh, multisig inputs are signed
+// with ToPsbt.
 func (t *BtcTransaction) SignWithSecretsSource(secretsSource txauthor.SecretsSource) error {
 	err := t.AddAllInputScripts(secretsSource)
 	if err != nil {
@@ -98,6 +175,12 @@ func (t *BtcTransaction) GetFee() int64 {
 	return int64(fee)
 }
 
+// CoinSelection returns the selection of the coin selection strategy, it is
+// nil for the default in order strategy of single key unspents.
+func (t *BtcTransaction) CoinSelection() *CoinSelection {
+	return t.selection
+}
+
 func (t *BtcTransaction) Decode() *btcjson.TxRawDecodeResult {
 	return DecodeMsgTx(t.Tx, t.chainParams)
 }
@@ -139,10 +222,6 @@ func BtcToSatoshi(v float64) int64 {
 
 func makeTxOutputs(outputs []BtcOutput, relayFeePerKb btcutil.Amount, chainCfg *chaincfg.Params) ([]*wire.TxOut, error) {
 	outLen := len(outputs)
-	if outLen == 0 {
-		return nil, errors.New("tx output is empty")
-	}
-
 	txOuts := make([]*wire.TxOut, 0, outLen)
 	for i := 0; i < outLen; i++ {
 		out := &outputs[i]
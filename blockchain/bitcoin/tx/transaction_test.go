@@ -66,7 +66,7 @@ func TestTransaction(t *testing.T) {
 		out3 := BtcOutput{Address: addrA3, Amount: BtcToSatoshi(transferAmount)}
 
 		tx, err = NewBtcTransaction([]BtcUnspent{unspent}, []BtcOutput{out1, out2, out3},
-			addrA0, feePerKb, chainParams)
+			addrA0, feePerKb, chainParams, nil)
 		require.NoError(t, err)
 	}
 
@@ -86,3 +86,43 @@ func TestTransaction(t *testing.T) {
 		fmt.Println("decoded tx:", string(b))
 	}
 }
+
+func TestTaprootTransaction(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	btcChainId := wallet.BtcChainRegtest
+	chainParams, _ := wallet.GetBtcChainParams(btcChainId)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", btcChainId, wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := hdw.NewTaprootWallet(0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewTaprootWallet(0, 0, 1)
+	require.NoError(t, err)
+	bw0 := w0.(*wallet.BtcWallet)
+	bw1 := w1.(*wallet.BtcWallet)
+
+	unspents := []BtcUnspent{
+		fakeUnspent(t, bw0.DeriveNativeAddress(), 0.5, "taproot0"),
+		fakeUnspent(t, bw0.DeriveNativeAddress(), 0.7, "taproot1"),
+	}
+	outputs := []BtcOutput{{Address: bw1.DeriveNativeAddress(), Amount: BtcToSatoshi(1)}}
+
+	tx, err := NewBtcTransaction(unspents, outputs, bw0.DeriveNativeAddress(), 20*1000, chainParams, nil)
+	require.NoError(t, err)
+
+	// key path spend, checked by the script engine
+	err = tx.Sign(bw1)
+	require.Error(t, err)
+	err = tx.Sign(bw0)
+	require.NoError(t, err)
+	for _, in := range tx.Tx.TxIn {
+		require.Len(t, in.Witness, 1)
+		require.Len(t, in.Witness[0], 64)
+	}
+
+	ret := tx.Decode()
+	require.Equal(t, "witness_v1_taproot", ret.Vout[0].ScriptPubKey.Type)
+	fmt.Println("fee:", tx.GetFee())
+}
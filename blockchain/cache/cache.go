@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultMaxEntries  = 10000
+	DefaultTTL         = 24 * time.Hour
+	DefaultLoadTimeout = 30 * time.Second
+)
+
+// Backend stores the values of a Cache, e.g. Memory or a redis client. A ttl
+// of 0 never expires.
+type Backend interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// Memory is an in-memory LRU Backend of at most maxEntries values.
+type Memory struct {
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List // of *memoryEntry, the most recent first
+}
+
+func NewMemory(maxEntries int) *Memory {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Memory{maxEntries: maxEntries, now: time.Now, entries: map[string]*list.Element{}}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && !m.now().Before(entry.expires) {
+		m.remove(elem)
+		return nil, false
+	}
+	m.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = m.now().Add(ttl)
+	}
+	if elem, ok := m.entries[key]; ok {
+		elem.Value = entry
+		m.lru.MoveToFront(elem)
+		return
+	}
+	m.entries[key] = m.lru.PushFront(entry)
+	for m.lru.Len() > m.maxEntries {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *Memory) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+}
+
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+func (m *Memory) remove(elem *list.Element) {
+	m.lru.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryEntry).key)
+}
+
+type Options struct {
+	// Backend is NewMemory(DefaultMaxEntries) if nil.
+	Backend Backend
+	// TTL is the ttl of the values, DefaultTTL if 0 and no expiry if
+	// negative.
+	TTL time.Duration
+	// LoadTimeout bounds a load, which outlives the ctx of its caller.
+	// DefaultLoadTimeout if 0.
+	LoadTimeout time.Duration
+}
+
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Shared counts the misses which waited for the load of another caller.
+	Shared uint64
+}
+
+// Cache is a read-through cache: a miss loads the value once for all the
+// concurrent callers of the same key.
+type Cache struct {
+	backend     Backend
+	ttl         time.Duration
+	loadTimeout time.Duration
+	group       singleflight.Group
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	shared atomic.Uint64
+}
+
+func New(opts Options) *Cache {
+	c := &Cache{backend: opts.Backend, ttl: opts.TTL, loadTimeout: opts.LoadTimeout}
+	if c.backend == nil {
+		c.backend = NewMemory(DefaultMaxEntries)
+	}
+	if c.loadTimeout <= 0 {
+		c.loadTimeout = DefaultLoadTimeout
+	}
+	if c.ttl == 0 {
+		c.ttl = DefaultTTL
+	} else if c.ttl < 0 {
+		c.ttl = 0
+	}
+	return c
+}
+
+func (c *Cache) Backend() Backend {
+	return c.backend
+}
+
+func (c *Cache) Stats() Stats {
+	return Stats{Hits: c.hits.Load(), Misses: c.misses.Load(), Shared: c.shared.Load()}
+}
+
+// Get returns the value of key, on a miss the one of load which is stored if
+// load reports it's final. The load is shared by the concurrent callers of
+// key, it runs for LoadTimeout with the values but not the cancellation of
+// the ctx of the first caller, and each caller stops waiting on its own ctx.
+func (c *Cache) Get(ctx context.Context, key string, load func(ctx context.Context) (value []byte, final bool, err error)) ([]byte, error) {
+	if value, ok := c.backend.Get(key); ok {
+		c.hits.Add(1)
+		return value, nil
+	}
+	c.misses.Add(1)
+	leader := false
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		leader = true
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.loadTimeout)
+		defer cancel()
+		value, final, err := load(loadCtx)
+		if err == nil && final {
+			c.backend.Set(key, value, c.ttl)
+		}
+		return value, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-ch:
+		if !leader {
+			c.shared.Add(1)
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]byte), nil
+	}
+}
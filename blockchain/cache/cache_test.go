@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	memory := NewMemory(2)
+	memory.now = func() time.Time { return now }
+
+	memory.Set("a", []byte("1"), 0)
+	memory.Set("b", []byte("2"), time.Minute)
+	_, ok := memory.Get("a")
+	require.True(t, ok)
+	// b is the least recently used
+	memory.Set("c", []byte("3"), 0)
+	_, ok = memory.Get("b")
+	require.False(t, ok)
+	value, ok := memory.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), value)
+
+	memory.Set("c", []byte("4"), time.Minute)
+	value, _ = memory.Get("c")
+	require.Equal(t, []byte("4"), value)
+	now = now.Add(time.Minute)
+	_, ok = memory.Get("c")
+	require.False(t, ok)
+	require.Equal(t, 1, memory.Len())
+
+	memory.Delete("a")
+	require.Zero(t, memory.Len())
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Backend: NewMemory(10)})
+
+	{ // concurrent misses load once
+		var loads atomic.Int64
+		release := make(chan struct{})
+		load := func(ctx context.Context) ([]byte, bool, error) {
+			loads.Add(1)
+			<-release
+			return []byte("value"), true, nil
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := c.Get(ctx, "key", load)
+				require.NoError(t, err)
+				require.Equal(t, []byte("value"), value)
+			}()
+		}
+		// every caller is waiting or about to hit
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		require.Equal(t, int64(1), loads.Load())
+
+		value, err := c.Get(ctx, "key", load)
+		require.NoError(t, err)
+		require.Equal(t, []byte("value"), value)
+		stats := c.Stats()
+		fmt.Println("stats:", stats)
+		require.Equal(t, uint64(11), stats.Hits+stats.Misses)
+		require.Equal(t, stats.Misses-1, stats.Shared)
+	}
+
+	{ // values which aren't final and errors aren't stored
+		loads := 0
+		load := func(ctx context.Context) ([]byte, bool, error) {
+			loads++
+			return []byte("pending"), false, nil
+		}
+		for i := 0; i < 2; i++ {
+			_, err := c.Get(ctx, "pending", load)
+			require.NoError(t, err)
+		}
+		require.Equal(t, 2, loads)
+
+		failed := errors.New("failed")
+		_, err := c.Get(ctx, "failed", func(ctx context.Context) ([]byte, bool, error) {
+			return nil, true, failed
+		})
+		require.ErrorIs(t, err, failed)
+		_, ok := c.Backend().Get("failed")
+		require.False(t, ok)
+	}
+
+	{ // the load outlives the caller which started it
+		release := make(chan struct{})
+		canceled, cancel := context.WithCancel(ctx)
+		first := make(chan error)
+		go func() {
+			_, err := c.Get(canceled, "shared", func(ctx context.Context) ([]byte, bool, error) {
+				<-release
+				return []byte("shared"), true, ctx.Err()
+			})
+			first <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		second := make(chan []byte)
+		go func() {
+			value, err := c.Get(ctx, "shared", nil)
+			require.NoError(t, err)
+			second <- value
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		require.ErrorIs(t, <-first, context.Canceled)
+		close(release)
+		require.Equal(t, []byte("shared"), <-second)
+	}
+
+	{ // a waiting caller returns on its ctx
+		release := make(chan struct{})
+		defer close(release)
+		go c.Get(ctx, "slow", func(ctx context.Context) ([]byte, bool, error) {
+			<-release
+			return nil, true, nil
+		})
+		time.Sleep(10 * time.Millisecond)
+		timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := c.Get(timeout, "slow", func(ctx context.Context) ([]byte, bool, error) {
+			t.Fatal("loaded twice")
+			return nil, false, nil
+		})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+}
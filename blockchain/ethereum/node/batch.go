@@ -0,0 +1,161 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// DefaultBatchSize stays below the batch limit of geth, 1000 requests.
+const DefaultBatchSize = 100
+
+// BatchResult is the result of one request of a batch, Err fails that request
+// only.
+type BatchResult[T any] struct {
+	Value T
+	Err   error
+}
+
+// batch sends elems in batches of BatchSize. The returned error is of a whole
+// batch, the errors of the requests are in the Error of each elem.
+func (c *EthClient) batch(ctx context.Context, elems []rpc.BatchElem) error {
+	size := c.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	for start := 0; start < len(elems); start += size {
+		end := min(start+size, len(elems))
+		if err := c.client.BatchCallContext(ctx, elems[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchBalanceAt returns the balances of accounts at blockNumber, the latest
+// block if nil.
+func (c *EthClient) BatchBalanceAt(ctx context.Context, accounts []common.Address, blockNumber *big.Int) ([]BatchResult[*big.Int], error) {
+	balances := make([]hexutil.Big, len(accounts))
+	elems := make([]rpc.BatchElem, len(accounts))
+	for i, account := range accounts {
+		elems[i] = rpc.BatchElem{Method: "eth_getBalance", Args: []interface{}{account, toBlockNumArg(blockNumber)}, Result: &balances[i]}
+	}
+	if err := c.batch(ctx, elems); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult[*big.Int], len(accounts))
+	for i, elem := range elems {
+		if results[i].Err = elem.Error; elem.Error == nil {
+			results[i].Value = balances[i].ToInt()
+		}
+	}
+	return results, nil
+}
+
+// BatchNonceAt returns the nonces of accounts at blockNumber, the latest block
+// if nil. big.NewInt(int64(rpc.PendingBlockNumber)) includes the txpool.
+func (c *EthClient) BatchNonceAt(ctx context.Context, accounts []common.Address, blockNumber *big.Int) ([]BatchResult[uint64], error) {
+	nonces := make([]hexutil.Uint64, len(accounts))
+	elems := make([]rpc.BatchElem, len(accounts))
+	for i, account := range accounts {
+		elems[i] = rpc.BatchElem{Method: "eth_getTransactionCount", Args: []interface{}{account, toBlockNumArg(blockNumber)}, Result: &nonces[i]}
+	}
+	if err := c.batch(ctx, elems); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult[uint64], len(accounts))
+	for i, elem := range elems {
+		if results[i].Err = elem.Error; elem.Error == nil {
+			results[i].Value = uint64(nonces[i])
+		}
+	}
+	return results, nil
+}
+
+// BatchTransactionReceipt returns the receipts of hashes, ethereum.NotFound
+// for a transaction which isn't mined.
+func (c *EthClient) BatchTransactionReceipt(ctx context.Context, hashes []common.Hash) ([]BatchResult[*types.Receipt], error) {
+	receipts := make([]*types.Receipt, len(hashes))
+	elems := make([]rpc.BatchElem, len(hashes))
+	for i, hash := range hashes {
+		elems[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{hash}, Result: &receipts[i]}
+	}
+	if err := c.batch(ctx, elems); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult[*types.Receipt], len(hashes))
+	for i, elem := range elems {
+		results[i] = BatchResult[*types.Receipt]{Value: receipts[i], Err: elem.Error}
+		if elem.Error == nil && receipts[i] == nil {
+			results[i].Err = ethereum.NotFound
+		}
+	}
+	return results, nil
+}
+
+// BatchCallContract executes the calls at blockNumber, the latest block if nil.
+// A reverted call fails with its rpc error.
+func (c *EthClient) BatchCallContract(ctx context.Context, msgs []ethereum.CallMsg, blockNumber *big.Int) ([]BatchResult[[]byte], error) {
+	outputs := make([]hexutil.Bytes, len(msgs))
+	elems := make([]rpc.BatchElem, len(msgs))
+	for i, msg := range msgs {
+		elems[i] = rpc.BatchElem{Method: "eth_call", Args: []interface{}{toCallArg(msg), toBlockNumArg(blockNumber)}, Result: &outputs[i]}
+	}
+	if err := c.batch(ctx, elems); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult[[]byte], len(msgs))
+	for i, elem := range elems {
+		results[i] = BatchResult[[]byte]{Value: outputs[i], Err: elem.Error}
+	}
+	return results, nil
+}
+
+// toBlockNumArg and toCallArg are of ethclient.
+func toBlockNumArg(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	if number.Sign() >= 0 {
+		return hexutil.EncodeBig(number)
+	}
+	if number.IsInt64() {
+		return rpc.BlockNumber(number.Int64()).String()
+	}
+	return fmt.Sprintf("<invalid %d>", number)
+}
+
+func toCallArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["input"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.GasPrice != nil {
+		arg["gasPrice"] = (*hexutil.Big)(msg.GasPrice)
+	}
+	if msg.GasFeeCap != nil {
+		arg["maxFeePerGas"] = (*hexutil.Big)(msg.GasFeeCap)
+	}
+	if msg.GasTipCap != nil {
+		arg["maxPriorityFeePerGas"] = (*hexutil.Big)(msg.GasTipCap)
+	}
+	if msg.AccessList != nil {
+		arg["accessList"] = msg.AccessList
+	}
+	return arg
+}
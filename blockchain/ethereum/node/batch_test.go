@@ -0,0 +1,158 @@
+package node
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeMulticall executes aggregate3 calls one by one, as the Multicall3
+// contract does, since the simulated chain doesn't have it.
+type fakeMulticall struct {
+	client  *EthClient
+	address common.Address
+	calls   int
+}
+
+func (f *fakeMulticall) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return f.client.RpcClient.CodeAt(ctx, contract, blockNumber)
+}
+
+func (f *fakeMulticall) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	f.calls++
+	method := multicallABI.Methods["aggregate3"]
+	unpacked, err := method.Inputs.Unpack(call.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	var results []multicall3Result
+	for _, call3 := range unpacked[0].([]struct {
+		Target       common.Address `json:"target"`
+		AllowFailure bool           `json:"allowFailure"`
+		CallData     []byte         `json:"callData"`
+	}) {
+		if call3.Target == f.address {
+			args, err := multicallABI.Methods["getEthBalance"].Inputs.Unpack(call3.CallData[4:])
+			if err != nil {
+				return nil, err
+			}
+			balance, err := f.client.RpcClient.BalanceAt(ctx, args[0].(common.Address), blockNumber)
+			if err != nil {
+				return nil, err
+			}
+			output, _ := multicallABI.Methods["getEthBalance"].Outputs.Pack(balance)
+			results = append(results, multicall3Result{Success: true, ReturnData: output})
+			continue
+		}
+		output, err := f.client.RpcClient.CallContract(ctx, ethereum.CallMsg{To: &call3.Target, Data: call3.CallData}, blockNumber)
+		results = append(results, multicall3Result{Success: err == nil, ReturnData: output})
+	}
+	return method.Outputs.Pack(results)
+}
+
+func TestBatch(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	var wallets []*wallet.EthWallet
+	var addrs []common.Address
+	for i := 0; i < 5; i++ {
+		w, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, i)
+		require.NoError(t, err)
+		wallets = append(wallets, w.(*wallet.EthWallet))
+		addrs = append(addrs, w.(*wallet.EthWallet).DeriveNativeAddress())
+	}
+
+	cli, stop, err := RunSimulated(wallets[0], wallets[1])
+	require.NoError(t, err)
+	defer stop()
+	cli.BatchSize = 2
+	ctx := context.Background()
+
+	head, err := cli.RpcClient.HeaderByNumber(ctx, nil)
+	require.NoError(t, err)
+	signed, err := types.SignNewTx(wallets[0].DeriveNativePrivateKey(), types.LatestSigner(wallets[0].ChainParams()), &types.DynamicFeeTx{
+		To:        &addrs[2],
+		Value:     big.NewInt(1000),
+		Gas:       wallet.EtherTransferGas,
+		GasTipCap: big.NewInt(1e9),
+		GasFeeCap: new(big.Int).Add(big.NewInt(1e9), new(big.Int).Mul(head.BaseFee, big.NewInt(2))),
+	})
+	require.NoError(t, err)
+	require.NoError(t, cli.RpcClient.SendTransaction(ctx, signed))
+	cli.Commit()
+
+	{ // balances
+		balances, err := cli.BatchBalanceAt(ctx, addrs, nil)
+		require.NoError(t, err)
+		require.Len(t, balances, len(addrs))
+		for _, balance := range balances {
+			require.NoError(t, balance.Err)
+		}
+		require.True(t, balances[0].Value.Cmp(SimulatedBalance) < 0)
+		require.Equal(t, SimulatedBalance, balances[1].Value)
+		require.Equal(t, big.NewInt(1000), balances[2].Value)
+		require.Zero(t, balances[4].Value.Sign())
+
+		// a block of the future fails every request, not the batch
+		balances, err = cli.BatchBalanceAt(ctx, addrs[:3], big.NewInt(1000))
+		require.NoError(t, err)
+		for _, balance := range balances {
+			require.Error(t, balance.Err)
+		}
+	}
+
+	{ // nonces
+		nonces, err := cli.BatchNonceAt(ctx, addrs[:3], nil)
+		require.NoError(t, err)
+		require.Equal(t, []BatchResult[uint64]{{Value: 1}, {Value: 0}, {Value: 0}}, nonces)
+	}
+
+	{ // receipts
+		receipts, err := cli.BatchTransactionReceipt(ctx, []common.Hash{signed.Hash(), {1}})
+		require.NoError(t, err)
+		require.NoError(t, receipts[0].Err)
+		require.Equal(t, types.ReceiptStatusSuccessful, receipts[0].Value.Status)
+		require.ErrorIs(t, receipts[1].Err, ethereum.NotFound)
+	}
+
+	{ // calls, the second can't pay its value
+		results, err := cli.BatchCallContract(ctx, []ethereum.CallMsg{
+			{From: addrs[0], To: &addrs[3], Value: big.NewInt(1)},
+			{From: addrs[4], To: &addrs[3], Value: big.NewInt(1)},
+			{From: addrs[1], To: &addrs[3]},
+		}, nil)
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		require.Error(t, results[1].Err)
+		require.NoError(t, results[2].Err)
+	}
+
+	{ // multicall
+		fake := &fakeMulticall{client: cli.EthClient, address: Multicall3Address}
+		multicall := NewMulticall(fake, Multicall3Address)
+		multicall.BatchSize = 2
+		balances, err := multicall.BalanceAt(ctx, addrs, nil)
+		require.NoError(t, err)
+		require.Equal(t, 3, fake.calls)
+		require.Equal(t, SimulatedBalance, balances[1].Value)
+		require.Equal(t, big.NewInt(1000), balances[2].Value)
+
+		// a call to an account without code returns nothing
+		results, err := multicall.Aggregate(ctx, []MulticallCall{{Target: addrs[3], CallData: []byte{1}}}, nil)
+		require.NoError(t, err)
+		require.NoError(t, results[0].Err)
+		require.Empty(t, results[0].Value)
+
+		// the real one is not deployed
+		_, err = cli.Multicall().BalanceAt(ctx, addrs, nil)
+		require.Error(t, err)
+	}
+}
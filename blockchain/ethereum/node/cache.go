@@ -0,0 +1,249 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/cache"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// headRefresh is how often the head is read to tell whether a block is final.
+const headRefresh = time.Second
+
+var erc20MetadataABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(`[
+{"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"stateMutability":"view","type":"function"},
+{"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"stateMutability":"view","type":"function"},
+{"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"stateMutability":"view","type":"function"}
+]`))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+type TokenMetadata struct {
+	Name     string
+	Symbol   string
+	Decimals uint8
+}
+
+// CachedEthClient caches the chain data which can't change: blocks by hash,
+// and blocks by number, transactions and receipts once they're Confirmations
+// deep. Everything else goes to the EthClient.
+type CachedEthClient struct {
+	*EthClient
+	cache *cache.Cache
+	// Confirmations is the number of blocks, including the block of the data,
+	// until it's cached. DefaultTxConfirmations if 0.
+	Confirmations uint64
+	// KeyPrefix is the prefix of the keys, "eth:" if empty. The chains of a
+	// shared Backend need their own prefixes.
+	KeyPrefix string
+
+	mu     sync.Mutex
+	head   uint64
+	headAt time.Time
+}
+
+func NewCachedEthClient(client *EthClient, c *cache.Cache) *CachedEthClient {
+	return &CachedEthClient{EthClient: client, cache: c}
+}
+
+func (c *CachedEthClient) Cache() *cache.Cache {
+	return c.cache
+}
+
+func (c *CachedEthClient) key(kind string, id interface{}) string {
+	prefix := c.KeyPrefix
+	if prefix == "" {
+		prefix = "eth:"
+	}
+	return fmt.Sprintf("%s%s:%v", prefix, kind, id)
+}
+
+// final reports whether the block of number is Confirmations deep. The head
+// is read at most every headRefresh.
+func (c *CachedEthClient) final(ctx context.Context, number uint64) (bool, error) {
+	confirmations := c.Confirmations
+	if confirmations == 0 {
+		confirmations = DefaultTxConfirmations
+	}
+	c.mu.Lock()
+	head, headAt := c.head, c.headAt
+	c.mu.Unlock()
+	if number+confirmations <= head+1 {
+		return true, nil
+	}
+	if time.Since(headAt) < headRefresh {
+		return false, nil
+	}
+	latest, err := c.RpcClient.BlockNumber(ctx)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.head, c.headAt = max(c.head, latest), time.Now()
+	head = c.head
+	c.mu.Unlock()
+	return number+confirmations <= head+1, nil
+}
+
+func (c *CachedEthClient) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	data, err := c.cache.Get(ctx, c.key("block", hash.Hex()), func(ctx context.Context) ([]byte, bool, error) {
+		block, err := c.RpcClient.BlockByHash(ctx, hash)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := rlp.EncodeToBytes(block)
+		return data, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlock(data)
+}
+
+// BlockByNumber caches the blocks of a non-negative number, the ones of a
+// block tag like "latest" are never cached.
+func (c *CachedEthClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if number == nil || number.Sign() < 0 {
+		return c.RpcClient.BlockByNumber(ctx, number)
+	}
+	data, err := c.cache.Get(ctx, c.key("block-number", number), func(ctx context.Context) ([]byte, bool, error) {
+		block, err := c.RpcClient.BlockByNumber(ctx, number)
+		if err != nil {
+			return nil, false, err
+		}
+		final, err := c.final(ctx, block.NumberU64())
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := rlp.EncodeToBytes(block)
+		return data, final, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlock(data)
+}
+
+func decodeBlock(data []byte) (*types.Block, error) {
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(data, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+type cachedTransaction struct {
+	Tx          hexutil.Bytes   `json:"tx"`
+	From        *common.Address `json:"from"`
+	BlockNumber *hexutil.Big    `json:"blockNumber"`
+}
+
+// TransactionByHash is EthClient.TransactionByHash, a pending transaction is
+// never cached.
+func (c *CachedEthClient) TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, *common.Address, *big.Int, error) {
+	data, err := c.cache.Get(ctx, c.key("tx", hash.Hex()), func(ctx context.Context) ([]byte, bool, error) {
+		tx, from, blockNumber, err := c.EthClient.TransactionByHash(ctx, hash)
+		if err != nil {
+			return nil, false, err
+		}
+		binary, err := tx.MarshalBinary()
+		if err != nil {
+			return nil, false, err
+		}
+		final := false
+		if blockNumber != nil {
+			if final, err = c.final(ctx, blockNumber.Uint64()); err != nil {
+				return nil, false, err
+			}
+		}
+		data, err := json.Marshal(cachedTransaction{Tx: binary, From: from, BlockNumber: (*hexutil.Big)(blockNumber)})
+		return data, final, err
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var cached cachedTransaction
+	if err = json.Unmarshal(data, &cached); err != nil {
+		return nil, nil, nil, err
+	}
+	tx := new(types.Transaction)
+	if err = tx.UnmarshalBinary(cached.Tx); err != nil {
+		return nil, nil, nil, err
+	}
+	return tx, cached.From, cached.BlockNumber.ToInt(), nil
+}
+
+func (c *CachedEthClient) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	data, err := c.cache.Get(ctx, c.key("receipt", hash.Hex()), func(ctx context.Context) ([]byte, bool, error) {
+		receipt, err := c.RpcClient.TransactionReceipt(ctx, hash)
+		if err != nil {
+			return nil, false, err
+		}
+		final, err := c.final(ctx, receipt.BlockNumber.Uint64())
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := json.Marshal(receipt)
+		return data, final, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	receipt := new(types.Receipt)
+	if err = json.Unmarshal(data, receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// TokenMetadata returns the name, symbol and decimals of an ERC-20 token.
+// They're cached for the TTL of the cache, a token could be upgraded.
+func (c *CachedEthClient) TokenMetadata(ctx context.Context, token common.Address) (*TokenMetadata, error) {
+	data, err := c.cache.Get(ctx, c.key("token", token.Hex()), func(ctx context.Context) ([]byte, bool, error) {
+		methods := []string{"name", "symbol", "decimals"}
+		msgs := make([]ethereum.CallMsg, len(methods))
+		for i, method := range methods {
+			msgs[i] = ethereum.CallMsg{To: &token, Data: erc20MetadataABI.Methods[method].ID}
+		}
+		results, err := c.BatchCallContract(ctx, msgs, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		outputs := make([]interface{}, len(methods))
+		for i, result := range results {
+			if result.Err != nil {
+				return nil, false, fmt.Errorf("%s: %w", methods[i], result.Err)
+			}
+			unpacked, err := erc20MetadataABI.Unpack(methods[i], result.Value)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", methods[i], err)
+			}
+			outputs[i] = unpacked[0]
+		}
+		data, err := json.Marshal(TokenMetadata{Name: outputs[0].(string), Symbol: outputs[1].(string), Decimals: outputs[2].(uint8)})
+		return data, true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	metadata := new(TokenMetadata)
+	if err = json.Unmarshal(data, metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
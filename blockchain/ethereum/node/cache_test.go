@@ -0,0 +1,123 @@
+package node
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/cache"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+// metadataToken answers every call with the same 96 bytes, which are the
+// string "TST" for name and symbol and 32 for decimals.
+var metadataToken = hexutil.MustDecode("0x606c600c60003960" + "6c6000f3" +
+	"6060600c60003960606000f3" +
+	"0000000000000000000000000000000000000000000000000000000000000020" +
+	"0000000000000000000000000000000000000000000000000000000000000003" +
+	"5453540000000000000000000000000000000000000000000000000000000000")
+
+func TestCachedEthClient(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	w, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	from := w.(*wallet.EthWallet)
+
+	cli, stop, err := RunSimulated(from)
+	require.NoError(t, err)
+	defer stop()
+	ctx := context.Background()
+
+	send := func(nonce uint64, to *common.Address, data []byte) *types.Transaction {
+		head, err := cli.RpcClient.HeaderByNumber(ctx, nil)
+		require.NoError(t, err)
+		signed, err := types.SignNewTx(from.DeriveNativePrivateKey(), types.LatestSigner(from.ChainParams()), &types.DynamicFeeTx{
+			Nonce:     nonce,
+			To:        to,
+			Value:     big.NewInt(0),
+			Gas:       200000,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: new(big.Int).Add(big.NewInt(1e9), new(big.Int).Mul(head.BaseFee, big.NewInt(2))),
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.NoError(t, cli.RpcClient.SendTransaction(ctx, signed))
+		cli.Commit()
+		return signed
+	}
+	deploy := send(0, nil, metadataToken)
+	transfer := send(1, &common.Address{1}, nil)
+
+	memory := cache.NewMemory(100)
+	cached := NewCachedEthClient(cli.EthClient, cache.New(cache.Options{Backend: memory}))
+	cached.Confirmations = 3
+
+	{ // the transfer has 1 confirmation, it isn't final yet
+		for i := 0; i < 2; i++ {
+			tx, sender, blockNumber, err := cached.TransactionByHash(ctx, transfer.Hash())
+			require.NoError(t, err)
+			require.Equal(t, transfer.Hash(), tx.Hash())
+			require.Equal(t, from.DeriveNativeAddress(), *sender)
+			require.Equal(t, uint64(2), blockNumber.Uint64())
+		}
+		_, err := cached.TransactionReceipt(ctx, transfer.Hash())
+		require.NoError(t, err)
+		require.Zero(t, memory.Len())
+		require.Equal(t, cache.Stats{Misses: 3}, cached.Cache().Stats())
+	}
+
+	// 2 more blocks, the head is read again after headRefresh
+	cli.Commit()
+	cli.Commit()
+	cached.headAt = cached.headAt.Add(-headRefresh)
+
+	{ // final
+		for i := 0; i < 2; i++ {
+			tx, _, blockNumber, err := cached.TransactionByHash(ctx, transfer.Hash())
+			require.NoError(t, err)
+			require.Equal(t, transfer.Hash(), tx.Hash())
+			require.Equal(t, uint64(2), blockNumber.Uint64())
+
+			receipt, err := cached.TransactionReceipt(ctx, deploy.Hash())
+			require.NoError(t, err)
+			require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+			require.Equal(t, deploy.Hash(), receipt.TxHash)
+			require.Equal(t, crypto.CreateAddress(from.DeriveNativeAddress(), 0), receipt.ContractAddress)
+
+			block, err := cached.BlockByNumber(ctx, big.NewInt(2))
+			require.NoError(t, err)
+			require.Equal(t, transfer.Hash(), block.Transactions()[0].Hash())
+			byHash, err := cached.BlockByHash(ctx, block.Hash())
+			require.NoError(t, err)
+			require.Equal(t, block.Hash(), byHash.Hash())
+		}
+		require.Equal(t, 4, memory.Len())
+		require.Equal(t, uint64(4), cached.Cache().Stats().Hits)
+
+		// the head is never cached
+		_, err := cached.BlockByNumber(ctx, nil)
+		require.NoError(t, err)
+		block, err := cached.BlockByNumber(ctx, big.NewInt(4))
+		require.NoError(t, err)
+		require.Equal(t, uint64(4), block.NumberU64())
+		require.Equal(t, 4, memory.Len())
+	}
+
+	{ // token metadata
+		metadata, err := cached.TokenMetadata(ctx, crypto.CreateAddress(from.DeriveNativeAddress(), 0))
+		require.NoError(t, err)
+		require.Equal(t, &TokenMetadata{Name: "TST", Symbol: "TST", Decimals: 32}, metadata)
+		require.Equal(t, 5, memory.Len())
+
+		_, err = cached.TokenMetadata(ctx, common.Address{1})
+		require.Error(t, err)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
 	"strconv"
 
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -16,9 +18,14 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
+var _ wallet.HistoryChecker = (*EthClient)(nil)
+
 type EthClient struct {
 	RpcClient *ethclient.Client
 	client    *rpc.Client
+	// BatchSize is the most requests of a json-rpc batch, DefaultBatchSize if
+	// 0.
+	BatchSize int
 }
 
 func NewEthClient(URL string) (*EthClient, error) {
@@ -30,6 +37,16 @@ func NewEthClient(URL string) (*EthClient, error) {
 	return &EthClient{RpcClient: rpcClient, client: client}, nil
 }
 
+// NewEthClientWithTransport returns an EthClient which sends its requests
+// with transport, e.g. a limiter.Guard or an rpcpool.Pool. URL is http(s).
+func NewEthClientWithTransport(URL string, transport http.RoundTripper) (*EthClient, error) {
+	client, err := rpc.DialOptions(context.Background(), URL, rpc.WithHTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		return nil, err
+	}
+	return &EthClient{RpcClient: ethclient.NewClient(client), client: client}, nil
+}
+
 func (c *EthClient) SetHeader(key, value string) {
 	if c.client != nil {
 		c.client.SetHeader(key, value)
@@ -69,6 +86,28 @@ func (c *EthClient) TransactionByHash(ctx context.Context, hash common.Hash) (tx
 	return json.tx, json.From, blockNumber, nil
 }
 
+// HasHistory reports whether address has sent a transaction or holds ether.
+// An address which only received tokens is not detected.
+func (c *EthClient) HasHistory(ctx context.Context, address string) (bool, error) {
+	if !common.IsHexAddress(address) {
+		return false, errors.New("invalid address")
+	}
+	account := common.HexToAddress(address)
+
+	nonce, err := c.RpcClient.NonceAt(ctx, account, nil)
+	if err != nil {
+		return false, err
+	}
+	if nonce > 0 {
+		return true, nil
+	}
+	balance, err := c.RpcClient.BalanceAt(ctx, account, nil)
+	if err != nil {
+		return false, err
+	}
+	return balance.Sign() > 0, nil
+}
+
 type rpcTransaction struct {
 	tx *types.Transaction
 	txExtraInfo
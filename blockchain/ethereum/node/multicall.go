@@ -0,0 +1,147 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Multicall3Address is the address of Multicall3 on most chains, see
+// https://github.com/mds1/multicall.
+var Multicall3Address = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+const Multicall3ABI = `[
+{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"},
+{"inputs":[{"name":"addr","type":"address"}],"name":"getEthBalance","outputs":[{"name":"balance","type":"uint256"}],"stateMutability":"view","type":"function"}
+]`
+
+var multicallABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(Multicall3ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// ErrCallFailed is the error of a reverted call of an aggregate.
+var ErrCallFailed = errors.New("call failed")
+
+// DefaultMulticallSize keeps an aggregate well below the gas cap of eth_call.
+const DefaultMulticallSize = 200
+
+type MulticallCall struct {
+	Target   common.Address
+	CallData []byte
+}
+
+// multicall3Call and multicall3Result are the tuples of aggregate3.
+type multicall3Call struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicall3Result struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// Multicall packs many eth_calls into one call of aggregate3 of a Multicall3
+// contract. The calls are allowed to fail, a failed call doesn't fail the
+// others.
+type Multicall struct {
+	caller  bind.ContractCaller
+	Address common.Address
+	// BatchSize is the most calls of one aggregate, DefaultMulticallSize if 0.
+	BatchSize int
+}
+
+func NewMulticall(caller bind.ContractCaller, address common.Address) *Multicall {
+	return &Multicall{caller: caller, Address: address}
+}
+
+// Multicall returns the aggregator of the Multicall3 at Multicall3Address.
+func (c *EthClient) Multicall() *Multicall {
+	return NewMulticall(c.RpcClient, Multicall3Address)
+}
+
+// Aggregate executes calls at blockNumber, the latest block if nil. The Err
+// of a reverted call is ErrCallFailed and its Value the revert data.
+func (m *Multicall) Aggregate(ctx context.Context, calls []MulticallCall, blockNumber *big.Int) ([]BatchResult[[]byte], error) {
+	size := m.BatchSize
+	if size <= 0 {
+		size = DefaultMulticallSize
+	}
+	results := make([]BatchResult[[]byte], 0, len(calls))
+	for start := 0; start < len(calls); start += size {
+		chunk := calls[start:min(start+size, len(calls))]
+		call3s := make([]multicall3Call, len(chunk))
+		for i, call := range chunk {
+			call3s[i] = multicall3Call{Target: call.Target, AllowFailure: true, CallData: call.CallData}
+		}
+		input, err := multicallABI.Pack("aggregate3", call3s)
+		if err != nil {
+			return nil, err
+		}
+		output, err := m.caller.CallContract(ctx, ethereum.CallMsg{To: &m.Address, Data: input}, blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		if len(output) == 0 {
+			return nil, fmt.Errorf("no multicall contract at %s", m.Address)
+		}
+		unpacked, err := multicallABI.Unpack("aggregate3", output)
+		if err != nil {
+			return nil, err
+		}
+		chunkResults := *abi.ConvertType(unpacked[0], new([]multicall3Result)).(*[]multicall3Result)
+		if len(chunkResults) != len(chunk) {
+			return nil, errors.New("multicall returned a wrong number of results")
+		}
+		for _, result := range chunkResults {
+			item := BatchResult[[]byte]{Value: result.ReturnData}
+			if !result.Success {
+				item.Err = ErrCallFailed
+			}
+			results = append(results, item)
+		}
+	}
+	return results, nil
+}
+
+// BalanceAt returns the balances of accounts with getEthBalance of the
+// Multicall3 contract.
+func (m *Multicall) BalanceAt(ctx context.Context, accounts []common.Address, blockNumber *big.Int) ([]BatchResult[*big.Int], error) {
+	calls := make([]MulticallCall, len(accounts))
+	for i, account := range accounts {
+		input, err := multicallABI.Pack("getEthBalance", account)
+		if err != nil {
+			return nil, err
+		}
+		calls[i] = MulticallCall{Target: m.Address, CallData: input}
+	}
+	outputs, err := m.Aggregate(ctx, calls, blockNumber)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult[*big.Int], len(outputs))
+	for i, output := range outputs {
+		if results[i].Err = output.Err; output.Err != nil {
+			continue
+		}
+		unpacked, err := multicallABI.Unpack("getEthBalance", output.Value)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Value = unpacked[0].(*big.Int)
+	}
+	return results, nil
+}
@@ -0,0 +1,31 @@
+package node
+
+import (
+	"encoding/json"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/rpcpool"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// EthHeightProbe is the health check of Ethereum endpoints.
+var EthHeightProbe = rpcpool.Probe{
+	Method: "eth_blockNumber",
+	ParseHeight: func(result json.RawMessage) (uint64, error) {
+		var height hexutil.Uint64
+		err := json.Unmarshal(result, &height)
+		return uint64(height), err
+	},
+}
+
+// NewEthPool returns a pool of Ethereum endpoints, opts.Probe is
+// EthHeightProbe.
+func NewEthPool(endpoints []rpcpool.EndpointConfig, opts rpcpool.Options) (*rpcpool.Pool, error) {
+	opts.Probe = EthHeightProbe
+	return rpcpool.New(endpoints, opts)
+}
+
+// NewEthPoolClient returns an EthClient which sends its requests through pool.
+func NewEthPoolClient(pool *rpcpool.Pool) (*EthClient, error) {
+	// the url is replaced by the one of an endpoint
+	return NewEthClientWithTransport("http://rpcpool", pool)
+}
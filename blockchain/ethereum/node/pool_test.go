@@ -0,0 +1,67 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/limiter"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/rpcpool"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthPool(t *testing.T) {
+	height := func(number uint64) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ID     json.RawMessage `json:"id"`
+				Method string          `json:"method"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			require.Equal(t, "eth_blockNumber", req.Method)
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x%x"}`, req.ID, number)
+		}))
+	}
+	behind, ahead := height(100), height(200)
+	defer behind.Close()
+	defer ahead.Close()
+
+	pool, err := NewEthPool([]rpcpool.EndpointConfig{{URL: behind.URL}, {URL: ahead.URL}}, rpcpool.Options{})
+	require.NoError(t, err)
+	pool.CheckHealth(context.Background())
+	cli, err := NewEthPoolClient(pool)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		number, err := cli.RpcClient.BlockNumber(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, uint64(200), number)
+	}
+
+	{ // a guard on top of the pool
+		breaker := limiter.NewBreaker("eth", limiter.BreakerOptions{MaxFailures: 1})
+		guard := limiter.NewGuard(limiter.GuardOptions{
+			Transport: pool,
+			Methods:   map[string]limiter.Limiter{"eth_blockNumber": limiter.NewTokenBucket(1, 1)},
+			FailFast:  true,
+			Breaker:   breaker,
+		})
+		cli, err := NewEthClientWithTransport("http://rpcpool", guard)
+		require.NoError(t, err)
+		_, err = cli.RpcClient.BlockNumber(context.Background())
+		require.NoError(t, err)
+		_, err = cli.RpcClient.BlockNumber(context.Background())
+		require.ErrorIs(t, err, limiter.ErrLimited)
+
+		behind.Close()
+		ahead.Close()
+		_, err = cli.RpcClient.ChainID(context.Background())
+		require.Error(t, err)
+		_, err = cli.RpcClient.ChainID(context.Background())
+		require.ErrorIs(t, err, limiter.ErrBreakerOpen)
+		require.Equal(t, limiter.StateOpen, guard.Stats().Breaker.State)
+	}
+}
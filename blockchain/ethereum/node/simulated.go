@@ -0,0 +1,69 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync/atomic"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/ethconfig"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	gethnode "github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// SimulatedBalance is the ether every wallet of RunSimulated is funded with.
+var SimulatedBalance = new(big.Int).Mul(big.NewInt(100), big.NewInt(wallet.WeiPerEther))
+
+var simulatedCount atomic.Int64
+
+// SimulatedEth is an in-process Ethereum chain of chain id wallet.ChainPrivate.
+// Transactions stay in the pool until Commit mines them into a block.
+type SimulatedEth struct {
+	*EthClient
+	Backend *simulated.Backend
+}
+
+// RunSimulated starts a simulated chain with every wallet funded with
+// SimulatedBalance, the returned func shuts it down.
+func RunSimulated(wallets ...*wallet.EthWallet) (*SimulatedEth, func(), error) {
+	alloc := types.GenesisAlloc{}
+	for _, w := range wallets {
+		if w.ChainId() != wallet.ChainPrivate {
+			return nil, nil, errors.New("wallet is not of the private chain")
+		}
+		alloc[w.DeriveNativeAddress()] = types.Account{Balance: SimulatedBalance}
+	}
+
+	// the simulated backend doesn't expose its rpc client, the EthClient dials
+	// the node over a private ipc endpoint instead
+	var endpoint string
+	backend := simulated.NewBackend(alloc, func(nodeConf *gethnode.Config, ethConf *ethconfig.Config) {
+		nodeConf.IPCPath = fmt.Sprintf("backend-learn-sim-%d-%d.ipc", os.Getpid(), simulatedCount.Add(1))
+		endpoint = nodeConf.IPCEndpoint()
+	})
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		backend.Close()
+		return nil, nil, err
+	}
+
+	sim := &SimulatedEth{
+		EthClient: &EthClient{RpcClient: ethclient.NewClient(client), client: client},
+		Backend:   backend,
+	}
+	stop := func() {
+		client.Close()
+		backend.Close()
+	}
+	return sim, stop, nil
+}
+
+// Commit mines the pending transactions into a new block.
+func (s *SimulatedEth) Commit() {
+	s.Backend.Commit()
+}
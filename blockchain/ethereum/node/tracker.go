@@ -0,0 +1,333 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+type TxState int
+
+const (
+	TxPending TxState = iota
+	TxIncluded
+	TxConfirmed
+	TxDropped
+	TxReplaced
+	TxReorged
+
+	// txNew is a tracked transaction not checked yet
+	txNew TxState = -1
+)
+
+func (s TxState) String() string {
+	switch s {
+	case TxPending:
+		return "pending"
+	case TxIncluded:
+		return "included"
+	case TxConfirmed:
+		return "confirmed"
+	case TxDropped:
+		return "dropped"
+	case TxReplaced:
+		return "replaced"
+	case TxReorged:
+		return "reorged"
+	}
+	return "unknown"
+}
+
+// Final reports whether the transaction isn't tracked after the state.
+func (s TxState) Final() bool {
+	return s == TxConfirmed || s == TxDropped || s == TxReplaced
+}
+
+type TxEvent struct {
+	Hash  common.Hash
+	State TxState
+	// BlockNumber and BlockHash are the block of an included or confirmed
+	// transaction, or the block it was reorged out of.
+	BlockNumber   uint64
+	BlockHash     common.Hash
+	Confirmations uint64
+	Receipt       *types.Receipt
+}
+
+const (
+	DefaultTxConfirmations = 12
+	DefaultTxPollInterval  = 4 * time.Second
+	DefaultTxDropTimeout   = 10 * time.Minute
+)
+
+type TxTrackerOptions struct {
+	// Confirmations is the number of blocks, including the block of the
+	// transaction, until it's confirmed.
+	Confirmations uint64
+	PollInterval  time.Duration
+	// DropTimeout is how long a transaction may be unknown to the node before
+	// it's dropped.
+	DropTimeout time.Duration
+	// OnEvent receives the events if set, otherwise they are sent to Events.
+	OnEvent func(TxEvent)
+	// OnError receives the errors of the polls of Run, they're logged if nil.
+	OnError func(error)
+}
+
+type trackedTx struct {
+	state TxState
+	// from and nonce are known once the node has seen the transaction
+	known       bool
+	from        common.Address
+	nonce       uint64
+	blockNumber uint64
+	blockHash   common.Hash
+	lastSeen    time.Time
+}
+
+// TxTracker watches submitted transactions and emits their state transitions:
+// pending when seen by the node, included in a block, confirmed after
+// Confirmations blocks, reorged out when the block hash at its height changes,
+// replaced when another transaction used its nonce and dropped when the node
+// forgot it for DropTimeout. Confirmed, replaced and dropped transactions are
+// no longer tracked.
+type TxTracker struct {
+	client *EthClient
+	opts   TxTrackerOptions
+	events chan TxEvent
+
+	mu  sync.Mutex
+	txs map[common.Hash]*trackedTx
+}
+
+func NewTxTracker(client *EthClient, opts *TxTrackerOptions) *TxTracker {
+	t := &TxTracker{client: client, txs: make(map[common.Hash]*trackedTx)}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.Confirmations == 0 {
+		t.opts.Confirmations = DefaultTxConfirmations
+	}
+	if t.opts.PollInterval <= 0 {
+		t.opts.PollInterval = DefaultTxPollInterval
+	}
+	if t.opts.DropTimeout <= 0 {
+		t.opts.DropTimeout = DefaultTxDropTimeout
+	}
+	if t.opts.OnEvent == nil {
+		t.events = make(chan TxEvent, 64)
+	}
+	if t.opts.OnError == nil {
+		t.opts.OnError = func(err error) { log.Printf("tx tracker: %v", err) }
+	}
+	return t
+}
+
+// Events returns the event channel, nil with OnEvent. The channel must be
+// drained or Poll blocks.
+func (t *TxTracker) Events() <-chan TxEvent {
+	return t.events
+}
+
+func (t *TxTracker) Track(hash common.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.txs[hash]; !ok {
+		t.txs[hash] = &trackedTx{state: txNew, lastSeen: time.Now()}
+	}
+}
+
+func (t *TxTracker) Untrack(hash common.Hash) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.txs, hash)
+}
+
+// Tracked returns the number of tracked transactions.
+func (t *TxTracker) Tracked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.txs)
+}
+
+// Run polls every PollInterval until ctx is done. A failed poll is retried on
+// the next tick.
+func (t *TxTracker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := t.Poll(ctx); err != nil && ctx.Err() == nil {
+			t.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks every tracked transaction once and emits the transitions.
+func (t *TxTracker) Poll(ctx context.Context) error {
+	head, err := t.client.RpcClient.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	hashes := make([]common.Hash, 0, len(t.txs))
+	for hash := range t.txs {
+		hashes = append(hashes, hash)
+	}
+	t.mu.Unlock()
+
+	for _, hash := range hashes {
+		t.mu.Lock()
+		tx, ok := t.txs[hash]
+		var cur trackedTx
+		if ok {
+			cur = *tx
+		}
+		t.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		events, err := t.check(ctx, hash, &cur, head)
+		if err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		if _, ok = t.txs[hash]; ok {
+			if cur.state.Final() {
+				delete(t.txs, hash)
+			} else {
+				*t.txs[hash] = cur
+			}
+		}
+		t.mu.Unlock()
+
+		for _, event := range events {
+			t.emit(event)
+		}
+	}
+	return nil
+}
+
+func (t *TxTracker) emit(event TxEvent) {
+	if t.opts.OnEvent != nil {
+		t.opts.OnEvent(event)
+	} else {
+		t.events <- event
+	}
+}
+
+func (t *TxTracker) check(ctx context.Context, hash common.Hash, tx *trackedTx, head uint64) ([]TxEvent, error) {
+	var events []TxEvent
+
+	// the block at the height of an included transaction changed
+	if tx.state == TxIncluded {
+		header, err := t.client.RpcClient.HeaderByNumber(ctx, new(big.Int).SetUint64(tx.blockNumber))
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return nil, err
+		}
+		if header == nil || header.Hash() != tx.blockHash {
+			events = append(events, TxEvent{Hash: hash, State: TxReorged, BlockNumber: tx.blockNumber, BlockHash: tx.blockHash})
+			tx.state = TxReorged
+		}
+	}
+
+	receipt, err := t.client.RpcClient.TransactionReceipt(ctx, hash)
+	if err != nil && !isTxNotFound(err) {
+		return nil, err
+	}
+	if receipt != nil {
+		number := receipt.BlockNumber.Uint64()
+		if tx.state != TxIncluded || tx.blockHash != receipt.BlockHash {
+			tx.state = TxIncluded
+			tx.blockNumber, tx.blockHash = number, receipt.BlockHash
+			events = append(events, TxEvent{Hash: hash, State: TxIncluded, BlockNumber: number,
+				BlockHash: receipt.BlockHash, Confirmations: confirmations(head, number), Receipt: receipt})
+		}
+		if confirmations(head, number) >= t.opts.Confirmations {
+			tx.state = TxConfirmed
+			events = append(events, TxEvent{Hash: hash, State: TxConfirmed, BlockNumber: number,
+				BlockHash: receipt.BlockHash, Confirmations: confirmations(head, number), Receipt: receipt})
+		}
+		tx.lastSeen = time.Now()
+		if !tx.known {
+			// for the replacement check after a reorg
+			if err = t.learnSender(ctx, hash, tx); err != nil {
+				return nil, err
+			}
+		}
+		return events, nil
+	}
+
+	pendingTx, from, _, err := t.client.TransactionByHash(ctx, hash)
+	if err != nil && !isTxNotFound(err) {
+		return nil, err
+	}
+	if pendingTx != nil && from != nil {
+		tx.known, tx.from, tx.nonce = true, *from, pendingTx.Nonce()
+		tx.lastSeen = time.Now()
+		if tx.state != TxPending {
+			tx.state = TxPending
+			events = append(events, TxEvent{Hash: hash, State: TxPending})
+		}
+		return events, nil
+	}
+
+	// unknown to the node
+	if tx.known {
+		nonce, err := t.client.RpcClient.NonceAt(ctx, tx.from, nil)
+		if err != nil {
+			return nil, err
+		}
+		if nonce > tx.nonce {
+			tx.state = TxReplaced
+			return append(events, TxEvent{Hash: hash, State: TxReplaced}), nil
+		}
+	}
+	if time.Since(tx.lastSeen) >= t.opts.DropTimeout {
+		tx.state = TxDropped
+		events = append(events, TxEvent{Hash: hash, State: TxDropped})
+	}
+	return events, nil
+}
+
+func (t *TxTracker) learnSender(ctx context.Context, hash common.Hash, tx *trackedTx) error {
+	found, from, _, err := t.client.TransactionByHash(ctx, hash)
+	if err != nil {
+		if isTxNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if from != nil {
+		tx.known, tx.from, tx.nonce = true, *from, found.Nonce()
+	}
+	return nil
+}
+
+// isTxNotFound reports whether err means the transaction isn't known yet, geth
+// answers with an error instead of null while its index is behind.
+func isTxNotFound(err error) bool {
+	return errors.Is(err, ethereum.NotFound) || strings.Contains(err.Error(), "transaction indexing is in progress")
+}
+
+func confirmations(head, number uint64) uint64 {
+	if head < number {
+		return 0
+	}
+	return head - number + 1
+}
@@ -0,0 +1,182 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxTracker(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	w, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	ew := w.(*wallet.EthWallet)
+	from := ew.DeriveNativeAddress()
+
+	cli, stop, err := RunSimulated(ew)
+	require.NoError(t, err)
+	defer stop()
+	ctx := context.Background()
+
+	nonce := uint64(0)
+	send := func(nonce uint64, tip int64) common.Hash {
+		head, err := cli.RpcClient.HeaderByNumber(ctx, nil)
+		require.NoError(t, err)
+		tx, err := types.SignNewTx(ew.DeriveNativePrivateKey(), types.LatestSigner(ew.ChainParams()), &types.DynamicFeeTx{
+			Nonce:     nonce,
+			To:        &common.Address{1},
+			Value:     big.NewInt(1),
+			Gas:       wallet.EtherTransferGas,
+			GasTipCap: big.NewInt(tip),
+			GasFeeCap: new(big.Int).Add(big.NewInt(tip), new(big.Int).Mul(head.BaseFee, big.NewInt(2))),
+		})
+		require.NoError(t, err)
+		require.NoError(t, cli.RpcClient.SendTransaction(ctx, tx))
+		return tx.Hash()
+	}
+
+	tracker := NewTxTracker(cli.EthClient, &TxTrackerOptions{Confirmations: 3})
+	poll := func(tracker *TxTracker) []TxEvent {
+		require.NoError(t, tracker.Poll(ctx))
+		var events []TxEvent
+		for {
+			select {
+			case event := <-tracker.Events():
+				fmt.Println("event:", event.Hash.Hex(), event.State, event.BlockNumber)
+				events = append(events, event)
+			default:
+				return events
+			}
+		}
+	}
+	eventsOf := func(hash common.Hash, events []TxEvent) []TxEvent {
+		var txEvents []TxEvent
+		for _, event := range events {
+			if event.Hash == hash {
+				txEvents = append(txEvents, event)
+			}
+		}
+		return txEvents
+	}
+	states := func(events []TxEvent) []TxState {
+		var states []TxState
+		for _, event := range events {
+			states = append(states, event.State)
+		}
+		return states
+	}
+
+	{ // pending, included and confirmed
+		hash := send(nonce, 1e9)
+		nonce++
+		tracker.Track(hash)
+		require.Equal(t, []TxState{TxPending}, states(poll(tracker)))
+		require.Empty(t, poll(tracker))
+
+		cli.Commit()
+		events := poll(tracker)
+		require.Equal(t, []TxState{TxIncluded}, states(events))
+		require.Equal(t, uint64(1), events[0].Confirmations)
+		require.Equal(t, types.ReceiptStatusSuccessful, events[0].Receipt.Status)
+
+		cli.Commit()
+		cli.Commit()
+		events = poll(tracker)
+		require.Equal(t, []TxState{TxConfirmed}, states(events))
+		require.Equal(t, uint64(3), events[0].Confirmations)
+		require.Equal(t, 0, tracker.Tracked())
+	}
+
+	{ // replaced by a higher fee
+		hash := send(nonce, 1e9)
+		tracker.Track(hash)
+		require.Equal(t, []TxState{TxPending}, states(poll(tracker)))
+		replacement := send(nonce, 3e9)
+		nonce++
+		tracker.Track(replacement)
+		cli.Commit()
+
+		for _, event := range poll(tracker) {
+			if event.Hash == hash {
+				require.Equal(t, TxReplaced, event.State)
+			} else {
+				require.Equal(t, TxIncluded, event.State)
+			}
+		}
+	}
+
+	{ // reorged out
+		hash := send(nonce, 1e9)
+		nonce++
+		tracker.Track(hash)
+		cli.Commit()
+		events := eventsOf(hash, poll(tracker))
+		require.Equal(t, []TxState{TxIncluded}, states(events))
+		included := events[0]
+
+		parent, err := cli.RpcClient.HeaderByNumber(ctx, new(big.Int).SetUint64(included.BlockNumber-1))
+		require.NoError(t, err)
+		require.NoError(t, cli.Backend.Fork(parent.Hash()))
+		cli.Commit()
+		cli.Commit()
+
+		events = eventsOf(hash, poll(tracker))
+		require.NotEmpty(t, events)
+		require.Equal(t, TxReorged, events[0].State)
+		require.Equal(t, included.BlockHash, events[0].BlockHash)
+		tracker.Untrack(hash)
+		require.Equal(t, 0, tracker.Tracked())
+	}
+
+	{ // dropped
+		nonce, err = cli.RpcClient.PendingNonceAt(ctx, from)
+		require.NoError(t, err)
+		var dropped []TxEvent
+		dropTracker := NewTxTracker(cli.EthClient, &TxTrackerOptions{
+			DropTimeout: time.Nanosecond,
+			OnEvent: func(event TxEvent) {
+				dropped = append(dropped, event)
+			},
+		})
+		hash := send(nonce, 1e9)
+		dropTracker.Track(hash)
+		require.NoError(t, dropTracker.Poll(ctx))
+		cli.Backend.Rollback()
+		require.NoError(t, dropTracker.Poll(ctx))
+		require.Equal(t, []TxState{TxPending, TxDropped}, states(dropped))
+		require.Equal(t, 0, dropTracker.Tracked())
+	}
+}
+
+func TestTxTrackerNodeDown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	cli, err := NewEthClient(server.URL)
+	require.NoError(t, err)
+
+	var errs atomic.Int64
+	tracker := NewTxTracker(cli, &TxTrackerOptions{
+		PollInterval: 20 * time.Millisecond,
+		OnEvent:      func(TxEvent) {},
+		OnError:      func(error) { errs.Add(1) },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tracker.Run(ctx), context.DeadlineExceeded)
+	// one poll per tick, not a busy loop
+	require.GreaterOrEqual(t, errs.Load(), int64(1))
+	require.LessOrEqual(t, errs.Load(), int64(7))
+}
@@ -0,0 +1,381 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/tx"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var ErrReorgTooDeep = errors.New("reorg is deeper than the kept blocks")
+
+type DepositState int
+
+const (
+	DepositDetected DepositState = iota
+	// DepositReverted is a deposit of a block which was reorged out, the
+	// transaction may be detected again in another block.
+	DepositReverted
+)
+
+func (s DepositState) String() string {
+	switch s {
+	case DepositDetected:
+		return "detected"
+	case DepositReverted:
+		return "reverted"
+	}
+	return "unknown"
+}
+
+// Deposit is an ether transfer to a watched address by the To of a
+// transaction, transfers by contract calls are not seen, or an ERC-20
+// Transfer log to a watched address. A deposit is identified by TxHash, Token
+// and LogIndex.
+type Deposit struct {
+	// Token is the ERC-20 contract, the zero address for ether.
+	Token  common.Address `json:"token"`
+	TxHash common.Hash    `json:"txHash"`
+	// LogIndex is the index of the Transfer log in the block, 0 for ether.
+	LogIndex    uint           `json:"logIndex"`
+	From        common.Address `json:"from"`
+	To          common.Address `json:"to"`
+	Amount      *big.Int       `json:"amount"`
+	BlockNumber uint64         `json:"blockNumber"`
+	BlockHash   common.Hash    `json:"blockHash"`
+}
+
+func (d *Deposit) IsEther() bool {
+	return d.Token == common.Address{}
+}
+
+type DepositEvent struct {
+	State DepositState
+	Deposit
+}
+
+const (
+	DefaultReorgDepth = 64
+
+	// maxTopicAddresses is the most watched addresses filtered by eth_getLogs,
+	// more are filtered here.
+	maxTopicAddresses = 1000
+)
+
+type ScannerOptions struct {
+	// StartBlock is the first block to scan when the store has no checkpoint.
+	StartBlock uint64
+	// Confirmations is the number of blocks, including the block of a
+	// deposit, until it's scanned. node.DefaultTxConfirmations if 0.
+	Confirmations uint64
+	// ReorgDepth is the number of scanned blocks kept to roll back.
+	ReorgDepth   int
+	PollInterval time.Duration
+	// Tokens are the ERC-20 contracts whose Transfer logs are deposits, nil is
+	// any contract, which includes spam tokens.
+	Tokens []common.Address
+	// OnEvent receives the events if set, otherwise they are sent to Events.
+	OnEvent func(DepositEvent)
+	// OnError receives the errors of the polls of Run, they're logged if nil.
+	OnError func(error)
+}
+
+// Scanner follows the chain Confirmations blocks behind the head and emits the
+// deposits to the watched addresses. When the parent of the next block is not
+// the last scanned block, the last block is rolled back, its deposits are
+// reverted, and the blocks of the new chain are scanned again. Events are
+// emitted before the checkpoint is saved, so after a crash a block may be
+// emitted twice.
+type Scanner struct {
+	client *node.EthClient
+	opts   ScannerOptions
+	store  CheckpointStore
+	events chan DepositEvent
+
+	// scanMu serializes Poll
+	scanMu     sync.Mutex
+	checkpoint *Checkpoint
+
+	mu      sync.Mutex
+	watched map[common.Address]struct{}
+}
+
+func NewScanner(client *node.EthClient, store CheckpointStore, opts *ScannerOptions) *Scanner {
+	s := &Scanner{client: client, store: store, watched: make(map[common.Address]struct{})}
+	if opts != nil {
+		s.opts = *opts
+	}
+	if s.opts.Confirmations == 0 {
+		s.opts.Confirmations = node.DefaultTxConfirmations
+	}
+	if s.opts.ReorgDepth <= 0 {
+		s.opts.ReorgDepth = DefaultReorgDepth
+	}
+	if s.opts.PollInterval <= 0 {
+		s.opts.PollInterval = node.DefaultTxPollInterval
+	}
+	if s.opts.OnEvent == nil {
+		s.events = make(chan DepositEvent, 64)
+	}
+	if s.opts.OnError == nil {
+		s.opts.OnError = func(err error) { log.Printf("eth scanner: %v", err) }
+	}
+	return s
+}
+
+// Events returns the event channel, nil with OnEvent. The channel must be
+// drained or Poll blocks until its ctx is done.
+func (s *Scanner) Events() <-chan DepositEvent {
+	return s.events
+}
+
+func (s *Scanner) Watch(addresses ...common.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, address := range addresses {
+		s.watched[address] = struct{}{}
+	}
+}
+
+// WatchWallets watches the addresses of HDWallet derived wallets.
+func (s *Scanner) WatchWallets(wallets ...*wallet.EthWallet) {
+	addresses := make([]common.Address, 0, len(wallets))
+	for _, w := range wallets {
+		addresses = append(addresses, w.DeriveNativeAddress())
+	}
+	s.Watch(addresses...)
+}
+
+func (s *Scanner) Unwatch(addresses ...common.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, address := range addresses {
+		delete(s.watched, address)
+	}
+}
+
+// Next returns the number of the next block to scan.
+func (s *Scanner) Next() (uint64, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	return s.nextNumber(), nil
+}
+
+// Run polls every PollInterval until ctx is done or the reorg is too deep,
+// the other errors of the polls go to OnError.
+func (s *Scanner) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Poll(ctx); errors.Is(err, ErrReorgTooDeep) {
+			return err
+		} else if err != nil && ctx.Err() == nil {
+			s.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll scans the blocks up to Confirmations blocks behind the head.
+func (s *Scanner) Poll(ctx context.Context) error {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	head, err := s.client.RpcClient.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	// the new chain is shorter than the scanned one
+	for last := s.checkpoint.Last(); last != nil && last.Number > head; last = s.checkpoint.Last() {
+		if err = s.rollback(ctx); err != nil {
+			return err
+		}
+	}
+	if head+1 < s.opts.Confirmations {
+		return nil
+	}
+	safe := head + 1 - s.opts.Confirmations
+
+	for number := s.nextNumber(); number <= safe; number = s.nextNumber() {
+		header, err := s.client.RpcClient.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return err
+		}
+		if last := s.checkpoint.Last(); last != nil && header.ParentHash != last.Hash {
+			if err = s.rollback(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+
+		deposits, err := s.deposits(ctx, header)
+		if err != nil {
+			return err
+		}
+		for _, deposit := range deposits {
+			if err = s.emit(ctx, DepositEvent{State: DepositDetected, Deposit: deposit}); err != nil {
+				return err
+			}
+		}
+		s.checkpoint.Blocks = append(s.checkpoint.Blocks, BlockRef{Number: number, Hash: header.Hash(), Deposits: deposits})
+		if len(s.checkpoint.Blocks) > s.opts.ReorgDepth {
+			s.checkpoint.Blocks = s.checkpoint.Blocks[len(s.checkpoint.Blocks)-s.opts.ReorgDepth:]
+		}
+		if err = s.store.Save(s.checkpoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) load() error {
+	if s.checkpoint != nil {
+		return nil
+	}
+	checkpoint, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{}
+	}
+	s.checkpoint = checkpoint
+	return nil
+}
+
+func (s *Scanner) nextNumber() uint64 {
+	if last := s.checkpoint.Last(); last != nil {
+		return last.Number + 1
+	}
+	return s.opts.StartBlock
+}
+
+// rollback reverts the deposits of the last scanned block. The parent of the
+// oldest kept block is unknown, so it is only rolled back if it's the first
+// block scanned.
+func (s *Scanner) rollback(ctx context.Context) error {
+	last := s.checkpoint.Last()
+	if last == nil || (len(s.checkpoint.Blocks) == 1 && last.Number != s.opts.StartBlock) {
+		return ErrReorgTooDeep
+	}
+	for _, deposit := range last.Deposits {
+		if err := s.emit(ctx, DepositEvent{State: DepositReverted, Deposit: deposit}); err != nil {
+			return err
+		}
+	}
+	s.checkpoint.Blocks = s.checkpoint.Blocks[:len(s.checkpoint.Blocks)-1]
+	return s.store.Save(s.checkpoint)
+}
+
+func (s *Scanner) deposits(ctx context.Context, header *types.Header) ([]Deposit, error) {
+	s.mu.Lock()
+	watched := make(map[common.Address]struct{}, len(s.watched))
+	for address := range s.watched {
+		watched[address] = struct{}{}
+	}
+	s.mu.Unlock()
+	if len(watched) == 0 {
+		return nil, nil
+	}
+
+	hash := header.Hash()
+	number := header.Number.Uint64()
+	var deposits []Deposit
+
+	// ether
+	if header.TxHash != types.EmptyTxsHash {
+		block, err := s.client.RpcClient.BlockByHash(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		var receipts []*types.Receipt
+		for i, transaction := range block.Transactions() {
+			if transaction.To() == nil || transaction.Value().Sign() <= 0 {
+				continue
+			}
+			if _, ok := watched[*transaction.To()]; !ok {
+				continue
+			}
+			// a call to a watched contract may revert
+			if receipts == nil {
+				if receipts, err = s.client.RpcClient.BlockReceipts(ctx, rpc.BlockNumberOrHashWithHash(hash, false)); err != nil {
+					return nil, err
+				}
+			}
+			if i >= len(receipts) || receipts[i].Status != types.ReceiptStatusSuccessful {
+				continue
+			}
+			from, err := s.client.RpcClient.TransactionSender(ctx, transaction, hash, uint(i))
+			if err != nil {
+				return nil, err
+			}
+			deposits = append(deposits, Deposit{TxHash: transaction.Hash(), From: from, To: *transaction.To(),
+				Amount: transaction.Value(), BlockNumber: number, BlockHash: hash})
+		}
+	}
+
+	// tokens
+	if header.Bloom.Test(tx.Erc20TransferTopic.Bytes()) {
+		var toTopics []common.Hash
+		if len(watched) <= maxTopicAddresses {
+			for address := range watched {
+				toTopics = append(toTopics, common.BytesToHash(address.Bytes()))
+			}
+		}
+		logs, err := s.client.RpcClient.FilterLogs(ctx, ethereum.FilterQuery{
+			BlockHash: &hash,
+			Addresses: s.opts.Tokens,
+			Topics:    [][]common.Hash{{tx.Erc20TransferTopic}, nil, toTopics},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, log := range logs {
+			// ERC-721 Transfer logs have the token id as a fourth topic
+			if log.Removed || len(log.Topics) != 3 || len(log.Data) != 32 {
+				continue
+			}
+			to := common.BytesToAddress(log.Topics[2].Bytes())
+			if _, ok := watched[to]; !ok {
+				continue
+			}
+			deposits = append(deposits, Deposit{Token: log.Address, TxHash: log.TxHash, LogIndex: log.Index,
+				From: common.BytesToAddress(log.Topics[1].Bytes()), To: to,
+				Amount: new(big.Int).SetBytes(log.Data), BlockNumber: number, BlockHash: hash})
+		}
+	}
+	return deposits, nil
+}
+
+func (s *Scanner) emit(ctx context.Context, event DepositEvent) error {
+	if s.opts.OnEvent != nil {
+		s.opts.OnEvent(event)
+		return nil
+	}
+	select {
+	case s.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
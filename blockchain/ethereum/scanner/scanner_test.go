@@ -0,0 +1,212 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/tx"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+// testTransferInit deploys a contract which emits Transfer(caller, to, value)
+// for calldata of to and value:
+//
+//	PUSH1 32 PUSH1 32 PUSH1 0 CALLDATACOPY
+//	PUSH1 0 CALLDATALOAD CALLER PUSH32 <topic> PUSH1 32 PUSH1 0 LOG3 STOP
+func testTransferInit() []byte {
+	runtime := append(common.FromHex("60206020600037600035337f"), tx.Erc20TransferTopic.Bytes()...)
+	runtime = append(runtime, common.FromHex("60206000a300")...)
+	init := common.FromHex(fmt.Sprintf("60%02x80600b6000396000f3", len(runtime)))
+	return append(init, runtime...)
+}
+
+func TestScanner(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	var wallets []*wallet.EthWallet
+	for i := 0; i < 3; i++ {
+		w, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, i)
+		require.NoError(t, err)
+		wallets = append(wallets, w.(*wallet.EthWallet))
+	}
+	ew0 := wallets[0]
+	from := ew0.DeriveNativeAddress()
+	a1, a2 := wallets[1].DeriveNativeAddress(), wallets[2].DeriveNativeAddress()
+
+	cli, stop, err := node.RunSimulated(ew0)
+	require.NoError(t, err)
+	defer stop()
+	ctx := context.Background()
+
+	nonce := uint64(0)
+	send := func(to *common.Address, value int64, data []byte) common.Hash {
+		head, err := cli.RpcClient.HeaderByNumber(ctx, nil)
+		require.NoError(t, err)
+		signed, err := types.SignNewTx(ew0.DeriveNativePrivateKey(), types.LatestSigner(ew0.ChainParams()), &types.DynamicFeeTx{
+			Nonce:     nonce,
+			To:        to,
+			Value:     big.NewInt(value),
+			Gas:       200000,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: new(big.Int).Add(big.NewInt(1e9), new(big.Int).Mul(head.BaseFee, big.NewInt(2))),
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.NoError(t, cli.RpcClient.SendTransaction(ctx, signed))
+		nonce++
+		return signed.Hash()
+	}
+
+	token := crypto.CreateAddress(from, nonce)
+	send(nil, 0, testTransferInit())
+	cli.Commit()
+	code, err := cli.RpcClient.CodeAt(ctx, token, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, code)
+
+	var events []DepositEvent
+	s := NewScanner(cli.EthClient, NewFileStore(filepath.Join(t.TempDir(), "checkpoint.json")), &ScannerOptions{
+		StartBlock:    1,
+		Confirmations: 2,
+		OnEvent: func(event DepositEvent) {
+			fmt.Println("event:", event.State, event.Token.Hex(), event.To.Hex(), event.Amount, event.BlockNumber)
+			events = append(events, event)
+		},
+	})
+	s.WatchWallets(wallets[1], wallets[2])
+
+	etherHash := send(&a1, 1000, nil)
+	send(&from, 2000, nil)
+	transfer := append(common.LeftPadBytes(a2.Bytes(), 32), common.LeftPadBytes(big.NewInt(500).Bytes(), 32)...)
+	tokenHash := send(&token, 0, transfer)
+	cli.Commit()
+	depositBlock, err := cli.RpcClient.BlockNumber(ctx)
+	require.NoError(t, err)
+
+	// one confirmation only
+	require.NoError(t, s.Poll(ctx))
+	require.Empty(t, events)
+	next, err := s.Next()
+	require.NoError(t, err)
+	require.Equal(t, depositBlock, next)
+
+	cli.Commit()
+	require.NoError(t, s.Poll(ctx))
+	require.Len(t, events, 2)
+	ether, erc20 := events[0], events[1]
+	require.True(t, ether.IsEther())
+	require.Equal(t, etherHash, ether.TxHash)
+	require.Equal(t, from, ether.From)
+	require.Equal(t, a1, ether.To)
+	require.Equal(t, big.NewInt(1000), ether.Amount)
+	require.Equal(t, depositBlock, ether.BlockNumber)
+	require.Equal(t, token, erc20.Token)
+	require.Equal(t, tokenHash, erc20.TxHash)
+	require.Equal(t, from, erc20.From)
+	require.Equal(t, a2, erc20.To)
+	require.Equal(t, big.NewInt(500), erc20.Amount)
+
+	// other tokens only
+	var otherEvents []DepositEvent
+	other := NewScanner(cli.EthClient, &MemoryStore{}, &ScannerOptions{StartBlock: depositBlock, Confirmations: 1,
+		Tokens: []common.Address{{1}}, OnEvent: func(event DepositEvent) {
+			otherEvents = append(otherEvents, event)
+		}})
+	other.Watch(a1, a2)
+	require.NoError(t, other.Poll(ctx))
+	require.Len(t, otherEvents, 1)
+	require.True(t, otherEvents[0].IsEther())
+
+	// the deposit block is reorged out
+	events = nil
+	parent, err := cli.RpcClient.HeaderByNumber(ctx, new(big.Int).SetUint64(depositBlock-1))
+	require.NoError(t, err)
+	require.NoError(t, cli.Backend.Fork(parent.Hash()))
+	cli.Commit()
+	cli.Commit()
+	cli.Commit()
+	require.NoError(t, s.Poll(ctx))
+	require.GreaterOrEqual(t, len(events), 2)
+	require.Equal(t, DepositReverted, events[0].State)
+	require.Equal(t, etherHash, events[0].TxHash)
+	require.Equal(t, DepositReverted, events[1].State)
+	require.Equal(t, tokenHash, events[1].TxHash)
+	for _, event := range events[2:] {
+		require.Equal(t, DepositDetected, event.State)
+	}
+}
+
+func TestScannerRun(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	w0, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 1)
+	require.NoError(t, err)
+	ew0 := w0.(*wallet.EthWallet)
+	to := w1.(*wallet.EthWallet).DeriveNativeAddress()
+
+	cli, stop, err := node.RunSimulated(ew0)
+	require.NoError(t, err)
+	defer stop()
+	ctx := context.Background()
+
+	// more deposits than the buffer of the events
+	head, err := cli.RpcClient.HeaderByNumber(ctx, nil)
+	require.NoError(t, err)
+	for nonce := uint64(0); nonce < 100; nonce++ {
+		signed, err := types.SignNewTx(ew0.DeriveNativePrivateKey(), types.LatestSigner(ew0.ChainParams()), &types.DynamicFeeTx{
+			Nonce:     nonce,
+			To:        &to,
+			Value:     big.NewInt(1),
+			Gas:       21000,
+			GasTipCap: big.NewInt(1e9),
+			GasFeeCap: new(big.Int).Add(big.NewInt(1e9), new(big.Int).Mul(head.BaseFee, big.NewInt(2))),
+		})
+		require.NoError(t, err)
+		require.NoError(t, cli.RpcClient.SendTransaction(ctx, signed))
+	}
+	cli.Commit()
+
+	s := NewScanner(cli.EthClient, &MemoryStore{}, &ScannerOptions{StartBlock: 1, Confirmations: 1})
+	s.Watch(to)
+	// nobody drains the events, the poll gives up with its ctx
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Poll(timeout), context.DeadlineExceeded)
+	next, err := s.Next()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), next)
+
+	// the errors of the polls are reported and Run goes on
+	errs := make(chan error, 1)
+	s = NewScanner(cli.EthClient, &MemoryStore{}, &ScannerOptions{StartBlock: 1, PollInterval: 10 * time.Millisecond,
+		OnEvent: func(DepositEvent) {},
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}})
+	stop()
+	running, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- s.Run(running) }()
+	require.Error(t, <-errs)
+	<-errs
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,160 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const Erc20ABI = `[
+{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"stateMutability":"view","type":"function"},
+{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"stateMutability":"view","type":"function"},
+{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"stateMutability":"view","type":"function"},
+{"constant":true,"inputs":[],"name":"totalSupply","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+{"constant":true,"inputs":[{"name":"owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+{"constant":true,"inputs":[{"name":"owner","type":"address"},{"name":"spender","type":"address"}],"name":"allowance","outputs":[{"name":"","type":"uint256"}],"stateMutability":"view","type":"function"},
+{"constant":false,"inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},
+{"constant":false,"inputs":[{"name":"spender","type":"address"},{"name":"value","type":"uint256"}],"name":"approve","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},
+{"constant":false,"inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"name":"transferFrom","outputs":[{"name":"","type":"bool"}],"stateMutability":"nonpayable","type":"function"},
+{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"},
+{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"spender","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Approval","type":"event"}
+]`
+
+var erc20ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(Erc20ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// Erc20TransferTopic is the first topic of the logs of the Transfer event.
+var Erc20TransferTopic = erc20ABI.Events["Transfer"].ID
+
+// Erc20 is an ERC-20 token contract. The transactions are built like
+// TransferEther, so opts come from MakeTransactOpts.
+type Erc20 struct {
+	Address  common.Address
+	backend  bind.ContractBackend
+	contract *bind.BoundContract
+}
+
+func NewErc20(address common.Address, backend bind.ContractBackend) *Erc20 {
+	contract := bind.NewBoundContract(address, erc20ABI, backend, backend, backend)
+	return &Erc20{Address: address, backend: backend, contract: contract}
+}
+
+func (e *Erc20) Name(opts *bind.CallOpts) (string, error) {
+	var name string
+	err := e.call(opts, &name, "name")
+	return name, err
+}
+
+func (e *Erc20) Symbol(opts *bind.CallOpts) (string, error) {
+	var symbol string
+	err := e.call(opts, &symbol, "symbol")
+	return symbol, err
+}
+
+func (e *Erc20) Decimals(opts *bind.CallOpts) (uint8, error) {
+	var decimals uint8
+	err := e.call(opts, &decimals, "decimals")
+	return decimals, err
+}
+
+func (e *Erc20) TotalSupply(opts *bind.CallOpts) (*big.Int, error) {
+	var supply *big.Int
+	err := e.call(opts, &supply, "totalSupply")
+	return supply, err
+}
+
+func (e *Erc20) BalanceOf(opts *bind.CallOpts, owner common.Address) (*big.Int, error) {
+	var balance *big.Int
+	err := e.call(opts, &balance, "balanceOf", owner)
+	return balance, err
+}
+
+func (e *Erc20) Allowance(opts *bind.CallOpts, owner, spender common.Address) (*big.Int, error) {
+	var allowance *big.Int
+	err := e.call(opts, &allowance, "allowance", owner, spender)
+	return allowance, err
+}
+
+func (e *Erc20) Transfer(opts *bind.TransactOpts, to common.Address, amount *big.Int) (*types.Transaction, error) {
+	return e.transact(opts, "transfer", to, amount)
+}
+
+func (e *Erc20) Approve(opts *bind.TransactOpts, spender common.Address, amount *big.Int) (*types.Transaction, error) {
+	return e.transact(opts, "approve", spender, amount)
+}
+
+func (e *Erc20) TransferFrom(opts *bind.TransactOpts, from, to common.Address, amount *big.Int) (*types.Transaction, error) {
+	return e.transact(opts, "transferFrom", from, to, amount)
+}
+
+// EstimateGas estimates the gas of calling method from the from address, e.g.
+// EstimateGas(from, "transfer", to, amount).
+func (e *Erc20) EstimateGas(from common.Address, method string, args ...interface{}) (uint64, error) {
+	input, err := erc20ABI.Pack(method, args...)
+	if err != nil {
+		return 0, err
+	}
+	return e.backend.EstimateGas(context.Background(), ethereum.CallMsg{From: from, To: &e.Address, Data: input})
+}
+
+func (e *Erc20) call(opts *bind.CallOpts, out interface{}, method string, args ...interface{}) error {
+	var results []interface{}
+	err := e.contract.Call(opts, &results, method, args...)
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 {
+		return fmt.Errorf("%s: unexpected result", method)
+	}
+	return erc20ABI.Methods[method].Outputs.Copy(out, results)
+}
+
+func (e *Erc20) transact(opts *bind.TransactOpts, method string, args ...interface{}) (*types.Transaction, error) {
+	if opts.Value != nil && opts.Value.Sign() != 0 {
+		return nil, errors.New("erc20 methods are not payable")
+	}
+	input, err := erc20ABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return transact(opts, e.backend, e.Address, input)
+}
+
+// FormatTokenAmount formats amount of the smallest unit in tokens, with at most
+// wallet.TokenShowDecimals decimals.
+func FormatTokenAmount(amount *big.Int, decimals uint8) string {
+	showDecimals := int(decimals)
+	if showDecimals > wallet.TokenShowDecimals {
+		showDecimals = wallet.TokenShowDecimals
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	return new(big.Rat).SetFrac(amount, unit).FloatString(showDecimals)
+}
+
+// ParseTokenAmount converts a decimal token amount to the smallest unit.
+func ParseTokenAmount(amount string, decimals uint8) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount: %s", amount)
+	}
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	r.Mul(r, new(big.Rat).SetInt(unit))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("amount %s has more than %d decimals", amount, decimals)
+	}
+	return r.Num(), nil
+}
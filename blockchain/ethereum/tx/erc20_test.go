@@ -0,0 +1,382 @@
+package tx
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+const testTokenSupply = 1000000 * 1000000 // 1M tokens of 6 decimals
+
+// testTokenRuntime is a minimal ERC-20 "Test Token" (TST, 6 decimals), the
+// balance of an address is stored at the slot of the address and the allowance
+// at keccak256(owner, spender).
+const testTokenRuntime = `
+	PUSH 0
+	CALLDATALOAD
+	PUSH 224
+	SHR
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @name
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @symbol
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @decimals
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @totalSupply
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @balanceOf
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @allowance
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @transfer
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @approve
+	DUP1
+	PUSH 0x%x
+	EQ
+	JUMPI @transferFrom
+	JUMP @revert
+
+name:
+	PUSH 10
+	PUSH 0x5465737420546f6b656e00000000000000000000000000000000000000000000
+	JUMP @returnString
+symbol:
+	PUSH 3
+	PUSH 0x5453540000000000000000000000000000000000000000000000000000000000
+	JUMP @returnString
+returnString:
+	PUSH 0x40
+	MSTORE
+	PUSH 0x20
+	MSTORE
+	PUSH 0x20
+	PUSH 0
+	MSTORE
+	PUSH 0x60
+	PUSH 0
+	RETURN
+
+decimals:
+	PUSH 6
+	JUMP @returnWord
+totalSupply:
+	PUSH %d
+	JUMP @returnWord
+balanceOf:
+	PUSH 4
+	CALLDATALOAD
+	SLOAD
+	JUMP @returnWord
+allowance:
+	PUSH 4
+	CALLDATALOAD
+	PUSH 0
+	MSTORE
+	PUSH 0x24
+	CALLDATALOAD
+	PUSH 0x20
+	MSTORE
+	PUSH 0x40
+	PUSH 0
+	KECCAK256
+	SLOAD
+	JUMP @returnWord
+returnTrue:
+	PUSH 1
+	JUMP @returnWord
+returnWord:
+	PUSH 0
+	MSTORE
+	PUSH 0x20
+	PUSH 0
+	RETURN
+
+transfer:
+	PUSH @returnTrue
+	CALLER
+	PUSH 4
+	CALLDATALOAD
+	PUSH 0x24
+	CALLDATALOAD
+	JUMP @move
+
+approve:
+	CALLER
+	PUSH 0
+	MSTORE
+	PUSH 4
+	CALLDATALOAD
+	PUSH 0x20
+	MSTORE
+	PUSH 0x24
+	CALLDATALOAD
+	PUSH 0x40
+	PUSH 0
+	KECCAK256
+	SSTORE
+	PUSH 0x24
+	CALLDATALOAD
+	PUSH 0
+	MSTORE
+	PUSH 4
+	CALLDATALOAD
+	CALLER
+	PUSH 0x%x
+	PUSH 0x20
+	PUSH 0
+	LOG3
+	JUMP @returnTrue
+
+transferFrom:
+	PUSH 4
+	CALLDATALOAD
+	PUSH 0
+	MSTORE
+	CALLER
+	PUSH 0x20
+	MSTORE
+	PUSH 0x40
+	PUSH 0
+	KECCAK256
+	DUP1
+	SLOAD
+	PUSH 0x44
+	CALLDATALOAD
+	DUP2
+	DUP2
+	GT
+	JUMPI @revert
+	SWAP1
+	SUB
+	SWAP1
+	SSTORE
+	PUSH @returnTrue
+	PUSH 4
+	CALLDATALOAD
+	PUSH 0x24
+	CALLDATALOAD
+	PUSH 0x44
+	CALLDATALOAD
+	JUMP @move
+
+;; stack: amount to from return
+move:
+	DUP3
+	SLOAD
+	DUP2
+	DUP2
+	LT
+	JUMPI @revert
+	DUP2
+	DUP2
+	SUB
+	DUP5
+	SSTORE
+	POP
+	DUP2
+	SLOAD
+	DUP2
+	ADD
+	DUP3
+	SSTORE
+	DUP1
+	PUSH 0
+	MSTORE
+	DUP2
+	DUP4
+	PUSH 0x%x
+	PUSH 0x20
+	PUSH 0
+	LOG3
+	POP
+	POP
+	POP
+	JUMP
+
+revert:
+	PUSH 0
+	DUP1
+	REVERT
+`
+
+// testTokenInit mints the supply to the deployer and returns the runtime code
+// which follows it.
+const testTokenInit = `
+	PUSH %d
+	CALLER
+	SSTORE
+	PUSH %d
+	DUP1
+	PUSH %d
+	PUSH 0
+	CODECOPY
+	PUSH 0
+	RETURN
+`
+
+func assemble(t *testing.T, source string) []byte {
+	c := asm.NewCompiler(false)
+	c.Feed(asm.Lex([]byte(source), false))
+	code, errs := c.Compile()
+	require.Empty(t, errs)
+	return common.FromHex(code)
+}
+
+func testTokenBytecode(t *testing.T) []byte {
+	methods, events := erc20ABI.Methods, erc20ABI.Events
+	runtime := assemble(t, fmt.Sprintf(testTokenRuntime,
+		methods["name"].ID, methods["symbol"].ID, methods["decimals"].ID, methods["totalSupply"].ID,
+		methods["balanceOf"].ID, methods["allowance"].ID, methods["transfer"].ID, methods["approve"].ID,
+		methods["transferFrom"].ID, testTokenSupply, events["Approval"].ID, events["Transfer"].ID))
+
+	// the offset of the runtime is the size of the init code
+	init := assemble(t, fmt.Sprintf(testTokenInit, testTokenSupply, len(runtime), 0))
+	for offset := 0; offset != len(init); {
+		offset = len(init)
+		init = assemble(t, fmt.Sprintf(testTokenInit, testTokenSupply, len(runtime), offset))
+	}
+	return append(init, runtime...)
+}
+
+func TestErc20(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+
+	var wallets []*wallet.EthWallet
+	var addrs []common.Address
+	for i := 0; i < 3; i++ {
+		w, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, i)
+		require.NoError(t, err)
+		wallets = append(wallets, w.(*wallet.EthWallet))
+		addrs = append(addrs, w.(*wallet.EthWallet).DeriveNativeAddress())
+	}
+
+	cli, stop, err := node.RunSimulated(wallets[0], wallets[1])
+	require.NoError(t, err)
+	defer stop()
+	client := cli.RpcClient
+	ctx := context.Background()
+
+	mined := func(tx *types.Transaction) {
+		cli.Commit()
+		receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+		require.NoError(t, err)
+		require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	}
+	balanceOf := func(token *Erc20, addr common.Address) int64 {
+		balance, err := token.BalanceOf(nil, addr)
+		require.NoError(t, err)
+		return balance.Int64()
+	}
+
+	// deploy the test token
+	opts, err := MakeTransactOpts(wallets[0], TransactBaseParam{From: addrs[0]}, -1, -1)
+	require.NoError(t, err)
+	tokenAddr, deployTx, _, err := bind.DeployContract(opts, erc20ABI, testTokenBytecode(t), client)
+	require.NoError(t, err)
+	mined(deployTx)
+
+	token := NewErc20(tokenAddr, client)
+	name, err := token.Name(nil)
+	require.NoError(t, err)
+	require.Equal(t, "Test Token", name)
+	symbol, err := token.Symbol(nil)
+	require.NoError(t, err)
+	require.Equal(t, "TST", symbol)
+	decimals, err := token.Decimals(nil)
+	require.NoError(t, err)
+	require.Equal(t, uint8(6), decimals)
+	supply, err := token.TotalSupply(nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(testTokenSupply), supply.Int64())
+	require.Equal(t, int64(testTokenSupply), balanceOf(token, addrs[0]))
+
+	amount, err := ParseTokenAmount("100.5", decimals)
+	require.NoError(t, err)
+	require.Equal(t, "100.500000", FormatTokenAmount(amount, decimals))
+
+	{ // transfer, eip-1559
+		gas, err := token.EstimateGas(addrs[0], "transfer", addrs[1], amount)
+		require.NoError(t, err)
+		require.Greater(t, gas, uint64(wallet.EtherTransferGas))
+
+		opts, err := MakeTransactOpts(wallets[0], TransactBaseParam{From: addrs[0]}, -1, -1)
+		require.NoError(t, err)
+		tx, err := token.Transfer(opts, addrs[1], amount)
+		require.NoError(t, err)
+		require.Equal(t, uint8(types.DynamicFeeTxType), tx.Type())
+		mined(tx)
+		require.Equal(t, amount.Int64(), balanceOf(token, addrs[1]))
+		require.Equal(t, testTokenSupply-amount.Int64(), balanceOf(token, addrs[0]))
+	}
+
+	{ // approve and transferFrom, legacy
+		opts, err := MakeTransactOpts(wallets[0], TransactBaseParam{From: addrs[0]}, -1, -1)
+		require.NoError(t, err)
+		tx, err := token.Approve(opts, addrs[1], big.NewInt(50))
+		require.NoError(t, err)
+		mined(tx)
+		allowance, err := token.Allowance(nil, addrs[0], addrs[1])
+		require.NoError(t, err)
+		require.Equal(t, int64(50), allowance.Int64())
+
+		gasPrice, err := client.SuggestGasPrice(ctx)
+		require.NoError(t, err)
+		opts, err = MakeTransactOpts(wallets[1], TransactBaseParam{From: addrs[1], GasPrice: gasPrice}, -1, -1)
+		require.NoError(t, err)
+		tx, err = token.TransferFrom(opts, addrs[0], addrs[2], big.NewInt(30))
+		require.NoError(t, err)
+		require.Equal(t, uint8(types.LegacyTxType), tx.Type())
+		mined(tx)
+		require.Equal(t, int64(30), balanceOf(token, addrs[2]))
+		allowance, err = token.Allowance(nil, addrs[0], addrs[1])
+		require.NoError(t, err)
+		require.Equal(t, int64(20), allowance.Int64())
+
+		// over the allowance
+		opts, err = MakeTransactOpts(wallets[1], TransactBaseParam{From: addrs[1]}, -1, -1)
+		require.NoError(t, err)
+		_, err = token.TransferFrom(opts, addrs[0], addrs[2], big.NewInt(30))
+		require.Error(t, err)
+	}
+
+	{ // ether keeps working on the same path
+		opts, err := MakeTransactOpts(wallets[0], TransactBaseParam{From: addrs[0], EthValue: big.NewInt(1)}, -1, -1)
+		require.NoError(t, err)
+		tx, err := TransferEther(opts, client, addrs[2])
+		require.NoError(t, err)
+		require.Equal(t, uint64(wallet.EtherTransferGas), tx.Gas())
+		mined(tx)
+		fmt.Println("ether transfer:", hexutil.Encode(tx.Hash().Bytes()))
+	}
+}
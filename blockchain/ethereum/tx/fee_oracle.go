@@ -0,0 +1,255 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
+)
+
+type FeeTier int
+
+const (
+	// FeeTierNone is the tip of SuggestGasTipCap and a fee cap of twice the base
+	// fee, without the oracle.
+	FeeTierNone FeeTier = iota
+	FeeTierSlow
+	FeeTierNormal
+	FeeTierFast
+)
+
+func (t FeeTier) String() string {
+	switch t {
+	case FeeTierNone:
+		return "none"
+	case FeeTierSlow:
+		return "slow"
+	case FeeTierNormal:
+		return "normal"
+	case FeeTierFast:
+		return "fast"
+	}
+	return "unknown"
+}
+
+const (
+	DefaultFeeHistoryBlocks = 20
+	DefaultBlockTime        = 12 * time.Second
+)
+
+// feeTierPercentiles are the eth_feeHistory reward percentiles of the tips of
+// slow, normal and fast.
+var feeTierPercentiles = []float64{10, 50, 90}
+
+// feeTierHeadroom is the number of full blocks, each raising the base fee by
+// 12.5%, the fee cap of a tier survives.
+var feeTierHeadroom = map[FeeTier]int{
+	FeeTierSlow:   2,
+	FeeTierNormal: 4,
+	FeeTierFast:   6,
+}
+
+type FeeSuggestion struct {
+	Tier      FeeTier
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+	// EstimatedWait is the expected time until inclusion, from the share of the
+	// recent blocks the tip would have been included in.
+	EstimatedWait time.Duration
+}
+
+// FeeCaps limit the suggestions of a chain, nil is no limit.
+type FeeCaps struct {
+	MaxGasTipCap *big.Int
+	MaxGasFeeCap *big.Int
+}
+
+func (c FeeCaps) Validate() error {
+	if c.MaxGasTipCap != nil && c.MaxGasFeeCap != nil && c.MaxGasFeeCap.Cmp(c.MaxGasTipCap) < 0 {
+		return errors.New("max fee cap is lower than the max tip cap")
+	}
+	return nil
+}
+
+// FeeHistoryBackend is the part of ethclient.Client the FeeOracle uses.
+type FeeHistoryBackend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+}
+
+// FeeOracle suggests EIP-1559 fees of the speed tiers from the eth_feeHistory
+// of the recent blocks.
+type FeeOracle struct {
+	backend FeeHistoryBackend
+	// Blocks is the history window and BlockTime the block interval of the
+	// chain.
+	Blocks    uint64
+	BlockTime time.Duration
+
+	capsMu sync.RWMutex
+	caps   map[int]FeeCaps
+}
+
+func NewFeeOracle(backend FeeHistoryBackend) *FeeOracle {
+	return &FeeOracle{backend: backend, Blocks: DefaultFeeHistoryBlocks, BlockTime: DefaultBlockTime}
+}
+
+// SetCaps sets the caps of the suggestions on chainId, the suggestions on
+// the chain of the backend take its caps.
+func (o *FeeOracle) SetCaps(chainId int, caps FeeCaps) error {
+	if _, err := wallet.GetEthChainParams(chainId); err != nil {
+		return err
+	}
+	if err := caps.Validate(); err != nil {
+		return err
+	}
+	o.capsMu.Lock()
+	defer o.capsMu.Unlock()
+	if o.caps == nil {
+		o.caps = make(map[int]FeeCaps)
+	}
+	o.caps[chainId] = caps
+	return nil
+}
+
+func (o *FeeOracle) GetCaps(chainId int) FeeCaps {
+	o.capsMu.RLock()
+	defer o.capsMu.RUnlock()
+	return o.caps[chainId]
+}
+
+// chainCaps returns the caps of the chain of the backend, which is only
+// asked for if any chain has caps.
+func (o *FeeOracle) chainCaps(ctx context.Context) (FeeCaps, error) {
+	o.capsMu.RLock()
+	hasCaps := len(o.caps) > 0
+	o.capsMu.RUnlock()
+	if !hasCaps {
+		return FeeCaps{}, nil
+	}
+	chainId, err := o.backend.ChainID(ctx)
+	if err != nil {
+		return FeeCaps{}, err
+	}
+	if !chainId.IsInt64() {
+		return FeeCaps{}, nil
+	}
+	return o.GetCaps(int(chainId.Int64())), nil
+}
+
+func (o *FeeOracle) Suggest(ctx context.Context, tier FeeTier) (*FeeSuggestion, error) {
+	if _, ok := feeTierHeadroom[tier]; !ok {
+		return nil, errors.New("invalid fee tier")
+	}
+	suggestions, err := o.SuggestAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return suggestions[tier-FeeTierSlow], nil
+}
+
+// SuggestAll returns the slow, normal and fast suggestions.
+func (o *FeeOracle) SuggestAll(ctx context.Context) ([]*FeeSuggestion, error) {
+	caps, err := o.chainCaps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	history, err := o.backend.FeeHistory(ctx, o.Blocks, nil, feeTierPercentiles)
+	if err != nil {
+		return nil, err
+	}
+	if len(history.BaseFee) == 0 || history.BaseFee[len(history.BaseFee)-1] == nil {
+		return nil, errors.New("chain has no base fee")
+	}
+
+	// the last base fee is of the next block
+	nextBaseFee := history.BaseFee[len(history.BaseFee)-1]
+	rising := nextBaseFee.Cmp(history.BaseFee[0]) > 0
+
+	// the rewards of the blocks with transactions
+	var rewards [][]*big.Int
+	for i, reward := range history.Reward {
+		if i < len(history.GasUsedRatio) && history.GasUsedRatio[i] > 0 && len(reward) == len(feeTierPercentiles) {
+			rewards = append(rewards, reward)
+		}
+	}
+	var fallbackTip *big.Int
+	if len(rewards) == 0 {
+		if fallbackTip, err = o.backend.SuggestGasTipCap(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var suggestions []*FeeSuggestion
+	for i, tier := range []FeeTier{FeeTierSlow, FeeTierNormal, FeeTierFast} {
+		tip := fallbackTip
+		if len(rewards) > 0 {
+			tip = medianReward(rewards, i)
+		}
+
+		headroom := feeTierHeadroom[tier]
+		if rising {
+			headroom++
+		}
+		feeCap := new(big.Int).Set(nextBaseFee)
+		for j := 0; j < headroom; j++ {
+			// +12.5%
+			feeCap.Add(feeCap, new(big.Int).Rsh(feeCap, 3))
+		}
+		feeCap.Add(feeCap, tip)
+
+		if caps.MaxGasTipCap != nil && tip.Cmp(caps.MaxGasTipCap) > 0 {
+			tip = caps.MaxGasTipCap
+		}
+		if caps.MaxGasFeeCap != nil && feeCap.Cmp(caps.MaxGasFeeCap) > 0 {
+			feeCap = caps.MaxGasFeeCap
+		}
+		if feeCap.Cmp(tip) < 0 {
+			tip = feeCap
+		}
+
+		suggestions = append(suggestions, &FeeSuggestion{
+			Tier:          tier,
+			GasTipCap:     new(big.Int).Set(tip),
+			GasFeeCap:     new(big.Int).Set(feeCap),
+			EstimatedWait: o.estimateWait(rewards, tip, feeCap, nextBaseFee),
+		})
+	}
+	return suggestions, nil
+}
+
+func medianReward(rewards [][]*big.Int, percentile int) *big.Int {
+	values := make([]*big.Int, 0, len(rewards))
+	for _, reward := range rewards {
+		values = append(values, reward[percentile])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Cmp(values[j]) < 0 })
+	return new(big.Int).Set(values[len(values)/2])
+}
+
+// estimateWait counts a block as including the tip if the tip is at least its
+// lowest percentile reward, the wait is geometric on the share of those blocks.
+func (o *FeeOracle) estimateWait(rewards [][]*big.Int, tip, feeCap, nextBaseFee *big.Int) time.Duration {
+	if feeCap.Cmp(nextBaseFee) < 0 {
+		return time.Duration(o.Blocks) * o.BlockTime
+	}
+	if len(rewards) == 0 {
+		return o.BlockTime
+	}
+	included := 0
+	for _, reward := range rewards {
+		if tip.Cmp(reward[0]) >= 0 {
+			included++
+		}
+	}
+	if included == 0 {
+		return time.Duration(o.Blocks) * o.BlockTime
+	}
+	return o.BlockTime * time.Duration(len(rewards)) / time.Duration(included)
+}
@@ -0,0 +1,169 @@
+package tx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeFeeHistory struct {
+	history *ethereum.FeeHistory
+	chainId int64
+}
+
+func (f *fakeFeeHistory) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(f.chainId), nil
+}
+
+func (f *fakeFeeHistory) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(7), nil
+}
+
+func (f *fakeFeeHistory) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int,
+	rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	return f.history, nil
+}
+
+func gwei(v int64) *big.Int {
+	return new(big.Int).Mul(big.NewInt(v), BigIntEthGWei)
+}
+
+func TestFeeOracle(t *testing.T) {
+	ctx := context.Background()
+	backend := &fakeFeeHistory{chainId: wallet.ChainMainNet, history: &ethereum.FeeHistory{
+		// 4 blocks and the next one
+		BaseFee:      []*big.Int{gwei(8), gwei(9), gwei(10), gwei(10), gwei(8)},
+		GasUsedRatio: []float64{0.5, 0, 0.7, 0.9},
+		Reward: [][]*big.Int{
+			{gwei(1), gwei(2), gwei(5)},
+			{big.NewInt(0), big.NewInt(0), big.NewInt(0)}, // empty block
+			{gwei(2), gwei(3), gwei(6)},
+			{gwei(1), gwei(2), gwei(4)},
+		},
+	}}
+	oracle := NewFeeOracle(backend)
+
+	suggestions, err := oracle.SuggestAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, suggestions, 3)
+	require.Equal(t, gwei(1), suggestions[0].GasTipCap)
+	require.Equal(t, gwei(2), suggestions[1].GasTipCap)
+	require.Equal(t, gwei(5), suggestions[2].GasTipCap)
+
+	// base fee 8 gwei with 2, 4 and 6 times +12.5%
+	require.Equal(t, new(big.Int).Add(gwei(1), big.NewInt(10125000000)), suggestions[0].GasFeeCap)
+	for i := 1; i < len(suggestions); i++ {
+		require.Equal(t, FeeTier(i+1), suggestions[i].Tier)
+		require.True(t, suggestions[i].GasFeeCap.Cmp(suggestions[i-1].GasFeeCap) > 0)
+		require.True(t, suggestions[i].EstimatedWait <= suggestions[i-1].EstimatedWait)
+	}
+	// the slow tip beats the lowest reward of 2 of the 3 blocks
+	require.Equal(t, DefaultBlockTime*3/2, suggestions[0].EstimatedWait)
+	require.Equal(t, DefaultBlockTime, suggestions[2].EstimatedWait)
+
+	// rising base fee, one more block of headroom
+	backend.history.BaseFee[4] = gwei(12)
+	fast, err := oracle.Suggest(ctx, FeeTierFast)
+	require.NoError(t, err)
+	expected := gwei(12)
+	for i := 0; i < 7; i++ {
+		expected.Add(expected, new(big.Int).Rsh(expected, 3))
+	}
+	require.Equal(t, expected.Add(expected, gwei(5)), fast.GasFeeCap)
+
+	// caps of the chains
+	capped := NewFeeOracle(backend)
+	require.Error(t, capped.SetCaps(wallet.ChainMainNet, FeeCaps{MaxGasTipCap: gwei(30), MaxGasFeeCap: gwei(3)}))
+	require.ErrorIs(t, capped.SetCaps(wallet.ChainGoerli, FeeCaps{}), wallet.ErrDeprecatedChain)
+	require.Error(t, capped.SetCaps(12345, FeeCaps{}))
+	require.NoError(t, capped.SetCaps(wallet.ChainMainNet, FeeCaps{MaxGasTipCap: gwei(3), MaxGasFeeCap: gwei(30)}))
+	require.NoError(t, capped.SetCaps(wallet.ChainSepolia, FeeCaps{MaxGasTipCap: gwei(4)}))
+	fast, err = capped.Suggest(ctx, FeeTierFast)
+	require.NoError(t, err)
+	require.Equal(t, gwei(3), fast.GasTipCap)
+	require.Equal(t, gwei(30), fast.GasFeeCap)
+	backend.chainId = wallet.ChainSepolia
+	fast, err = capped.Suggest(ctx, FeeTierFast)
+	require.NoError(t, err)
+	require.Equal(t, gwei(4), fast.GasTipCap)
+	require.Equal(t, expected, fast.GasFeeCap)
+	// a chain without caps
+	backend.chainId = wallet.ChainHolesky
+	fast, err = capped.Suggest(ctx, FeeTierFast)
+	require.NoError(t, err)
+	require.Equal(t, gwei(5), fast.GasTipCap)
+	backend.chainId = wallet.ChainMainNet
+	fast, err = oracle.Suggest(ctx, FeeTierFast)
+	require.NoError(t, err)
+	require.Equal(t, gwei(5), fast.GasTipCap)
+
+	// no transactions, the tip of the node
+	backend.history.GasUsedRatio = []float64{0, 0, 0, 0}
+	slow, err := oracle.Suggest(ctx, FeeTierSlow)
+	require.NoError(t, err)
+	require.Equal(t, big.NewInt(7), slow.GasTipCap)
+	_, err = oracle.Suggest(ctx, FeeTierNone)
+	require.Error(t, err)
+}
+
+func TestFeeTierTransfer(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	w0, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 1)
+	require.NoError(t, err)
+	ew0 := w0.(*wallet.EthWallet)
+	from, to := ew0.DeriveNativeAddress(), w1.(*wallet.EthWallet).DeriveNativeAddress()
+
+	cli, stop, err := node.RunSimulated(ew0)
+	require.NoError(t, err)
+	defer stop()
+
+	// recent blocks with tips of 1 to 5 gwei
+	for i := int64(1); i <= 5; i++ {
+		param := TransactBaseParam{From: from, EthValue: big.NewInt(1), GasTipCap: gwei(i)}
+		require.NoError(t, param.EnsureGasPrice(cli.RpcClient))
+		opts, err := MakeTransactOpts(ew0, param, -1, -1)
+		require.NoError(t, err)
+		_, err = TransferEther(opts, cli.RpcClient, to)
+		require.NoError(t, err)
+		cli.Commit()
+	}
+
+	// through the nonce manager too, the blocks have one transaction each so
+	// every percentile is the median tip
+	backend := NewNonceManager().Backend(cli.RpcClient, wallet.ChainPrivate)
+	var feeCaps []*big.Int
+	for _, tier := range []FeeTier{FeeTierSlow, FeeTierFast} {
+		param := TransactBaseParam{From: from, EthValue: big.NewInt(1), Tier: tier}
+		require.NoError(t, param.EnsureGasPrice(backend))
+		opts, err := MakeTransactOpts(ew0, param, -1, -1)
+		require.NoError(t, err)
+		tx, err := TransferEther(opts, backend, to)
+		require.NoError(t, err)
+		require.Equal(t, uint8(types.DynamicFeeTxType), tx.Type())
+		require.True(t, tx.GasFeeCap().Cmp(param.BaseFee) > 0)
+		require.Equal(t, gwei(3), tx.GasTipCap())
+		feeCaps = append(feeCaps, tx.GasFeeCap())
+		cli.Commit()
+		receipt, err := cli.RpcClient.TransactionReceipt(context.Background(), tx.Hash())
+		require.NoError(t, err)
+		require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	}
+	require.True(t, feeCaps[1].Cmp(feeCaps[0]) > 0)
+
+	// the caps of the param limit the tier
+	param := TransactBaseParam{From: from, EthValue: big.NewInt(1), Tier: FeeTierFast,
+		FeeCaps: map[int]FeeCaps{wallet.ChainPrivate: {MaxGasTipCap: gwei(2)}, wallet.ChainMainNet: {MaxGasTipCap: gwei(1)}}}
+	require.NoError(t, param.EnsureGasPrice(backend))
+	require.Equal(t, gwei(2), param.GasTipCap)
+}
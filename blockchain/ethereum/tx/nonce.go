@@ -0,0 +1,302 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// NonceSource gives the pending nonce of an account, bind.ContractBackend
+// implements it.
+type NonceSource interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+type nonceKey struct {
+	chainId int
+	address common.Address
+}
+
+type accountNonces struct {
+	synced bool
+	next   uint64
+	// reserved are handed out and not sent yet, inFlight are sent and not
+	// confirmed yet.
+	reserved map[uint64]struct{}
+	inFlight map[uint64]common.Hash
+	// free are released nonces below next, ascending, they are handed out
+	// before next.
+	free []uint64
+}
+
+// NonceManager hands out the nonces of accounts for concurrent sends, keyed by
+// chain id and address. A nonce is reserved by Next and then either Sent or
+// Released, a sent nonce is Confirmed when mined or Released when its
+// transaction is dropped. Released nonces fill the gaps first.
+type NonceManager struct {
+	mu       sync.Mutex
+	accounts map[nonceKey]*accountNonces
+}
+
+func NewNonceManager() *NonceManager {
+	return &NonceManager{accounts: make(map[nonceKey]*accountNonces)}
+}
+
+func (m *NonceManager) account(chainId int, address common.Address) *accountNonces {
+	key := nonceKey{chainId: chainId, address: address}
+	a, ok := m.accounts[key]
+	if !ok {
+		a = &accountNonces{reserved: make(map[uint64]struct{}), inFlight: make(map[uint64]common.Hash)}
+		m.accounts[key] = a
+	}
+	return a
+}
+
+// Next reserves the next nonce of address, the account is synced from source
+// the first time.
+func (m *NonceManager) Next(ctx context.Context, source NonceSource, chainId int, address common.Address) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.account(chainId, address)
+	if !a.synced {
+		if err := a.resync(ctx, source, address); err != nil {
+			return 0, err
+		}
+	}
+
+	var nonce uint64
+	if len(a.free) > 0 {
+		nonce = a.free[0]
+		a.free = a.free[1:]
+	} else {
+		nonce = a.next
+		a.next++
+	}
+	a.reserved[nonce] = struct{}{}
+	return nonce, nil
+}
+
+// Sent marks a reserved nonce as in flight with the transaction hash.
+func (m *NonceManager) Sent(chainId int, address common.Address, nonce uint64, hash common.Hash) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.account(chainId, address)
+	delete(a.reserved, nonce)
+	a.inFlight[nonce] = hash
+}
+
+// Release gives back a reserved nonce which wasn't sent, or a sent one whose
+// transaction was dropped, so the gap is filled by the next send.
+func (m *NonceManager) Release(chainId int, address common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.account(chainId, address)
+	_, reserved := a.reserved[nonce]
+	_, inFlight := a.inFlight[nonce]
+	if !reserved && !inFlight {
+		return
+	}
+	delete(a.reserved, nonce)
+	delete(a.inFlight, nonce)
+	i := sort.Search(len(a.free), func(i int) bool { return a.free[i] >= nonce })
+	if i < len(a.free) && a.free[i] == nonce {
+		return
+	}
+	a.free = append(a.free, 0)
+	copy(a.free[i+1:], a.free[i:])
+	a.free[i] = nonce
+
+	// trailing free nonces are just not used yet
+	for len(a.free) > 0 && a.free[len(a.free)-1] == a.next-1 {
+		a.free = a.free[:len(a.free)-1]
+		a.next--
+	}
+}
+
+// Confirm removes a mined nonce from the in-flight ones, the lower free nonces
+// can't be used anymore.
+func (m *NonceManager) Confirm(chainId int, address common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a := m.account(chainId, address)
+	delete(a.inFlight, nonce)
+	i := sort.Search(len(a.free), func(i int) bool { return a.free[i] > nonce })
+	a.free = a.free[i:]
+}
+
+// InFlight returns the sent and not confirmed transactions of address by nonce.
+func (m *NonceManager) InFlight(chainId int, address common.Address) map[uint64]common.Hash {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inFlight := make(map[uint64]common.Hash)
+	for nonce, hash := range m.account(chainId, address).inFlight {
+		inFlight[nonce] = hash
+	}
+	return inFlight
+}
+
+// Resync resets address to the pending nonce of source, e.g. after a restart
+// or a "nonce too low" error. In-flight transactions at or above the pending
+// nonce are not known to the node anymore and their nonces are freed.
+func (m *NonceManager) Resync(ctx context.Context, source NonceSource, chainId int, address common.Address) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.account(chainId, address).resync(ctx, source, address)
+}
+
+func (a *accountNonces) resync(ctx context.Context, source NonceSource, address common.Address) error {
+	pending, err := source.PendingNonceAt(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	for nonce := range a.inFlight {
+		if nonce >= pending {
+			delete(a.inFlight, nonce)
+		}
+	}
+	for nonce := range a.reserved {
+		if nonce < pending {
+			delete(a.reserved, nonce)
+		}
+	}
+
+	// reserved nonces may be sent later, the others up to them are free
+	next := pending
+	for nonce := range a.reserved {
+		if nonce >= next {
+			next = nonce + 1
+		}
+	}
+	a.free = a.free[:0]
+	for nonce := pending; nonce < next; nonce++ {
+		if _, ok := a.reserved[nonce]; !ok {
+			a.free = append(a.free, nonce)
+		}
+	}
+	a.next = next
+	a.synced = true
+	return nil
+}
+
+// Backend wraps backend of chainId so the nonces of TransferEther, Erc20 and
+// other bind calls with a nil opts.Nonce come from m. A "nonce too low" error
+// on send resyncs the account. A transaction signed with opts.NoSend keeps its
+// nonce reserved, the caller sends it with SendTransaction or gives the nonce
+// back with ReleaseNonce.
+func (m *NonceManager) Backend(backend bind.ContractBackend, chainId int) *NonceManagedBackend {
+	return &NonceManagedBackend{ContractBackend: backend, manager: m, chainId: chainId}
+}
+
+type NonceManagedBackend struct {
+	bind.ContractBackend
+	manager *NonceManager
+	chainId int
+}
+
+func (b *NonceManagedBackend) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return b.manager.Next(ctx, b.ContractBackend, b.chainId, account)
+}
+
+func (b *NonceManagedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	from, err := types.Sender(types.LatestSignerForChainID(big.NewInt(int64(b.chainId))), tx)
+	if err != nil {
+		return err
+	}
+
+	err = b.ContractBackend.SendTransaction(ctx, tx)
+	if err == nil {
+		b.manager.Sent(b.chainId, from, tx.Nonce(), tx.Hash())
+		return nil
+	}
+	if IsNonceTooLow(err) {
+		// the nonce is used, resync drops it
+		if resyncErr := b.manager.Resync(ctx, b.ContractBackend, b.chainId, from); resyncErr != nil {
+			return resyncErr
+		}
+		return err
+	}
+	if IsRejected(err) {
+		b.manager.Release(b.chainId, from, tx.Nonce())
+		return err
+	}
+	// the node may have it, e.g. after a timeout or "already known", the nonce
+	// stays in flight until it's confirmed or released as dropped
+	b.manager.Sent(b.chainId, from, tx.Nonce(), tx.Hash())
+	return err
+}
+
+func (b *NonceManagedBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(int64(b.chainId)), nil
+}
+
+func (b *NonceManagedBackend) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int,
+	rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+
+	feeBackend, ok := b.ContractBackend.(FeeHistoryBackend)
+	if !ok {
+		return nil, errors.New("backend has no fee history")
+	}
+	return feeBackend.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+}
+
+// ReleaseNonce releases a nonce which transact took but didn't send.
+func (b *NonceManagedBackend) ReleaseNonce(account common.Address, nonce uint64) {
+	b.manager.Release(b.chainId, account, nonce)
+}
+
+func IsNonceTooLow(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "nonce too low")
+}
+
+// rejectedErrors are the errors of the node for a transaction it didn't take,
+// "replacement transaction underpriced" isn't one, the nonce is used by the
+// transaction it didn't replace.
+var rejectedErrors = []string{
+	"insufficient funds",
+	"intrinsic gas too low",
+	"exceeds block gas limit",
+	"gas limit reached",
+	"max fee per gas less than block base fee",
+	"max priority fee per gas higher than max fee per gas",
+	"fee cap less than block base fee",
+	"tip higher than fee cap",
+	"transaction type not supported",
+	"invalid sender",
+	"oversized data",
+	"negative value",
+	"exceeds the configured cap",
+	"only replay-protected",
+	"nonce too high",
+}
+
+// IsRejected reports whether err is a send error of a transaction the node
+// provably didn't take, so its nonce can be used again.
+func IsRejected(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "transaction underpriced") && !strings.Contains(msg, "replacement") {
+		return true
+	}
+	for _, rejected := range rejectedErrors {
+		if strings.Contains(msg, rejected) {
+			return true
+		}
+	}
+	return false
+}
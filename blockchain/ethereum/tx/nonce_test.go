@@ -0,0 +1,225 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNonceSource uint64
+
+func (s *fakeNonceSource) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return uint64(*s), nil
+}
+
+// sendErrorBackend fails every send with err.
+type sendErrorBackend struct {
+	bind.ContractBackend
+	err error
+}
+
+func (b sendErrorBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return b.err
+}
+
+func TestNonceManager(t *testing.T) {
+	ctx := context.Background()
+	source := fakeNonceSource(5)
+	addr := common.HexToAddress("0x1")
+	m := NewNonceManager()
+
+	next := func() uint64 {
+		nonce, err := m.Next(ctx, &source, wallet.ChainPrivate, addr)
+		require.NoError(t, err)
+		return nonce
+	}
+
+	// synced from the source, keyed by chain id
+	require.Equal(t, uint64(5), next())
+	require.Equal(t, uint64(6), next())
+	other, err := m.Next(ctx, &source, wallet.ChainMainNet, addr)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), other)
+
+	// gaps are filled first
+	n7, n8 := next(), next()
+	m.Sent(wallet.ChainPrivate, addr, 5, common.Hash{5})
+	m.Sent(wallet.ChainPrivate, addr, 7, common.Hash{7})
+	m.Release(wallet.ChainPrivate, addr, 6)
+	m.Release(wallet.ChainPrivate, addr, n7) // dropped
+	require.Equal(t, uint64(6), next())
+	require.Equal(t, uint64(7), next())
+	require.Equal(t, uint64(9), next())
+
+	// the top nonces are just given back
+	m.Release(wallet.ChainPrivate, addr, 9)
+	m.Release(wallet.ChainPrivate, addr, n8)
+	require.Equal(t, uint64(8), next())
+	require.Equal(t, map[uint64]common.Hash{5: {5}}, m.InFlight(wallet.ChainPrivate, addr))
+	m.Confirm(wallet.ChainPrivate, addr, 5)
+	require.Empty(t, m.InFlight(wallet.ChainPrivate, addr))
+
+	// nonce too low, another sender used up to 10
+	source = 11
+	require.NoError(t, m.Resync(ctx, &source, wallet.ChainPrivate, addr))
+	require.Equal(t, uint64(11), next())
+
+	// concurrent
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var nonces []int
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nonce, err := m.Next(ctx, &source, wallet.ChainPrivate, addr)
+			mu.Lock()
+			if err == nil {
+				nonces = append(nonces, int(nonce))
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	require.Len(t, nonces, 50)
+	sort.Ints(nonces)
+	for i, nonce := range nonces {
+		require.Equal(t, 12+i, nonce)
+	}
+}
+
+func TestNonceManagedTransfer(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	w0, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 1)
+	require.NoError(t, err)
+	ew0 := w0.(*wallet.EthWallet)
+	from, to := ew0.DeriveNativeAddress(), w1.(*wallet.EthWallet).DeriveNativeAddress()
+
+	cli, stop, err := node.RunSimulated(ew0)
+	require.NoError(t, err)
+	defer stop()
+
+	m := NewNonceManager()
+	backend := m.Backend(cli.RpcClient, wallet.ChainPrivate)
+
+	// concurrent payouts from one wallet
+	const count = 10
+	txs := make(chan *types.Transaction, count)
+	errs := make(chan error, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts, err := MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(1)}, -1, -1)
+			if err == nil {
+				var tx *types.Transaction
+				if tx, err = TransferEther(opts, backend, to); err == nil {
+					txs <- tx
+				}
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(txs)
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Len(t, m.InFlight(wallet.ChainPrivate, from), count)
+
+	cli.Commit()
+	for tx := range txs {
+		receipt, err := cli.RpcClient.TransactionReceipt(context.Background(), tx.Hash())
+		require.NoError(t, err)
+		require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+		m.Confirm(wallet.ChainPrivate, from, tx.Nonce())
+	}
+	require.Empty(t, m.InFlight(wallet.ChainPrivate, from))
+
+	// a nonce used outside the manager
+	opts, err := MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(1)}, -1, count)
+	require.NoError(t, err)
+	_, err = TransferEther(opts, cli.RpcClient, to)
+	require.NoError(t, err)
+	cli.Commit()
+
+	opts, err = MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(1)}, -1, -1)
+	require.NoError(t, err)
+	_, err = TransferEther(opts, backend, to)
+	require.True(t, IsNonceTooLow(err))
+	opts, err = MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(1)}, -1, -1)
+	require.NoError(t, err)
+	tx, err := TransferEther(opts, backend, to)
+	require.NoError(t, err)
+	require.Equal(t, uint64(count+1), tx.Nonce())
+
+	cli.Commit()
+	m.Confirm(wallet.ChainPrivate, from, tx.Nonce())
+	next := uint64(count + 2)
+
+	{ // a rejected transaction gives its nonce back
+		opts, err := MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(0).Lsh(big.NewInt(1), 128)}, -1, -1)
+		require.NoError(t, err)
+		_, err = TransferEther(opts, backend, to)
+		require.True(t, IsRejected(err))
+		require.Empty(t, m.InFlight(wallet.ChainPrivate, from))
+		nonce, err := m.Next(context.Background(), cli.RpcClient, wallet.ChainPrivate, from)
+		require.NoError(t, err)
+		require.Equal(t, next, nonce)
+		m.Release(wallet.ChainPrivate, from, nonce)
+	}
+
+	{ // the node may have it, the nonce stays in flight
+		for _, sendErr := range []error{
+			context.DeadlineExceeded,
+			errors.New("already known"),
+			errors.New("replacement transaction underpriced"),
+		} {
+			require.False(t, IsRejected(sendErr))
+			failing := m.Backend(sendErrorBackend{ContractBackend: cli.RpcClient, err: sendErr}, wallet.ChainPrivate)
+			opts, err := MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(1)}, -1, -1)
+			require.NoError(t, err)
+			tx, err := TransferEther(opts, failing, to)
+			require.ErrorIs(t, err, sendErr)
+			require.Nil(t, tx)
+			inFlight := m.InFlight(wallet.ChainPrivate, from)
+			require.Contains(t, inFlight, next)
+			m.Release(wallet.ChainPrivate, from, next)
+		}
+		require.True(t, IsRejected(errors.New("transaction underpriced")))
+	}
+
+	{ // a signed and not sent transaction keeps its nonce until it's sent or released
+		opts, err := MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: big.NewInt(1)}, -1, -1)
+		require.NoError(t, err)
+		opts.NoSend = true
+		unsent, err := TransferEther(opts, backend, to)
+		require.NoError(t, err)
+		require.Equal(t, next, unsent.Nonce())
+		opts.NoSend = false
+		tx, err := TransferEther(opts, backend, to)
+		require.NoError(t, err)
+		require.Equal(t, next+1, tx.Nonce())
+		backend.ReleaseNonce(from, unsent.Nonce())
+		tx, err = TransferEther(opts, backend, to)
+		require.NoError(t, err)
+		require.Equal(t, next, tx.Nonce())
+	}
+}
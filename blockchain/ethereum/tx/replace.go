@@ -0,0 +1,102 @@
+package tx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ReplaceBumpPercent is the minimum fee bump of geth's txpool to replace a
+// pending transaction of the same nonce.
+const ReplaceBumpPercent = 10
+
+// SpeedUp replaces the pending tx of w by the same transaction with bumped fees.
+func SpeedUp(w *wallet.EthWallet, backend bind.ContractBackend, tx *types.Transaction) (*types.Transaction, error) {
+	return replace(w, backend, tx, tx.To(), tx.Value(), tx.Data(), tx.Gas())
+}
+
+// Cancel replaces the pending tx of w by a zero value transfer to itself with
+// bumped fees.
+func Cancel(w *wallet.EthWallet, backend bind.ContractBackend, tx *types.Transaction) (*types.Transaction, error) {
+	from := w.DeriveNativeAddress()
+	return replace(w, backend, tx, &from, big.NewInt(0), nil, wallet.EtherTransferGas)
+}
+
+// replace sends the replacement of orig with the fees of orig bumped by
+// ReplaceBumpPercent, or the current fees if they are higher.
+func replace(w *wallet.EthWallet, backend bind.ContractBackend, orig *types.Transaction,
+	to *common.Address, value *big.Int, data []byte, gas uint64) (*types.Transaction, error) {
+
+	from := w.DeriveNativeAddress()
+	sender, err := types.Sender(types.LatestSigner(w.ChainParams()), orig)
+	if err != nil {
+		return nil, err
+	}
+	if sender != from {
+		return nil, errors.New("transaction is not sent by the wallet")
+	}
+	if to == nil {
+		return nil, errors.New("contract creation can't be replaced")
+	}
+
+	param := TransactBaseParam{From: from}
+	if err = param.EnsureGasPrice(backend); err != nil {
+		return nil, err
+	}
+
+	var tx *types.Transaction
+	switch orig.Type() {
+	case types.LegacyTxType:
+		tx = types.NewTx(&types.LegacyTx{
+			Nonce:    orig.Nonce(),
+			To:       to,
+			GasPrice: maxBig(bumpFee(orig.GasPrice()), param.GetGasPrice()),
+			Gas:      gas,
+			Value:    value,
+			Data:     data,
+		})
+	case types.DynamicFeeTxType:
+		tip := maxBig(bumpFee(orig.GasTipCap()), param.GasTipCap)
+		feeCap := maxBig(bumpFee(orig.GasFeeCap()), param.GasFeeCap)
+		tx = types.NewTx(&types.DynamicFeeTx{
+			Nonce:      orig.Nonce(),
+			To:         to,
+			GasTipCap:  tip,
+			GasFeeCap:  maxBig(feeCap, tip),
+			Gas:        gas,
+			Value:      value,
+			Data:       data,
+			AccessList: orig.AccessList(),
+		})
+	default:
+		return nil, errors.New("unsupported transaction type")
+	}
+
+	signedTx, err := SignTx(w, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err = backend.SendTransaction(context.Background(), signedTx); err != nil {
+		return nil, err
+	}
+	return signedTx, nil
+}
+
+// bumpFee returns fee increased by ReplaceBumpPercent, rounded up.
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+ReplaceBumpPercent))
+	bumped.Add(bumped, big.NewInt(99))
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if b == nil || a.Cmp(b) >= 0 {
+		return new(big.Int).Set(a)
+	}
+	return new(big.Int).Set(b)
+}
@@ -0,0 +1,96 @@
+package tx
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/ethereum/node"
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplace(t *testing.T) {
+	require.Equal(t, int64(110), bumpFee(big.NewInt(100)).Int64())
+	require.Equal(t, int64(112), bumpFee(big.NewInt(101)).Int64())
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	hdw, err := wallet.NewHDWallet(mnemonic, "", wallet.BtcChainMainNet, wallet.ChainPrivate)
+	require.NoError(t, err)
+	w0, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	w1, err := hdw.NewWallet(wallet.SymbolEth, 0, 0, 1)
+	require.NoError(t, err)
+	ew0, ew1 := w0.(*wallet.EthWallet), w1.(*wallet.EthWallet)
+	from, to := ew0.DeriveNativeAddress(), ew1.DeriveNativeAddress()
+
+	cli, stop, err := node.RunSimulated(ew0)
+	require.NoError(t, err)
+	defer stop()
+	ctx := context.Background()
+	amount := big.NewInt(wallet.WeiPerEther)
+
+	requireMined := func(mined, replaced *types.Transaction) {
+		receipt, err := cli.RpcClient.TransactionReceipt(ctx, mined.Hash())
+		require.NoError(t, err)
+		require.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+		_, err = cli.RpcClient.TransactionReceipt(ctx, replaced.Hash())
+		require.ErrorIs(t, err, ethereum.NotFound)
+	}
+
+	{ // speed up eip-1559
+		param := TransactBaseParam{From: from, EthValue: amount, GasTipCap: big.NewInt(1)}
+		require.NoError(t, param.EnsureGasPrice(cli.RpcClient))
+		opts, err := MakeTransactOpts(ew0, param, -1, -1)
+		require.NoError(t, err)
+		tx, err := TransferEther(opts, cli.RpcClient, to)
+		require.NoError(t, err)
+
+		fast, err := SpeedUp(ew0, cli.RpcClient, tx)
+		require.NoError(t, err)
+		require.Equal(t, tx.Nonce(), fast.Nonce())
+		require.Equal(t, uint8(types.DynamicFeeTxType), fast.Type())
+		require.True(t, fast.GasTipCap().Cmp(bumpFee(tx.GasTipCap())) >= 0)
+		require.True(t, fast.GasFeeCap().Cmp(bumpFee(tx.GasFeeCap())) >= 0)
+		require.Equal(t, tx.Value(), fast.Value())
+
+		// the same bump again is accepted too
+		faster, err := SpeedUp(ew0, cli.RpcClient, fast)
+		require.NoError(t, err)
+		cli.Commit()
+		requireMined(faster, tx)
+
+		balance, err := cli.RpcClient.BalanceAt(ctx, to, nil)
+		require.NoError(t, err)
+		require.Equal(t, amount, balance)
+	}
+
+	{ // cancel legacy
+		gasPrice, err := cli.RpcClient.SuggestGasPrice(ctx)
+		require.NoError(t, err)
+		opts, err := MakeTransactOpts(ew0, TransactBaseParam{From: from, EthValue: amount, GasPrice: gasPrice}, -1, -1)
+		require.NoError(t, err)
+		tx, err := TransferEther(opts, cli.RpcClient, to)
+		require.NoError(t, err)
+
+		// not the sender
+		_, err = Cancel(ew1, cli.RpcClient, tx)
+		require.Error(t, err)
+
+		cancel, err := Cancel(ew0, cli.RpcClient, tx)
+		require.NoError(t, err)
+		require.Equal(t, uint8(types.LegacyTxType), cancel.Type())
+		require.Equal(t, from, *cancel.To())
+		require.Zero(t, cancel.Value().Sign())
+		require.True(t, cancel.GasPrice().Cmp(bumpFee(tx.GasPrice())) >= 0)
+		cli.Commit()
+		requireMined(cancel, tx)
+
+		balance, err := cli.RpcClient.BalanceAt(ctx, to, nil)
+		require.NoError(t, err)
+		require.Equal(t, amount, balance)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"math/big"
 
 	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -21,6 +22,11 @@ type TransactBaseParam struct {
 	GasFeeCap *big.Int
 	GasTipCap *big.Int
 	BaseFee   *big.Int
+	// Tier takes the unset fee caps from the FeeOracle of the backend on
+	// EnsureGasPrice, which has to be called before MakeTransactOpts.
+	Tier FeeTier
+	// FeeCaps limit the suggestion of Tier on the chains of their ids.
+	FeeCaps map[int]FeeCaps
 }
 
 func (t *TransactBaseParam) EnsureGasPrice(backend bind.ContractBackend) error {
@@ -42,6 +48,28 @@ func (t *TransactBaseParam) EnsureGasPrice(backend bind.ContractBackend) error {
 		}
 	} else {
 		// eip-1559
+		if t.Tier != FeeTierNone && (t.GasTipCap == nil || t.GasFeeCap == nil) {
+			feeBackend, ok := backend.(FeeHistoryBackend)
+			if !ok {
+				return errors.New("backend has no fee history")
+			}
+			oracle := NewFeeOracle(feeBackend)
+			for chainId, caps := range t.FeeCaps {
+				if err := oracle.SetCaps(chainId, caps); err != nil {
+					return err
+				}
+			}
+			suggestion, err := oracle.Suggest(context.Background(), t.Tier)
+			if err != nil {
+				return err
+			}
+			if t.GasTipCap == nil {
+				t.GasTipCap = suggestion.GasTipCap
+			}
+			if t.GasFeeCap == nil {
+				t.GasFeeCap = suggestion.GasFeeCap
+			}
+		}
 		if t.GasTipCap == nil {
 			tip, err := backend.SuggestGasTipCap(context.Background())
 			if err != nil {
@@ -81,6 +109,11 @@ func SignTx(w *wallet.EthWallet, tx *types.Transaction) (*types.Transaction, err
 	return signedTx, nil
 }
 
+// MakeTransactOpts makes the opts of w. With a negative nonce the pending nonce
+// is taken from the backend on send, which is the NonceManager's for a backend
+// of NonceManager.Backend. With NoSend set on the opts such a nonce stays
+// reserved until the transaction is sent or the nonce is released with
+// NonceManagedBackend.ReleaseNonce.
 func MakeTransactOpts(w *wallet.EthWallet, param TransactBaseParam, gasLimit int64, nonce int64) (*bind.TransactOpts, error) {
 	var theNonce *big.Int
 	if nonce >= 0 {
@@ -107,41 +140,77 @@ func MakeTransactOpts(w *wallet.EthWallet, param TransactBaseParam, gasLimit int
 	return txOpts, nil
 }
 
+// nonceReleaser is a backend which hands out nonces, see NonceManager.Backend.
+type nonceReleaser interface {
+	ReleaseNonce(account common.Address, nonce uint64)
+}
+
 func TransferEther(opts *bind.TransactOpts, backend bind.ContractBackend, addressTo common.Address) (*types.Transaction, error) {
-	// nonce
-	var nonce uint64
-	if opts.Nonce != nil {
-		nonce = opts.Nonce.Uint64()
-	} else {
-		tmp, err := backend.PendingNonceAt(context.Background(), opts.From)
+	return transact(opts, backend, addressTo, nil)
+}
+
+// transact builds, signs and sends a transaction to addressTo with input. The
+// envelope is legacy when opts has a gas price or the chain has no base fee,
+// otherwise EIP-1559. The gas limit is estimated if opts has none.
+func transact(opts *bind.TransactOpts, backend bind.ContractBackend, addressTo common.Address, input []byte) (*types.Transaction, error) {
+	if opts.GasPrice != nil && (opts.GasFeeCap != nil || opts.GasTipCap != nil) {
+		return nil, errors.New("both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified")
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// check and set fee
+	if opts.GasPrice == nil {
+		param := TransactBaseParam{
+			GasFeeCap: opts.GasFeeCap,
+			GasTipCap: opts.GasTipCap,
+		}
+		err := param.EnsureGasPrice(backend)
 		if err != nil {
 			return nil, err
 		}
-		nonce = tmp
+		opts.GasPrice = param.GasPrice
+		opts.GasFeeCap = param.GasFeeCap
+		opts.GasTipCap = param.GasTipCap
 	}
 
 	// gas limit
 	gasLimit := opts.GasLimit
 	if gasLimit == 0 {
-		gasLimit = wallet.EtherTransferGas
+		if len(input) == 0 {
+			gasLimit = wallet.EtherTransferGas
+		} else {
+			estimated, err := backend.EstimateGas(ctx, ethereum.CallMsg{
+				From:      opts.From,
+				To:        &addressTo,
+				GasPrice:  opts.GasPrice,
+				GasFeeCap: opts.GasFeeCap,
+				GasTipCap: opts.GasTipCap,
+				Value:     opts.Value,
+				Data:      input,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to estimate gas: %w", err)
+			}
+			gasLimit = estimated
+		}
 	}
 
-	param := TransactBaseParam{
-		GasPrice:  opts.GasPrice,
-		GasFeeCap: opts.GasFeeCap,
-		GasTipCap: opts.GasTipCap,
-	}
-	// check and set fee
-	err := param.EnsureGasPrice(backend)
-	if err != nil {
-		return nil, err
+	// nonce, taken last so a failure doesn't leave a gap
+	var nonce uint64
+	if opts.Nonce != nil {
+		nonce = opts.Nonce.Uint64()
+	} else {
+		tmp, err := backend.PendingNonceAt(ctx, opts.From)
+		if err != nil {
+			return nil, err
+		}
+		nonce = tmp
 	}
-	opts.GasPrice = param.GasPrice
-	opts.GasFeeCap = param.GasFeeCap
-	opts.GasTipCap = param.GasTipCap
 
 	var tx *types.Transaction
-	var input []byte
 	if opts.GasFeeCap == nil {
 		baseTx := &types.LegacyTx{
 			Nonce:    nonce,
@@ -168,15 +237,19 @@ func TransferEther(opts *bind.TransactOpts, backend bind.ContractBackend, addres
 	// sign tx
 	signedTx, err := opts.Signer(opts.From, tx)
 	if err != nil {
+		if releaser, ok := backend.(nonceReleaser); ok && opts.Nonce == nil {
+			releaser.ReleaseNonce(opts.From, nonce)
+		}
 		return nil, err
 	}
 
+	// a managed nonce stays reserved, see MakeTransactOpts
 	if opts.NoSend {
 		return signedTx, nil
 	}
 
 	// send trasaction
-	err = backend.SendTransaction(context.Background(), signedTx)
+	err = backend.SendTransaction(ctx, signedTx)
 	if err != nil {
 		return nil, err
 	}
@@ -42,10 +42,10 @@ func TestTransaction(t *testing.T) {
 	addrA0, _ := HexToAddress(a0)
 	addrA1, _ := HexToAddress(a1)
 
-	// coonect eth client
-	// TODO: find a url
-	cli, err := node.NewEthClient("")
+	// start a simulated chain, a0 holds 100 ether
+	cli, stop, err := node.RunSimulated(w0.(*wallet.EthWallet))
 	require.NoError(t, err)
+	defer stop()
 
 	// get balance
 	{
@@ -99,6 +99,9 @@ func TestTransaction(t *testing.T) {
 		b, _ := json.MarshalIndent(tx, "", " ")
 		fmt.Println("tx:", string(b))
 
+		// mine the transaction
+		cli.Commit()
+
 		fmt.Println("get transaction receipt ----------")
 
 		// transaction receipt
@@ -0,0 +1,29 @@
+// Package jsonrpc holds the json-rpc helpers shared by the transports of
+// rpcpool and limiter.
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// IsErrorResponse reports whether a 5xx response is a json-rpc error, which
+// bitcoind answers with status 500, rather than a failure of the node. The
+// body is kept for the caller.
+func IsErrorResponse(resp *http.Response) bool {
+	body := resp.Body
+	data, err := io.ReadAll(io.LimitReader(body, 1<<20))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), body), body}
+	if err != nil {
+		return false
+	}
+	var msg struct {
+		Error json.RawMessage `json:"error"`
+	}
+	return json.Unmarshal(data, &msg) == nil && len(msg.Error) > 0 && string(msg.Error) != "null"
+}
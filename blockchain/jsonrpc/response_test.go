@@ -0,0 +1,25 @@
+package jsonrpc
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsErrorResponse(t *testing.T) {
+	for body, want := range map[string]bool{
+		`{"result":null,"error":{"code":-5,"message":"not found"},"id":1}`: true,
+		`{"result":null,"error":null,"id":1}`:                              false,
+		`bad gateway`:                                                      false,
+	} {
+		resp := &http.Response{StatusCode: http.StatusInternalServerError, Body: io.NopCloser(strings.NewReader(body))}
+		require.Equal(t, want, IsErrorResponse(resp), body)
+		// the body is kept
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(data))
+	}
+}
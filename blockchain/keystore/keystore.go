@@ -0,0 +1,236 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Kdf is the key derivation function which stretches the passphrase.
+type Kdf string
+
+const (
+	KdfScrypt Kdf = "scrypt"
+	KdfPbkdf2 Kdf = "pbkdf2"
+
+	version = 3
+
+	typeMnemonic = "mnemonic"
+	typeSeed     = "seed"
+)
+
+var (
+	ErrDecrypt     = keystore.ErrDecrypt
+	ErrWrongType   = errors.New("keystore holds another kind of secret")
+	ErrWrongFormat = errors.New("not a version 3 keystore")
+)
+
+// Params are the cost parameters of the kdf.
+type Params struct {
+	Kdf Kdf
+	// ScryptN and ScryptP are the scrypt CPU/memory cost and parallelization.
+	ScryptN int
+	ScryptP int
+	// Pbkdf2Iterations is the HMAC-SHA256 iteration count.
+	Pbkdf2Iterations int
+}
+
+var (
+	// StandardParams are geth's defaults, about 1 second and 256MB per unlock.
+	StandardParams = Params{Kdf: KdfScrypt, ScryptN: keystore.StandardScryptN, ScryptP: keystore.StandardScryptP}
+	// LightParams are cheap scrypt parameters for tests and constrained
+	// devices.
+	LightParams = Params{Kdf: KdfScrypt, ScryptN: keystore.LightScryptN, ScryptP: keystore.LightScryptP}
+	// Pbkdf2Params is the pbkdf2 alternative, as written by MyEtherWallet.
+	Pbkdf2Params = Params{Kdf: KdfPbkdf2, Pbkdf2Iterations: 262144}
+)
+
+// encryptedJSON is the Web3 Secret Storage v3 envelope. Mnemonics and seeds
+// of hd wallets use the same envelope with the x- fields, which other v3
+// readers ignore.
+type encryptedJSON struct {
+	Address    string              `json:"address,omitempty"`
+	Crypto     keystore.CryptoJSON `json:"crypto"`
+	Id         string              `json:"id"`
+	Version    int                 `json:"version"`
+	Type       string              `json:"x-type,omitempty"`
+	BtcChainId int                 `json:"x-btcChainId,omitempty"`
+	EthChainId int                 `json:"x-ethChainId,omitempty"`
+}
+
+// EncryptEthWallet exports the key of w as a Web3 Secret Storage v3 JSON,
+// compatible with geth and other Ethereum wallets.
+func EncryptEthWallet(w *wallet.EthWallet, passphrase string, params Params) ([]byte, error) {
+	cryptoJSON, err := encrypt(crypto.FromECDSA(w.DeriveNativePrivateKey()), passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	address := w.DeriveNativeAddress()
+	return json.Marshal(&encryptedJSON{
+		Address: hex.EncodeToString(address[:]),
+		Crypto:  cryptoJSON,
+		Id:      uuid.NewString(),
+		Version: version,
+	})
+}
+
+// DecryptEthWallet unlocks a v3 key file into a wallet of chainId.
+func DecryptEthWallet(keyJSON []byte, passphrase string, chainId int) (*wallet.EthWallet, error) {
+	k, err := parse(keyJSON, "")
+	if err != nil {
+		return nil, err
+	}
+	key, err := keystore.DecryptDataV3(k.Crypto, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := wallet.NewEthWallet(hex.EncodeToString(key), chainId)
+	if err != nil {
+		return nil, err
+	}
+	if k.Address != "" && w.DeriveNativeAddress() != common.HexToAddress(k.Address) {
+		return nil, fmt.Errorf("key content mismatch: have address %x, want %s", w.DeriveNativeAddress(), k.Address)
+	}
+	return w, nil
+}
+
+// EncryptMnemonic encrypts a BIP-39 mnemonic together with the chains of the
+// hd wallet restored from it.
+func EncryptMnemonic(mnemonic string, btcChainId, ethChainId int, passphrase string, params Params) ([]byte, error) {
+	if _, err := wallet.NewSeedFromMnemonic(mnemonic, ""); err != nil {
+		return nil, err
+	}
+	return encryptHD(typeMnemonic, []byte(mnemonic), btcChainId, ethChainId, passphrase, params)
+}
+
+func DecryptMnemonic(keyJSON []byte, passphrase string) (string, error) {
+	k, err := parse(keyJSON, typeMnemonic)
+	if err != nil {
+		return "", err
+	}
+	mnemonic, err := keystore.DecryptDataV3(k.Crypto, passphrase)
+	if err != nil {
+		return "", err
+	}
+	return string(mnemonic), nil
+}
+
+// EncryptHDWallet encrypts the seed of h.
+func EncryptHDWallet(h *wallet.HDWallet, passphrase string, params Params) ([]byte, error) {
+	return encryptHD(typeSeed, h.Seed(), h.BtcChainId(), h.EthChainId(), passphrase, params)
+}
+
+// DecryptHDWallet unlocks an encrypted seed or mnemonic into a hd wallet,
+// mnemonicPassword is the BIP-39 passphrase of a mnemonic.
+func DecryptHDWallet(keyJSON []byte, passphrase, mnemonicPassword string) (*wallet.HDWallet, error) {
+	k, err := parse(keyJSON, "")
+	if err != nil {
+		return nil, err
+	}
+	secret, err := keystore.DecryptDataV3(k.Crypto, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k.Type {
+	case typeSeed:
+		return wallet.NewHDWalletFromSeed(secret, k.BtcChainId, k.EthChainId)
+	case typeMnemonic:
+		return wallet.NewHDWallet(string(secret), mnemonicPassword, k.BtcChainId, k.EthChainId)
+	}
+	return nil, ErrWrongType
+}
+
+func encryptHD(typ string, secret []byte, btcChainId, ethChainId int, passphrase string, params Params) ([]byte, error) {
+	cryptoJSON, err := encrypt(secret, passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&encryptedJSON{
+		Crypto:     cryptoJSON,
+		Id:         uuid.NewString(),
+		Version:    version,
+		Type:       typ,
+		BtcChainId: btcChainId,
+		EthChainId: ethChainId,
+	})
+}
+
+func parse(keyJSON []byte, typ string) (*encryptedJSON, error) {
+	k := new(encryptedJSON)
+	if err := json.Unmarshal(keyJSON, k); err != nil {
+		return nil, err
+	}
+	if k.Version != version {
+		return nil, ErrWrongFormat
+	}
+	if typ != "" && k.Type != typ {
+		return nil, ErrWrongType
+	}
+	return k, nil
+}
+
+func encrypt(data []byte, passphrase string, params Params) (keystore.CryptoJSON, error) {
+	switch params.Kdf {
+	case KdfScrypt:
+		return keystore.EncryptDataV3(data, []byte(passphrase), params.ScryptN, params.ScryptP)
+	case KdfPbkdf2:
+		return encryptPbkdf2(data, []byte(passphrase), params.Pbkdf2Iterations)
+	}
+	return keystore.CryptoJSON{}, fmt.Errorf("unsupported kdf: %s", params.Kdf)
+}
+
+// encryptPbkdf2 is keystore.EncryptDataV3 with pbkdf2, go-ethereum only
+// decrypts it.
+func encryptPbkdf2(data, auth []byte, iterations int) (keystore.CryptoJSON, error) {
+	if iterations <= 0 {
+		return keystore.CryptoJSON{}, errors.New("invalid pbkdf2 iterations")
+	}
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return keystore.CryptoJSON{}, err
+	}
+	derivedKey := pbkdf2.Key(auth, salt, iterations, 32, sha256.New)
+	encryptKey := derivedKey[:16]
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return keystore.CryptoJSON{}, err
+	}
+	block, err := aes.NewCipher(encryptKey)
+	if err != nil {
+		return keystore.CryptoJSON{}, err
+	}
+	cipherText := make([]byte, len(data))
+	cipher.NewCTR(block, iv).XORKeyStream(cipherText, data)
+	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
+
+	cryptoJSON := keystore.CryptoJSON{
+		Cipher:     "aes-128-ctr",
+		CipherText: hex.EncodeToString(cipherText),
+		KDF:        string(KdfPbkdf2),
+		KDFParams: map[string]interface{}{
+			"c":     iterations,
+			"dklen": 32,
+			"prf":   "hmac-sha256",
+			"salt":  hex.EncodeToString(salt),
+		},
+		MAC: hex.EncodeToString(mac),
+	}
+	cryptoJSON.CipherParams.IV = hex.EncodeToString(iv)
+	return cryptoJSON, nil
+}
@@ -0,0 +1,85 @@
+package keystore
+
+import (
+	"testing"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/wallet"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthWalletKeystore(t *testing.T) {
+	w, err := wallet.NewEthWallet("7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d", wallet.ChainMainNet)
+	require.NoError(t, err)
+
+	for _, params := range []Params{LightParams, {Kdf: KdfPbkdf2, Pbkdf2Iterations: 1024}} {
+		keyJSON, err := EncryptEthWallet(w, "testpassword", params)
+		require.NoError(t, err)
+
+		unlocked, err := DecryptEthWallet(keyJSON, "testpassword", wallet.ChainSepolia)
+		require.NoError(t, err)
+		require.Equal(t, w.DerivePrivateKey(), unlocked.DerivePrivateKey())
+		require.Equal(t, wallet.ChainSepolia, unlocked.ChainId())
+
+		_, err = DecryptEthWallet(keyJSON, "wrong", wallet.ChainMainNet)
+		require.ErrorIs(t, err, ErrDecrypt)
+
+		// readable by geth
+		key, err := keystore.DecryptKey(keyJSON, "testpassword")
+		require.NoError(t, err)
+		require.Equal(t, w.DeriveNativeAddress(), key.Address)
+	}
+
+	// Web3 Secret Storage test vector
+	vector := `{"crypto":{"cipher":"aes-128-ctr","cipherparams":{"iv":"6087dab2f9fdbbfaddc31a909735c1e6"},` +
+		`"ciphertext":"5318b4d5bcd28de64ee5559e671353e16f075ecae9f99c7a79a38af5f869aa46","kdf":"pbkdf2",` +
+		`"kdfparams":{"c":262144,"dklen":32,"prf":"hmac-sha256","salt":"ae3cd4e7013836a3df6bd7241b12db061dbe2c6785853cce422d148a624ce0bd"},` +
+		`"mac":"517ead924a9d0dc3124507e3393d175ce3ff7c1e96529c6c555ce9e51205e9b2"},` +
+		`"id":"3198bc9c-6672-5ab3-d995-4942343ae5b6","version":3}`
+	unlocked, err := DecryptEthWallet([]byte(vector), "testpassword", wallet.ChainMainNet)
+	require.NoError(t, err)
+	require.Equal(t, w.DerivePrivateKey(), unlocked.DerivePrivateKey())
+}
+
+func TestHDWalletKeystore(t *testing.T) {
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	h, err := wallet.NewHDWallet(mnemonic, "salt", wallet.BtcChainRegtest, wallet.ChainSepolia)
+	require.NoError(t, err)
+	w, err := h.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+
+	{ // seed
+		keyJSON, err := EncryptHDWallet(h, "passphrase", LightParams)
+		require.NoError(t, err)
+		unlocked, err := DecryptHDWallet(keyJSON, "passphrase", "")
+		require.NoError(t, err)
+		require.Equal(t, h.Seed(), unlocked.Seed())
+		require.Equal(t, wallet.BtcChainRegtest, unlocked.BtcChainId())
+		require.Equal(t, wallet.ChainSepolia, unlocked.EthChainId())
+
+		uw, err := unlocked.NewNativeSegWitWallet(0, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, w.DeriveAddress(), uw.DeriveAddress())
+
+		_, err = DecryptMnemonic(keyJSON, "passphrase")
+		require.ErrorIs(t, err, ErrWrongType)
+		_, err = DecryptHDWallet(keyJSON, "wrong", "")
+		require.ErrorIs(t, err, ErrDecrypt)
+	}
+
+	{ // mnemonic
+		keyJSON, err := EncryptMnemonic(mnemonic, wallet.BtcChainRegtest, wallet.ChainSepolia, "passphrase", Pbkdf2Params)
+		require.NoError(t, err)
+		restored, err := DecryptMnemonic(keyJSON, "passphrase")
+		require.NoError(t, err)
+		require.Equal(t, mnemonic, restored)
+
+		unlocked, err := DecryptHDWallet(keyJSON, "passphrase", "salt")
+		require.NoError(t, err)
+		require.Equal(t, h.Seed(), unlocked.Seed())
+
+		_, err = EncryptMnemonic("not a mnemonic", 0, 0, "passphrase", LightParams)
+		require.Error(t, err)
+	}
+}
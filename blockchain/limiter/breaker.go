@@ -0,0 +1,271 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	// StateClosed lets every call through and counts the failures.
+	StateClosed State = iota
+	// StateOpen fails every call fast with ErrBreakerOpen until OpenTimeout.
+	StateOpen
+	// StateHalfOpen lets HalfOpenCalls through to check for a recovery.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	DefaultMaxFailures   = 5
+	DefaultMinCalls      = 20
+	DefaultBreakerWindow = time.Minute
+	DefaultOpenTimeout   = 30 * time.Second
+)
+
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+type BreakerOptions struct {
+	// MaxFailures consecutive failures open the breaker, DefaultMaxFailures if
+	// 0.
+	MaxFailures int
+	// FailureRatio of the calls of Window opens the breaker once there are
+	// MinCalls, 0 disables it.
+	FailureRatio float64
+	MinCalls     int
+	Window       time.Duration
+	// OpenTimeout is how long the breaker stays open before it's half-open.
+	OpenTimeout time.Duration
+	// HalfOpenCalls successful calls close a half-open breaker, 1 if 0.
+	HalfOpenCalls int
+	// IsFailure reports whether the error of a call counts, by default every
+	// error but context.Canceled.
+	IsFailure func(err error) bool
+	// OnStateChange is called on every change of the state, without the lock
+	// of the breaker.
+	OnStateChange func(name string, from State, to State)
+}
+
+type BreakerStats struct {
+	Name  string
+	State State
+	// Since is the time of the last change of State.
+	Since time.Time
+	// Calls and Failures are of the current Window.
+	Calls               int
+	Failures            int
+	ConsecutiveFailures int
+	// Rejected counts the calls failed with ErrBreakerOpen, Opens how often
+	// the breaker opened.
+	Rejected uint64
+	Opens    uint64
+}
+
+// Breaker is a circuit breaker: it opens on too many failures, fails fast
+// while open, and after OpenTimeout lets a few calls through to close again
+// if they succeed or reopen if any fails.
+type Breaker struct {
+	name string
+	opts BreakerOptions
+	now  func() time.Time
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64 // of the state, the calls of a previous one don't count
+	since       time.Time
+	windowStart time.Time
+	calls       int
+	failures    int
+	consecutive int
+	halfOpen    int // calls let through while half-open
+	successes   int // of the half-open calls
+	rejected    uint64
+	opens       uint64
+}
+
+func NewBreaker(name string, opts BreakerOptions) *Breaker {
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = DefaultMaxFailures
+	}
+	if opts.MinCalls <= 0 {
+		opts.MinCalls = DefaultMinCalls
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultBreakerWindow
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = DefaultOpenTimeout
+	}
+	if opts.HalfOpenCalls <= 0 {
+		opts.HalfOpenCalls = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return err != nil && !errors.Is(err, context.Canceled)
+		}
+	}
+	now := time.Now()
+	return &Breaker{name: name, opts: opts, now: time.Now, since: now, windowStart: now}
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) State() State {
+	return b.Stats().State
+}
+
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	changed, from := b.expire(b.now())
+	stats := BreakerStats{Name: b.name, State: b.state, Since: b.since, Calls: b.calls, Failures: b.failures,
+		ConsecutiveFailures: b.consecutive, Rejected: b.rejected, Opens: b.opens}
+	b.mu.Unlock()
+	if changed {
+		b.notify(from, stats.State)
+	}
+	return stats
+}
+
+// expire moves an open breaker to half-open after OpenTimeout and starts a
+// new Window of a closed one.
+func (b *Breaker) expire(now time.Time) (changed bool, from State) {
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.since) >= b.opts.OpenTimeout {
+			return true, b.set(StateHalfOpen, now)
+		}
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.opts.Window {
+			b.windowStart, b.calls, b.failures = now, 0, 0
+		}
+	}
+	return false, b.state
+}
+
+// set changes the state and returns the previous one.
+func (b *Breaker) set(state State, now time.Time) State {
+	from := b.state
+	b.state, b.since = state, now
+	b.generation++
+	b.windowStart, b.calls, b.failures, b.consecutive = now, 0, 0, 0
+	b.halfOpen, b.successes = 0, 0
+	if state == StateOpen {
+		b.opens++
+	}
+	return from
+}
+
+func (b *Breaker) notify(from State, to State) {
+	if b.opts.OnStateChange != nil && from != to {
+		b.opts.OnStateChange(b.name, from, to)
+	}
+}
+
+// Allow admits a call, done must be called with its error once it's over. It
+// fails with ErrBreakerOpen while the breaker is open or the half-open calls
+// are taken.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+	changed, from := b.expire(b.now())
+	state, generation := b.state, b.generation
+	switch {
+	case state == StateOpen, state == StateHalfOpen && b.halfOpen >= b.opts.HalfOpenCalls:
+		b.rejected++
+		err = ErrBreakerOpen
+	case state == StateHalfOpen:
+		b.halfOpen++
+	}
+	b.mu.Unlock()
+	if changed {
+		b.notify(from, StateHalfOpen)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.done(generation, b.opts.IsFailure(err)) })
+	}, nil
+}
+
+func (b *Breaker) done(generation uint64, failure bool) {
+	b.mu.Lock()
+	now := b.now()
+	if changed, from := b.expire(now); generation != b.generation {
+		// the state changed while the call was running
+		b.mu.Unlock()
+		if changed {
+			b.notify(from, StateHalfOpen)
+		}
+		return
+	}
+	from, to := b.state, b.state
+	switch b.state {
+	case StateClosed:
+		b.calls++
+		if failure {
+			b.failures++
+			b.consecutive++
+		} else {
+			b.consecutive = 0
+		}
+		if b.consecutive >= b.opts.MaxFailures || b.opts.FailureRatio > 0 && b.calls >= b.opts.MinCalls &&
+			float64(b.failures)/float64(b.calls) >= b.opts.FailureRatio {
+			b.set(StateOpen, now)
+			to = StateOpen
+		}
+	case StateHalfOpen:
+		if failure {
+			b.set(StateOpen, now)
+			to = StateOpen
+		} else if b.successes++; b.successes >= b.opts.HalfOpenCalls {
+			b.set(StateClosed, now)
+			to = StateClosed
+		}
+	}
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+// reject counts a call as rejected if the breaker is open, without letting
+// it through otherwise.
+func (b *Breaker) reject() bool {
+	b.mu.Lock()
+	changed, from := b.expire(b.now())
+	open := b.state == StateOpen
+	if open {
+		b.rejected++
+	}
+	b.mu.Unlock()
+	if changed {
+		b.notify(from, StateHalfOpen)
+	}
+	return open
+}
+
+// Do calls fn if the breaker allows it.
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	done(err)
+	return err
+}
@@ -0,0 +1,187 @@
+package limiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/jsonrpc"
+)
+
+type GuardOptions struct {
+	// Transport sends the requests, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+	// Limit is the quota of all calls, Methods the quotas of json-rpc methods
+	// on top of it. Every call of a batch takes its share.
+	Limit   Limiter
+	Methods map[string]Limiter
+	// FailFast fails a request over its quota with ErrLimited instead of
+	// waiting. The calls of a batch taken before the refused one are lost.
+	FailFast bool
+	// Breaker fails the requests fast while the node is down, none if nil.
+	// Transport errors and 429 or 5xx responses are its failures, json-rpc
+	// errors are answers.
+	Breaker *Breaker
+}
+
+type MethodStats struct {
+	Calls   uint64
+	Limited uint64
+}
+
+type GuardStats struct {
+	// Requests counts the http requests, Limited the ones refused by a quota.
+	Requests uint64
+	Limited  uint64
+	Methods  map[string]MethodStats
+	// Breaker is nil without a breaker.
+	Breaker *BreakerStats
+}
+
+// Guard is the http.RoundTripper of a json-rpc client which keeps its calls
+// within the quotas of the provider and fails fast while the provider is down.
+type Guard struct {
+	opts      GuardOptions
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	requests uint64
+	limited  uint64
+	methods  map[string]*MethodStats
+}
+
+func NewGuard(opts GuardOptions) *Guard {
+	g := &Guard{opts: opts, transport: opts.Transport, methods: map[string]*MethodStats{}}
+	if g.transport == nil {
+		g.transport = http.DefaultTransport
+	}
+	return g
+}
+
+func (g *Guard) Stats() GuardStats {
+	g.mu.Lock()
+	stats := GuardStats{Requests: g.requests, Limited: g.limited, Methods: make(map[string]MethodStats, len(g.methods))}
+	for method, methodStats := range g.methods {
+		stats.Methods[method] = *methodStats
+	}
+	g.mu.Unlock()
+	if g.opts.Breaker != nil {
+		breakerStats := g.opts.Breaker.Stats()
+		stats.Breaker = &breakerStats
+	}
+	return stats
+}
+
+func (g *Guard) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	methods := rpcMethods(body)
+	g.mu.Lock()
+	g.requests++
+	for _, method := range methods {
+		g.method(method).Calls++
+	}
+	g.mu.Unlock()
+
+	// don't wait for a quota to be refused by an open breaker
+	if g.opts.Breaker != nil && g.opts.Breaker.reject() {
+		return nil, ErrBreakerOpen
+	}
+	for _, method := range methods {
+		if err := g.take(req, method); err != nil {
+			return nil, err
+		}
+	}
+	var done func(error)
+	if g.opts.Breaker != nil {
+		var err error
+		if done, err = g.opts.Breaker.Allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	resp, err := g.transport.RoundTrip(out)
+	if done != nil {
+		switch {
+		case err != nil:
+			done(err)
+		case resp.StatusCode == http.StatusTooManyRequests,
+			resp.StatusCode >= http.StatusInternalServerError && !jsonrpc.IsErrorResponse(resp):
+			done(fmt.Errorf("status %s", resp.Status))
+		default:
+			done(nil)
+		}
+	}
+	return resp, err
+}
+
+func (g *Guard) method(method string) *MethodStats {
+	stats, ok := g.methods[method]
+	if !ok {
+		stats = &MethodStats{}
+		g.methods[method] = stats
+	}
+	return stats
+}
+
+// take takes a call of method from the quotas.
+func (g *Guard) take(req *http.Request, method string) error {
+	for _, limiter := range []Limiter{g.opts.Limit, g.opts.Methods[method]} {
+		if limiter == nil {
+			continue
+		}
+		var err error
+		if g.opts.FailFast {
+			if !limiter.Allow() {
+				err = ErrLimited
+			}
+		} else {
+			err = limiter.Wait(req.Context())
+		}
+		if err != nil {
+			if errors.Is(err, ErrLimited) {
+				g.mu.Lock()
+				g.limited++
+				g.method(method).Limited++
+				g.mu.Unlock()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rpcMethods returns the methods of a json-rpc request or batch.
+func rpcMethods(body []byte) []string {
+	type call struct {
+		Method string `json:"method"`
+	}
+	body = bytes.TrimSpace(body)
+	var calls []call
+	if len(body) > 0 && body[0] == '[' {
+		json.Unmarshal(body, &calls)
+	} else {
+		var single call
+		json.Unmarshal(body, &single)
+		calls = append(calls, single)
+	}
+	methods := make([]string, len(calls))
+	for i, call := range calls {
+		methods[i] = call.Method
+	}
+	return methods
+}
@@ -0,0 +1,121 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuard(t *testing.T) {
+	var status atomic.Int64
+	status.Store(http.StatusOK)
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch code := int(status.Load()); code {
+		case http.StatusOK:
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+		case http.StatusInternalServerError:
+			// like bitcoind
+			w.WriteHeader(code)
+			fmt.Fprint(w, `{"result":null,"error":{"code":-8,"message":"invalid"},"id":1}`)
+		default:
+			w.WriteHeader(code)
+		}
+	}))
+	defer server.Close()
+
+	breaker := NewBreaker("node", BreakerOptions{MaxFailures: 2, OpenTimeout: time.Hour})
+	guard := NewGuard(GuardOptions{
+		Limit:    NewTokenBucket(1000, 1000),
+		Methods:  map[string]Limiter{"eth_getLogs": NewTokenBucket(1, 2)},
+		FailFast: true,
+		Breaker:  breaker,
+	})
+	client := &http.Client{Transport: guard}
+	post := func(body string) (int, error) {
+		resp, err := client.Post(server.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	call := func(method string) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q,"params":[]}`, method)
+	}
+
+	{ // quotas
+		for i := 0; i < 5; i++ {
+			_, err := post(call("eth_blockNumber"))
+			require.NoError(t, err)
+		}
+		_, err := post(call("eth_getLogs"))
+		require.NoError(t, err)
+		// a batch of 2 takes 2
+		_, err = post("[" + call("eth_getLogs") + "," + call("eth_getLogs") + "]")
+		require.ErrorIs(t, err, ErrLimited)
+
+		stats := guard.Stats()
+		require.Equal(t, uint64(7), stats.Requests)
+		require.Equal(t, uint64(1), stats.Limited)
+		require.Equal(t, MethodStats{Calls: 5}, stats.Methods["eth_blockNumber"])
+		require.Equal(t, MethodStats{Calls: 3, Limited: 1}, stats.Methods["eth_getLogs"])
+		require.Equal(t, int64(6), calls.Load())
+	}
+
+	{ // a json-rpc error is an answer
+		status.Store(http.StatusInternalServerError)
+		for i := 0; i < 3; i++ {
+			code, err := post(call("getblock"))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusInternalServerError, code)
+		}
+		require.Equal(t, StateClosed, breaker.State())
+	}
+
+	{ // the node is down
+		status.Store(http.StatusBadGateway)
+		for i := 0; i < 2; i++ {
+			code, err := post(call("eth_blockNumber"))
+			require.NoError(t, err)
+			require.Equal(t, http.StatusBadGateway, code)
+		}
+		before := calls.Load()
+		_, err := post(call("eth_blockNumber"))
+		require.ErrorIs(t, err, ErrBreakerOpen)
+		require.Equal(t, before, calls.Load())
+
+		stats := guard.Stats()
+		fmt.Println("stats:", stats.Methods, *stats.Breaker)
+		require.Equal(t, StateOpen, stats.Breaker.State)
+		require.Equal(t, uint64(1), stats.Breaker.Rejected)
+	}
+
+	{ // waiting for the quota
+		guard := NewGuard(GuardOptions{Limit: NewLeakyBucket(100, 10)})
+		client := &http.Client{Transport: guard}
+		status.Store(http.StatusOK)
+		start := time.Now()
+		for i := 0; i < 4; i++ {
+			resp, err := client.Post(server.URL, "application/json", strings.NewReader(call("eth_chainId")))
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		require.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(call("eth_chainId")))
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.ErrorIs(t, err, context.Canceled)
+	}
+}
@@ -0,0 +1,207 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLimited is the error of a request over its quota.
+var ErrLimited = errors.New("rate limited")
+
+// Limiter admits requests: Allow takes a request now or refuses it, Wait
+// blocks until it can be taken or fails with ErrLimited if it never can.
+type Limiter interface {
+	Allow() bool
+	Wait(ctx context.Context) error
+}
+
+// sleep waits for d or ctx, whichever is first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// SlidingWindow is the counter: at most limit requests within window, which
+// moves by window/slots. More slots count more precisely at the edges of the
+// window.
+type SlidingWindow struct {
+	limit int
+	slot  time.Duration
+	now   func() time.Time
+
+	mu     sync.Mutex
+	slots  []int64 // the slot number of each count
+	counts []int
+}
+
+func NewSlidingWindow(limit int, window time.Duration, slots int) *SlidingWindow {
+	if limit <= 0 || window <= 0 || slots <= 0 {
+		panic(fmt.Sprintf("invalid sliding window %d/%s/%d", limit, window, slots))
+	}
+	return &SlidingWindow{
+		limit:  limit,
+		slot:   window / time.Duration(slots),
+		now:    time.Now,
+		slots:  make([]int64, slots),
+		counts: make([]int, slots),
+	}
+}
+
+// take counts a request if the window has room, else it returns how long
+// until the oldest slot leaves the window.
+func (w *SlidingWindow) take() (bool, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	current := now.UnixNano() / int64(w.slot)
+	size := int64(len(w.slots))
+	index := current % size
+	if w.slots[index] != current {
+		w.slots[index], w.counts[index] = current, 0
+	}
+	total, oldest := 0, current
+	for i, slot := range w.slots {
+		if slot > current-size && w.counts[i] > 0 {
+			total += w.counts[i]
+			oldest = min(oldest, slot)
+		}
+	}
+	if total < w.limit {
+		w.counts[index]++
+		return true, 0
+	}
+	return false, time.Unix(0, (oldest+size)*int64(w.slot)).Sub(now)
+}
+
+func (w *SlidingWindow) Allow() bool {
+	ok, _ := w.take()
+	return ok
+}
+
+func (w *SlidingWindow) Wait(ctx context.Context) error {
+	for {
+		ok, delay := w.take()
+		if ok {
+			return nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// LeakyBucket lets requests out at a constant rate per second. The requests
+// waiting for their turn fill the bucket, one over capacity overflows with
+// ErrLimited.
+type LeakyBucket struct {
+	interval time.Duration
+	capacity int
+	now      func() time.Time
+
+	mu   sync.Mutex
+	next time.Time // when the next request leaks out
+}
+
+func NewLeakyBucket(rate float64, capacity int) *LeakyBucket {
+	if rate <= 0 || capacity <= 0 {
+		panic(fmt.Sprintf("invalid leaky bucket %v/%d", rate, capacity))
+	}
+	return &LeakyBucket{interval: time.Duration(float64(time.Second) / rate), capacity: capacity, now: time.Now}
+}
+
+// Allow takes a request only if it can leak out right away.
+func (b *LeakyBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if b.next.After(now) {
+		return false
+	}
+	b.next = now.Add(b.interval)
+	return true
+}
+
+// Wait queues the request for its turn. A canceled request keeps its turn,
+// the ones behind it aren't moved up.
+func (b *LeakyBucket) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := b.now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	if queued := int(b.next.Sub(now) / b.interval); queued >= b.capacity {
+		b.mu.Unlock()
+		return ErrLimited
+	}
+	at := b.next
+	b.next = b.next.Add(b.interval)
+	b.mu.Unlock()
+	return sleep(ctx, at.Sub(now))
+}
+
+// TokenBucket adds rate tokens per second up to burst, a request takes one.
+// Unlike LeakyBucket it lets a burst through at once.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 || burst <= 0 {
+		panic(fmt.Sprintf("invalid token bucket %v/%d", rate, burst))
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), now: time.Now}
+}
+
+func (b *TokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+}
+
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(b.now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait takes a token ahead, the balance goes negative and later requests wait
+// longer. A canceled request gives its token back.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	b.refill(b.now())
+	b.tokens--
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if err := sleep(ctx, delay); err != nil {
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
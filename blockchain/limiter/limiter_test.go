@@ -0,0 +1,168 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestLimiters(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	allowed := func(limiter Limiter) int {
+		n := 0
+		for limiter.Allow() {
+			n++
+		}
+		return n
+	}
+
+	{ // 10 per second in slots of 100ms
+		window := NewSlidingWindow(10, time.Second, 10)
+		window.now = clock.Now
+		for i := 0; i < 6; i++ {
+			require.True(t, window.Allow())
+		}
+		clock.Add(500 * time.Millisecond)
+		require.Equal(t, 4, allowed(window))
+		// the first 6 leave the window
+		clock.Add(500 * time.Millisecond)
+		require.Equal(t, 6, allowed(window))
+		ok, delay := window.take()
+		require.False(t, ok)
+		require.Equal(t, 500*time.Millisecond, delay)
+	}
+
+	{ // 10 per second, no burst
+		bucket := NewLeakyBucket(10, 3)
+		bucket.now = clock.Now
+		require.Equal(t, 1, allowed(bucket))
+		clock.Add(100 * time.Millisecond)
+		require.Equal(t, 1, allowed(bucket))
+
+		// the bucket holds 3, one leaks now and 2 wait
+		clock.Add(100 * time.Millisecond)
+		ctx := context.Background()
+		require.NoError(t, bucket.Wait(ctx))
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		require.ErrorIs(t, bucket.Wait(canceled), context.Canceled)
+		require.ErrorIs(t, bucket.Wait(canceled), context.Canceled)
+		require.ErrorIs(t, bucket.Wait(ctx), ErrLimited)
+		require.False(t, bucket.Allow())
+	}
+
+	{ // 10 per second, bursts of 5
+		bucket := NewTokenBucket(10, 5)
+		bucket.now = clock.Now
+		require.Equal(t, 5, allowed(bucket))
+		clock.Add(250 * time.Millisecond)
+		require.Equal(t, 2, allowed(bucket))
+		clock.Add(10 * time.Second)
+		require.Equal(t, 5, allowed(bucket))
+
+		// a canceled wait gives its token back
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.ErrorIs(t, bucket.Wait(canceled), context.Canceled)
+		clock.Add(100 * time.Millisecond)
+		require.True(t, bucket.Allow())
+	}
+
+	{ // real time
+		bucket := NewTokenBucket(100, 1)
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			require.NoError(t, bucket.Wait(context.Background()))
+		}
+		elapsed := time.Since(start)
+		fmt.Println("token bucket, 6 waits:", elapsed)
+		require.GreaterOrEqual(t, elapsed, 50*time.Millisecond)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var changes []string
+	breaker := NewBreaker("node", BreakerOptions{
+		MaxFailures:   3,
+		FailureRatio:  0.5,
+		MinCalls:      4,
+		OpenTimeout:   10 * time.Second,
+		HalfOpenCalls: 2,
+		OnStateChange: func(name string, from State, to State) {
+			changes = append(changes, fmt.Sprintf("%s %s->%s", name, from, to))
+		},
+	})
+	breaker.now = clock.Now
+	down := errors.New("down")
+	fail := func() error { return down }
+	succeed := func() error { return nil }
+
+	// consecutive failures
+	require.NoError(t, breaker.Do(succeed))
+	require.ErrorIs(t, breaker.Do(fail), down)
+	require.ErrorIs(t, breaker.Do(fail), down)
+	require.Equal(t, StateClosed, breaker.State())
+	require.ErrorIs(t, breaker.Do(fail), down)
+	require.Equal(t, StateOpen, breaker.State())
+	require.ErrorIs(t, breaker.Do(succeed), ErrBreakerOpen)
+
+	// half-open, a failure reopens
+	clock.Add(10 * time.Second)
+	require.Equal(t, StateHalfOpen, breaker.State())
+	require.ErrorIs(t, breaker.Do(fail), down)
+	require.Equal(t, StateOpen, breaker.State())
+
+	// half-open, 2 calls at most and both succeed
+	clock.Add(10 * time.Second)
+	done1, err := breaker.Allow()
+	require.NoError(t, err)
+	done2, err := breaker.Allow()
+	require.NoError(t, err)
+	_, err = breaker.Allow()
+	require.ErrorIs(t, err, ErrBreakerOpen)
+	done1(nil)
+	done1(down) // once only
+	require.Equal(t, StateHalfOpen, breaker.State())
+	done2(context.Canceled)
+	require.Equal(t, StateClosed, breaker.State())
+
+	// failure ratio, with a call which outlives the state
+	stale, err := breaker.Allow()
+	require.NoError(t, err)
+	for _, fn := range []func() error{fail, succeed, fail, succeed} {
+		breaker.Do(fn)
+	}
+	require.Equal(t, StateOpen, breaker.State())
+	clock.Add(10 * time.Second)
+	require.NoError(t, breaker.Do(succeed))
+	require.NoError(t, breaker.Do(succeed))
+	require.Equal(t, StateClosed, breaker.State())
+	stale(down)
+	require.Zero(t, breaker.Stats().Failures)
+
+	stats := breaker.Stats()
+	fmt.Println("stats:", stats)
+	require.Equal(t, uint64(3), stats.Opens)
+	require.Equal(t, uint64(2), stats.Rejected)
+	require.Equal(t, []string{
+		"node closed->open", "node open->half-open", "node half-open->open", "node open->half-open",
+		"node half-open->closed", "node closed->open", "node open->half-open", "node half-open->closed",
+	}, changes)
+}
@@ -0,0 +1,448 @@
+package rpcpool
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SeanHuangAtsz/backend-learn/blockchain/jsonrpc"
+)
+
+type Policy int
+
+const (
+	PolicyRoundRobin Policy = iota
+	PolicyLeastLatency
+	// PolicyWeighted spreads the calls by the Weight of the endpoints with
+	// smooth weighted round-robin.
+	PolicyWeighted
+)
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyRoundRobin:
+		return "round-robin"
+	case PolicyLeastLatency:
+		return "least-latency"
+	case PolicyWeighted:
+		return "weighted"
+	}
+	return "unknown"
+}
+
+const (
+	DefaultHealthInterval = 15 * time.Second
+	DefaultProbeTimeout   = 5 * time.Second
+	DefaultMaxBlockLag    = 2
+)
+
+var ErrNoEndpoints = errors.New("no endpoints")
+
+// Probe reads the chain height of an endpoint with a json-rpc method without
+// params, e.g. eth_blockNumber or getblockcount.
+type Probe struct {
+	Method      string
+	ParseHeight func(result json.RawMessage) (uint64, error)
+}
+
+type EndpointConfig struct {
+	// URL is the http(s) url of the node, user info is sent as basic auth.
+	URL string
+	// User and Pass are sent as basic auth if URL has no user info, e.g. the
+	// rpcuser and rpcpassword of bitcoind. The probes use them too.
+	User string
+	Pass string
+	// Weight is of PolicyWeighted, 1 if 0.
+	Weight  int
+	Headers map[string]string
+}
+
+type Options struct {
+	Policy Policy
+	Probe  Probe
+	// HealthInterval is the interval of the health checks of Run.
+	HealthInterval time.Duration
+	ProbeTimeout   time.Duration
+	// MaxBlockLag is how many blocks an endpoint may be behind the highest
+	// one before it's ejected.
+	MaxBlockLag uint64
+	// Transport sends the requests, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+}
+
+type EndpointStatus struct {
+	URL     string
+	Healthy bool
+	Height  uint64
+	// Latency is the moving average of the response times.
+	Latency   time.Duration
+	LastError error
+}
+
+type endpoint struct {
+	config  EndpointConfig
+	url     *url.URL
+	user    *url.Userinfo
+	healthy bool
+	height  uint64
+	latency time.Duration
+	lastErr error
+	current int // of smooth weighted round-robin
+}
+
+// Pool routes json-rpc requests to several endpoints of a chain. Run checks
+// the endpoints every HealthInterval: an endpoint which fails its probe or
+// lags MaxBlockLag blocks behind the highest one is ejected until it
+// recovers. A request goes to the endpoint picked by the Policy and fails
+// over to the others on transport errors and 429 or 5xx responses, so a
+// request may reach several nodes. Pool is the http.RoundTripper of
+// rpc.Client, for clients which can't take one Serve forwards a local
+// listener.
+type Pool struct {
+	opts      Options
+	transport http.RoundTripper
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+
+	serveOnce sync.Once
+	listener  net.Listener
+	serveURL  *url.URL
+	serveErr  error
+}
+
+func New(endpoints []EndpointConfig, opts Options) (*Pool, error) {
+	if len(endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	if opts.Probe.Method == "" || opts.Probe.ParseHeight == nil {
+		return nil, errors.New("probe is required")
+	}
+	p := &Pool{opts: opts, transport: opts.Transport}
+	if p.transport == nil {
+		p.transport = http.DefaultTransport
+	}
+	if p.opts.HealthInterval <= 0 {
+		p.opts.HealthInterval = DefaultHealthInterval
+	}
+	if p.opts.ProbeTimeout <= 0 {
+		p.opts.ProbeTimeout = DefaultProbeTimeout
+	}
+	if p.opts.MaxBlockLag == 0 {
+		p.opts.MaxBlockLag = DefaultMaxBlockLag
+	}
+	for i, config := range endpoints {
+		u, err := url.Parse(config.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("endpoint %d is not http(s)", i)
+		}
+		if config.Weight < 0 {
+			return nil, fmt.Errorf("endpoint %d has a negative weight", i)
+		}
+		if config.Weight == 0 {
+			config.Weight = 1
+		}
+		e := &endpoint{config: config, url: u, user: u.User, healthy: true}
+		if e.user == nil && config.User != "" {
+			e.user = url.UserPassword(config.User, config.Pass)
+		}
+		u.User = nil
+		p.endpoints = append(p.endpoints, e)
+	}
+	return p, nil
+}
+
+// Status returns the endpoints in the order of New.
+func (p *Pool) Status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		status = append(status, EndpointStatus{URL: e.url.Redacted(), Healthy: e.healthy, Height: e.height,
+			Latency: e.latency, LastError: e.lastErr})
+	}
+	return status
+}
+
+// Run checks the health every HealthInterval until ctx is done.
+func (p *Pool) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		p.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHealth probes every endpoint once.
+func (p *Pool) CheckHealth(ctx context.Context) {
+	type probeResult struct {
+		height  uint64
+		latency time.Duration
+		err     error
+	}
+	results := make([]probeResult, len(p.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range p.endpoints {
+		wg.Add(1)
+		go func(i int, e *endpoint) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, p.opts.ProbeTimeout)
+			defer cancel()
+			start := time.Now()
+			height, err := p.probe(probeCtx, e)
+			results[i] = probeResult{height: height, latency: time.Since(start), err: err}
+		}(i, e)
+	}
+	wg.Wait()
+
+	var tip uint64
+	for _, result := range results {
+		if result.err == nil {
+			tip = max(tip, result.height)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, e := range p.endpoints {
+		result := results[i]
+		e.lastErr = result.err
+		if result.err != nil {
+			e.healthy = false
+			continue
+		}
+		e.height = result.height
+		e.observe(result.latency)
+		e.healthy = tip-result.height <= p.opts.MaxBlockLag
+		if !e.healthy {
+			e.lastErr = fmt.Errorf("%d blocks behind", tip-result.height)
+		}
+	}
+}
+
+func (p *Pool) probe(ctx context.Context, e *endpoint) (uint64, error) {
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": p.opts.Probe.Method, "params": []interface{}{}})
+	header := http.Header{"Content-Type": {"application/json"}}
+	resp, err := p.send(ctx, e, http.MethodPost, header, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("probe status %s", resp.Status)
+	}
+	var msg struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return 0, err
+	}
+	if msg.Error != nil {
+		return 0, errors.New(msg.Error.Message)
+	}
+	return p.opts.Probe.ParseHeight(msg.Result)
+}
+
+func (e *endpoint) observe(latency time.Duration) {
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		// moving average, 30% of the new sample
+		e.latency = (e.latency*7 + latency*3) / 10
+	}
+}
+
+// candidates returns the healthy endpoints, the one of the policy first and
+// then the others by latency. If none is healthy it's all of them.
+func (p *Pool) candidates() []*endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var healthy []*endpoint
+	for _, e := range p.endpoints {
+		if e.healthy {
+			healthy = append(healthy, e)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = append(healthy, p.endpoints...)
+	}
+
+	var first *endpoint
+	switch p.opts.Policy {
+	case PolicyLeastLatency:
+		for _, e := range healthy {
+			if first == nil || e.latency < first.latency {
+				first = e
+			}
+		}
+	case PolicyWeighted:
+		total := 0
+		for _, e := range healthy {
+			e.current += e.config.Weight
+			total += e.config.Weight
+			if first == nil || e.current > first.current {
+				first = e
+			}
+		}
+		first.current -= total
+	default:
+		first = healthy[p.next%len(healthy)]
+		p.next++
+	}
+
+	candidates := []*endpoint{first}
+	others := make([]*endpoint, 0, len(healthy)-1)
+	for _, e := range healthy {
+		if e != first {
+			others = append(others, e)
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool { return others[i].latency < others[j].latency })
+	return append(candidates, others...)
+}
+
+// RoundTrip sends req to the endpoints until one answers, the url of req is
+// ignored.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.forward(req.Context(), req.Method, req.Header, body)
+}
+
+func (p *Pool) forward(ctx context.Context, method string, header http.Header, body []byte) (*http.Response, error) {
+	var lastErr error
+	for _, e := range p.candidates() {
+		start := time.Now()
+		resp, err := p.send(ctx, e, method, header, body)
+		if err == nil && (resp.StatusCode < http.StatusInternalServerError || jsonrpc.IsErrorResponse(resp)) &&
+			resp.StatusCode != http.StatusTooManyRequests {
+			p.mu.Lock()
+			e.observe(time.Since(start))
+			p.mu.Unlock()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			if err == nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: status %s", e.url.Redacted(), resp.Status)
+			resp.Body.Close()
+		}
+		// out of the rotation until the next health check
+		p.mu.Lock()
+		e.healthy, e.lastErr = false, err
+		p.mu.Unlock()
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (p *Pool) send(ctx context.Context, e *endpoint, method string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	for key, value := range e.config.Headers {
+		req.Header.Set(key, value)
+	}
+	if e.user != nil {
+		pass, _ := e.user.Password()
+		req.SetBasicAuth(e.user.Username(), pass)
+	}
+	return p.transport.RoundTrip(req)
+}
+
+// Serve forwards the requests to a local listener to the pool and returns its
+// url, it's started once. The listener takes the random credentials in the
+// user info of the url, which replace the ones of the requests: the
+// credentials of the endpoints are added when they're forwarded.
+func (p *Pool) Serve() (*url.URL, error) {
+	p.serveOnce.Do(func() {
+		secret := make([]byte, 16)
+		if _, p.serveErr = rand.Read(secret); p.serveErr != nil {
+			return
+		}
+		p.listener, p.serveErr = net.Listen("tcp", "127.0.0.1:0")
+		if p.serveErr != nil {
+			return
+		}
+		p.serveURL = &url.URL{Scheme: "http", Host: p.listener.Addr().String(),
+			User: url.UserPassword("rpcpool", hex.EncodeToString(secret))}
+		go http.Serve(p.listener, http.HandlerFunc(p.serveHTTP))
+	})
+	if p.serveErr != nil {
+		return nil, p.serveErr
+	}
+	u := *p.serveURL
+	return &u, nil
+}
+
+func (p *Pool) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, _ := r.BasicAuth()
+	wantPass, _ := p.serveURL.User.Password()
+	if subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(p.serveURL.User.Username()+":"+wantPass)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	r.Header.Del("Authorization")
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := p.forward(r.Context(), r.Method, r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// Close stops the listener of Serve.
+func (p *Pool) Close() error {
+	if p.listener != nil {
+		return p.listener.Close()
+	}
+	return nil
+}
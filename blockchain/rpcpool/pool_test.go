@@ -0,0 +1,244 @@
+package rpcpool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testProbe = Probe{
+	Method: "height",
+	ParseHeight: func(result json.RawMessage) (uint64, error) {
+		var height uint64
+		err := json.Unmarshal(result, &height)
+		return height, err
+	},
+}
+
+// fakeNode answers height with its height and every other method with its
+// name. status other than 200 fails every request.
+type fakeNode struct {
+	*httptest.Server
+	name     string
+	height   atomic.Uint64
+	status   atomic.Int64
+	delay    time.Duration
+	requests atomic.Int64
+	auth     atomic.Value
+}
+
+func newFakeNode(t *testing.T, name string, height uint64) *fakeNode {
+	n := &fakeNode{name: name}
+	n.height.Store(height)
+	n.status.Store(http.StatusOK)
+	n.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(n.delay)
+		user, pass, _ := r.BasicAuth()
+		n.auth.Store(user + ":" + pass)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if status := int(n.status.Load()); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		if req.Method == "height" {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%d}`, req.ID, n.height.Load())
+			return
+		}
+		n.requests.Add(1)
+		if req.Method == "invalid" {
+			// like bitcoind
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"result":null,"error":{"code":-5,"message":"invalid"},"id":%s}`, req.ID)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%q}`, req.ID, n.name)
+	}))
+	t.Cleanup(n.Close)
+	return n
+}
+
+func call(client *http.Client, url, method string) (string, int, error) {
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q,"params":[]}`, method)
+	resp, err := client.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, err
+	}
+	var msg struct {
+		Result string `json:"result"`
+	}
+	json.Unmarshal(data, &msg)
+	return msg.Result, resp.StatusCode, nil
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	a, b, c := newFakeNode(t, "a", 100), newFakeNode(t, "b", 100), newFakeNode(t, "c", 99)
+
+	_, err := New(nil, Options{Probe: testProbe})
+	require.ErrorIs(t, err, ErrNoEndpoints)
+	_, err = New([]EndpointConfig{{URL: "ws://localhost"}}, Options{Probe: testProbe})
+	require.Error(t, err)
+
+	pool, err := New([]EndpointConfig{{URL: a.URL}, {URL: b.URL}, {URL: c.URL}}, Options{Probe: testProbe})
+	require.NoError(t, err)
+	client := &http.Client{Transport: pool}
+
+	{ // round-robin over the healthy endpoints
+		pool.CheckHealth(ctx)
+		var names []string
+		for i := 0; i < 3; i++ {
+			name, _, err := call(client, "http://pool", "name")
+			require.NoError(t, err)
+			names = append(names, name)
+		}
+		require.Equal(t, []string{"a", "b", "c"}, names)
+
+		// c falls behind
+		a.height.Store(110)
+		pool.CheckHealth(ctx)
+		status := pool.Status()
+		require.True(t, status[0].Healthy)
+		require.False(t, status[2].Healthy)
+		require.Equal(t, uint64(99), status[2].Height)
+		require.Error(t, status[2].LastError)
+		fmt.Println("status:", status)
+		for i := 0; i < 4; i++ {
+			name, _, err := call(client, "http://pool", "name")
+			require.NoError(t, err)
+			require.NotEqual(t, "c", name)
+		}
+	}
+
+	{ // failover
+		b.height.Store(110)
+		c.height.Store(110)
+		pool.CheckHealth(ctx)
+		a.status.Store(http.StatusServiceUnavailable)
+		for i := 0; i < 3; i++ {
+			name, _, err := call(client, "http://pool", "name")
+			require.NoError(t, err)
+			require.NotEqual(t, "a", name)
+		}
+		require.False(t, pool.Status()[0].Healthy)
+
+		// a json-rpc error is an answer
+		before := b.requests.Load() + c.requests.Load()
+		_, status, err := call(client, "http://pool", "invalid")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusInternalServerError, status)
+		require.Equal(t, before+1, b.requests.Load()+c.requests.Load())
+
+		// a is back on the next health check
+		a.status.Store(http.StatusOK)
+		pool.CheckHealth(ctx)
+		require.True(t, pool.Status()[0].Healthy)
+
+		// everything is down
+		b.Close()
+		c.Close()
+		a.status.Store(http.StatusBadGateway)
+		_, _, err = call(client, "http://pool", "name")
+		require.Error(t, err)
+	}
+}
+
+func TestPoolPolicies(t *testing.T) {
+	ctx := context.Background()
+	a, b := newFakeNode(t, "a", 1), newFakeNode(t, "b", 1)
+
+	{ // weighted
+		pool, err := New([]EndpointConfig{{URL: a.URL, Weight: 3}, {URL: b.URL}}, Options{Probe: testProbe, Policy: PolicyWeighted})
+		require.NoError(t, err)
+		counts := map[string]int{}
+		for i := 0; i < 8; i++ {
+			name, _, err := call(&http.Client{Transport: pool}, "http://pool", "name")
+			require.NoError(t, err)
+			counts[name]++
+		}
+		require.Equal(t, map[string]int{"a": 6, "b": 2}, counts)
+	}
+
+	{ // least latency
+		b.delay = 20 * time.Millisecond
+		pool, err := New([]EndpointConfig{{URL: b.URL}, {URL: a.URL}}, Options{Probe: testProbe, Policy: PolicyLeastLatency})
+		require.NoError(t, err)
+		pool.CheckHealth(ctx)
+		status := pool.Status()
+		require.Greater(t, status[0].Latency, status[1].Latency)
+		for i := 0; i < 3; i++ {
+			name, _, err := call(&http.Client{Transport: pool}, "http://pool", "name")
+			require.NoError(t, err)
+			require.Equal(t, "a", name)
+		}
+	}
+
+	{ // the local listener, with the credentials of the endpoint
+		b.delay = 0
+		pool, err := New([]EndpointConfig{{URL: strings.Replace(a.URL, "http://", "http://user:pass@", 1)}},
+			Options{Probe: testProbe})
+		require.NoError(t, err)
+		defer pool.Close()
+		u, err := pool.Serve()
+		require.NoError(t, err)
+		again, err := pool.Serve()
+		require.NoError(t, err)
+		require.Equal(t, u.String(), again.String())
+		pass, ok := u.User.Password()
+		require.True(t, ok)
+
+		post := func(user, pass string) *http.Response {
+			req, err := http.NewRequest(http.MethodPost, "http://"+u.Host,
+				bytes.NewReader([]byte(`{"jsonrpc":"1.0","id":1,"method":"name","params":[]}`)))
+			require.NoError(t, err)
+			if user != "" {
+				req.SetBasicAuth(user, pass)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			return resp
+		}
+		// other local processes don't know the credentials
+		before := a.requests.Load()
+		for _, creds := range [][2]string{{"", ""}, {"user", "pass"}, {u.User.Username(), "wrong"}} {
+			resp := post(creds[0], creds[1])
+			resp.Body.Close()
+			require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+		require.Equal(t, before, a.requests.Load())
+
+		resp := post(u.User.Username(), pass)
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"a"`)
+		require.Equal(t, "user:pass", a.auth.Load())
+		require.NotContains(t, pool.Status()[0].URL, "pass")
+	}
+
+	{ // credentials out of the url, for the probes too
+		pool, err := New([]EndpointConfig{{URL: b.URL, User: "rpcuser", Pass: "rpcpass"}}, Options{Probe: testProbe})
+		require.NoError(t, err)
+		pool.CheckHealth(ctx)
+		require.Equal(t, "rpcuser:rpcpass", b.auth.Load())
+		require.True(t, pool.Status()[0].Healthy)
+	}
+}
@@ -1,18 +1,26 @@
 package wallet
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"log"
 
 	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/ethereum/go-ethereum/accounts"
 )
 
+const btcMessageMagic = "Bitcoin Signed Message:\n"
+
 var ErrAddressNotMatch = errors.New("address not match")
 
 type BtcWallet struct {
@@ -101,82 +109,213 @@ func (w *BtcWallet) DerivePrivateKey() string {
 }
 
 func (w *BtcWallet) DeriveNativeAddress() btcutil.Address {
-	switch w.segWitType {
+	return deriveBtcAddress(w.publicKey, w.segWitType, w.chainParams)
+}
+
+func (w *BtcWallet) DeriveNativePrivateKey() *btcec.PrivateKey {
+	return w.privateKey
+}
+
+func DerivePrivateKeyByPath(masterKey *hdkeychain.ExtendedKey, path string, fixIssue172 bool) (*btcec.PrivateKey, error) {
+	key, err := DeriveExtendedKeyByPath(masterKey, path, fixIssue172)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := key.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+	return privateKey, nil
+}
+
+func DeriveExtendedKeyByPath(masterKey *hdkeychain.ExtendedKey, path string, fixIssue172 bool) (*hdkeychain.ExtendedKey, error) {
+	dpath, err := accounts.ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := masterKey
+	for _, n := range dpath {
+		if fixIssue172 && key.IsAffectedByIssue172() {
+			key, err = key.Derive(n)
+		} else {
+			key, err = key.DeriveNonStandard(n)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+func deriveBtcAddress(publicKey *btcec.PublicKey, segWitType SegWitType, chainParams *chaincfg.Params) btcutil.Address {
+	switch segWitType {
 	case SegWitNone:
-		pk := w.publicKey.SerializeCompressed()
+		pk := publicKey.SerializeCompressed()
 		keyHash := btcutil.Hash160(pk)
-		p2pkhAddr, err := btcutil.NewAddressPubKeyHash(keyHash, w.chainParams)
+		p2pkhAddr, err := btcutil.NewAddressPubKeyHash(keyHash, chainParams)
 		if err != nil {
 			log.Println("DeriveAddress error:", err)
 			return nil
 		}
 		return p2pkhAddr
 	case SegWitScript:
-		pk := w.publicKey.SerializeCompressed()
+		pk := publicKey.SerializeCompressed()
 		keyHash := btcutil.Hash160(pk)
 		scriptSig, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(keyHash).Script()
 		if err != nil {
 			log.Println("DeriveAddress error:", err)
 			return nil
 		}
-		addr, err := btcutil.NewAddressScriptHash(scriptSig, w.chainParams)
+		addr, err := btcutil.NewAddressScriptHash(scriptSig, chainParams)
 		if err != nil {
 			log.Println("DeriveAddress error:", err)
 			return nil
 		}
 		return addr
 	case SegWitNative:
-		pk := w.publicKey.SerializeCompressed()
+		pk := publicKey.SerializeCompressed()
 		keyHash := btcutil.Hash160(pk)
-		p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(keyHash, w.chainParams)
+		p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(keyHash, chainParams)
 		if err != nil {
 			log.Println("DeriveAddress error:", err)
 			return nil
 		}
 		return p2wpkh
+	case SegWitTaproot:
+		// BIP-86, key path only, the output key commits to no script
+		taprootKey := txscript.ComputeTaprootKeyNoScript(publicKey)
+		p2tr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(taprootKey), chainParams)
+		if err != nil {
+			log.Println("DeriveAddress error:", err)
+			return nil
+		}
+		return p2tr
 	}
 	return nil
 }
 
-func (w *BtcWallet) DeriveNativePrivateKey() *btcec.PrivateKey {
-	return w.privateKey
+// txauthor.SecretsSource
+func (w *BtcWallet) GetKey(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+	if w.DeriveAddress() == addr.EncodeAddress() {
+		return w.privateKey, true, nil
+	}
+	return nil, false, ErrAddressNotMatch
 }
 
-func DerivePrivateKeyByPath(masterKey *hdkeychain.ExtendedKey, path string, fixIssue172 bool) (*btcec.PrivateKey, error) {
-	dpath, err := accounts.ParseDerivationPath(path)
+func (w *BtcWallet) GetScript(addr btcutil.Address) ([]byte, error) {
+	return nil, errors.New("GetScript not supported")
+}
+
+// SignDigest returns the DER encoded ECDSA signature of digest.
+func (w *BtcWallet) SignDigest(digest []byte) ([]byte, error) {
+	if len(digest) != 32 {
+		return nil, ErrInvalidDigest
+	}
+	return ecdsa.Sign(w.privateKey, digest).Serialize(), nil
+}
+
+func (w *BtcWallet) VerifyDigest(digest, signature []byte) (bool, error) {
+	if len(digest) != 32 {
+		return false, ErrInvalidDigest
+	}
+	sig, err := ecdsa.ParseDERSignature(signature)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	return sig.Verify(digest, w.publicKey), nil
+}
 
-	key := masterKey
-	for _, n := range dpath {
-		if fixIssue172 && key.IsAffectedByIssue172() {
-			key, err = key.Derive(n)
-		} else {
-			key, err = key.DeriveNonStandard(n)
-		}
-		if err != nil {
-			return nil, err
-		}
+// SignMessage signs message in the Bitcoin Core signmessage format: a base64
+// encoded 65-byte compact signature. For segwit wallets the header byte follows
+// BIP-137 so that the signature can be matched to the right address type.
+// Taproot addresses need BIP-322 and are not supported.
+func (w *BtcWallet) SignMessage(message string) (string, error) {
+	if w.segWitType == SegWitTaproot {
+		return "", errors.New("message signing not supported for taproot")
 	}
 
-	privateKey, err := key.ECPrivKey()
+	sig, err := ecdsa.SignCompact(w.privateKey, BtcMessageHash(message), true)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	return privateKey, nil
-}
 
-// txauthor.SecretsSource
-func (w *BtcWallet) GetKey(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
-	if w.DeriveAddress() == addr.EncodeAddress() {
-		return w.privateKey, true, nil
+	switch w.segWitType {
+	case SegWitScript:
+		sig[0] += 4
+	case SegWitNative:
+		sig[0] += 8
 	}
-	return nil, false, ErrAddressNotMatch
+	return base64.StdEncoding.EncodeToString(sig), nil
 }
 
-func (w *BtcWallet) GetScript(addr btcutil.Address) ([]byte, error) {
-	return nil, errors.New("GetScript not supported")
+func (w *BtcWallet) VerifyMessage(message, signature string) (bool, error) {
+	return VerifyBtcMessage(w.DeriveAddress(), message, signature, w.chainParams)
+}
+
+// BtcMessageHash returns the double sha256 digest signed by signmessage.
+func BtcMessageHash(message string) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarString(&buf, 0, btcMessageMagic)
+	_ = wire.WriteVarString(&buf, 0, message)
+	return chainhash.DoubleHashB(buf.Bytes())
+}
+
+// VerifyBtcMessage verifies a signmessage signature against a P2PKH,
+// P2SH-P2WPKH or P2WPKH address.
+func VerifyBtcMessage(address, message, signature string, chainParams *chaincfg.Params) (bool, error) {
+	addr, err := btcutil.DecodeAddress(address, chainParams)
+	if err != nil {
+		return false, err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+	if len(sig) != 65 || sig[0] < 27 || sig[0] > 42 {
+		return false, ErrInvalidSignature
+	}
+
+	// RecoverCompact only understands the legacy header range, so strip the
+	// BIP-137 segwit offsets before recovering the public key.
+	header := sig[0]
+	if header >= 35 {
+		sig[0] = 27 + (header-27)%4 + 4
+	}
+	publicKey, compressed, err := ecdsa.RecoverCompact(sig, BtcMessageHash(message))
+	if err != nil {
+		return false, err
+	}
+
+	var pk []byte
+	if compressed {
+		pk = publicKey.SerializeCompressed()
+	} else {
+		pk = publicKey.SerializeUncompressed()
+	}
+	keyHash := btcutil.Hash160(pk)
+
+	var recovered btcutil.Address
+	switch addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		recovered, err = btcutil.NewAddressPubKeyHash(keyHash, chainParams)
+	case *btcutil.AddressScriptHash:
+		var redeemScript []byte
+		redeemScript, err = txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(keyHash).Script()
+		if err == nil {
+			recovered, err = btcutil.NewAddressScriptHash(redeemScript, chainParams)
+		}
+	case *btcutil.AddressWitnessPubKeyHash:
+		recovered, err = btcutil.NewAddressWitnessPubKeyHash(keyHash, chainParams)
+	default:
+		return false, errors.New("address type not supported for message signing")
+	}
+	if err != nil {
+		return false, err
+	}
+	return recovered.EncodeAddress() == addr.EncodeAddress(), nil
 }
 
 func DecodeAddress(addr string, chainParams *chaincfg.Params) (btcutil.Address, error) {
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/btcsuite/btcd/wire"
@@ -31,9 +32,10 @@ const (
 	ChainMaticTestnet = 80001 // for Polygon Matic Testnet
 	ChainPrivate      = 1337  // for ETH
 
-	SegWitNone   SegWitType = 0
-	SegWitScript SegWitType = 1
-	SegWitNative SegWitType = 2
+	SegWitNone    SegWitType = 0
+	SegWitScript  SegWitType = 1
+	SegWitNative  SegWitType = 2
+	SegWitTaproot SegWitType = 3
 
 	ChangeTypeExternal = 0
 	ChangeTypeInternal = 1 // Usually used for change, not visible to the outside world
@@ -56,6 +58,10 @@ const (
 	p2sh  = "p2sh"
 )
 
+// ErrDeprecatedChain is the error of the eth testnets which were shut down,
+// go-ethereum has no config for them anymore.
+var ErrDeprecatedChain = errors.New("deprecated eth chain")
+
 var (
 	BscChainConfig          = &params.ChainConfig{}
 	BscTestnetChainConfig   = &params.ChainConfig{}
@@ -67,12 +73,8 @@ func GetEthChainParams(chainId int) (*params.ChainConfig, error) {
 	switch chainId {
 	case ChainMainNet:
 		return params.MainnetChainConfig, nil
-	//case ChainRopsten:
-	//	return params.RopstenChainConfig, nil
-	//case ChainRinkeby:
-	//	return params.RinkebyChainConfig, nil
-	case ChainGoerli:
-		return params.GoerliChainConfig, nil
+	case ChainRopsten, ChainRinkeby, ChainGoerli:
+		return nil, fmt.Errorf("%w: %d, use ChainSepolia or ChainHolesky", ErrDeprecatedChain, chainId)
 	case ChainHolesky:
 		return params.HoleskyChainConfig, nil
 	case ChainSepolia:
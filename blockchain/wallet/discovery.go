@@ -0,0 +1,157 @@
+package wallet
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultGapLimit is the BIP-44 address gap limit.
+const DefaultGapLimit = 20
+
+// HistoryChecker reports whether an address has ever been used on chain,
+// BtcClient and EthClient implement it.
+//
+// bitcoind has no address index: BtcClient sees the full history only of the
+// addresses of the node's wallet, the others are scanned in the utxo set. An
+// address whose coins are all spent looks unused then, and the discovery may
+// stop before the accounts or indexes after it. Import the descriptors into the
+// node's wallet, or use a checker backed by an address indexer, to find them.
+type HistoryChecker interface {
+	HasHistory(ctx context.Context, address string) (bool, error)
+}
+
+// BatchHistoryChecker checks a window of addresses at once, BtcClient
+// implements it with one utxo set scan. The discovery checks gapLimit
+// addresses at a time with it.
+type BatchHistoryChecker interface {
+	HistoryChecker
+	HasHistories(ctx context.Context, addresses []string) ([]bool, error)
+}
+
+type DiscoveredAccount struct {
+	AccountIndex int
+	// ReceiveIndexes and ChangeIndexes are the used indexes of the external
+	// and internal chains, in order.
+	ReceiveIndexes []int
+	ChangeIndexes  []int
+	// NextReceiveIndex and NextChangeIndex are the first fresh indexes after
+	// the last used one.
+	NextReceiveIndex int
+	NextChangeIndex  int
+}
+
+// DiscoverAccounts finds the used accounts of symbol and segWitType as BIP-44
+// account discovery: the accounts are scanned in order, every chain until
+// gapLimit unused addresses in a row, and the discovery stops at the first
+// account without history on its external chain. gapLimit is DefaultGapLimit
+// if 0.
+func (h *HDWallet) DiscoverAccounts(ctx context.Context, checker HistoryChecker, symbol string,
+	segWitType SegWitType, gapLimit int) ([]*DiscoveredAccount, error) {
+
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+	purpose, ok := bipPurpose[segWitType]
+	if !ok {
+		return nil, fmt.Errorf("invalid segwit type: %d", segWitType)
+	}
+	if symbol != SymbolBtc && segWitType != SegWitNone {
+		return nil, fmt.Errorf("segwit is not supported for %s", symbol)
+	}
+
+	var accounts []*DiscoveredAccount
+	for accountIndex := 0; ; accountIndex++ {
+		receiveIndexes, err := h.scanChain(ctx, checker, purpose, symbol, segWitType, accountIndex, ChangeTypeExternal, gapLimit)
+		if err != nil {
+			return nil, err
+		}
+		if len(receiveIndexes) == 0 {
+			return accounts, nil
+		}
+		changeIndexes, err := h.scanChain(ctx, checker, purpose, symbol, segWitType, accountIndex, ChangeTypeInternal, gapLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, &DiscoveredAccount{
+			AccountIndex:     accountIndex,
+			ReceiveIndexes:   receiveIndexes,
+			ChangeIndexes:    changeIndexes,
+			NextReceiveIndex: nextIndex(receiveIndexes),
+			NextChangeIndex:  nextIndex(changeIndexes),
+		})
+	}
+}
+
+func (h *HDWallet) scanChain(ctx context.Context, checker HistoryChecker, purpose int, symbol string,
+	segWitType SegWitType, accountIndex, changeType, gapLimit int) ([]int, error) {
+
+	chainId := h.btcChainId
+	if symbol == SymbolEth {
+		chainId = h.ethChainId
+	}
+
+	var used []int
+	// the window is the addresses until the gap limit if all are unused, so no
+	// address after the last needed one is checked
+	for start, gap := 0, 0; gap < gapLimit; {
+		var firstPath string
+		addresses := make([]string, gapLimit-gap)
+		for i := range addresses {
+			path, err := MakeBipXPath(purpose, symbol, chainId, accountIndex, changeType, start+i)
+			if err != nil {
+				return nil, err
+			}
+			w, err := h.NewWalletByPath(symbol, path, segWitType)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				firstPath = path
+			}
+			addresses[i] = w.DeriveAddress()
+		}
+
+		histories, err := checkHistories(ctx, checker, addresses)
+		if err != nil {
+			return nil, fmt.Errorf("check from %s: %w", firstPath, err)
+		}
+		for i, ok := range histories {
+			if ok {
+				used = append(used, start+i)
+				gap = 0
+			} else {
+				gap++
+			}
+		}
+		start += len(addresses)
+	}
+	return used, nil
+}
+
+func checkHistories(ctx context.Context, checker HistoryChecker, addresses []string) ([]bool, error) {
+	if batch, ok := checker.(BatchHistoryChecker); ok {
+		histories, err := batch.HasHistories(ctx, addresses)
+		if err == nil && len(histories) != len(addresses) {
+			err = fmt.Errorf("%d histories of %d addresses", len(histories), len(addresses))
+		}
+		return histories, err
+	}
+
+	histories := make([]bool, len(addresses))
+	for i, address := range addresses {
+		ok, err := checker.HasHistory(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		histories[i] = ok
+	}
+	return histories, nil
+}
+
+func nextIndex(used []int) int {
+	if len(used) == 0 {
+		return 0
+	}
+	return used[len(used)-1] + 1
+}
@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHistory map[string]bool
+
+func (f fakeHistory) HasHistory(ctx context.Context, address string) (bool, error) {
+	return f[address], nil
+}
+
+// batchHistory counts the addresses and calls of the batch checks.
+type batchHistory struct {
+	fakeHistory
+	checked, calls int
+}
+
+func (b *batchHistory) HasHistory(ctx context.Context, address string) (bool, error) {
+	return false, errors.New("checked one by one")
+}
+
+func (b *batchHistory) HasHistories(ctx context.Context, addresses []string) ([]bool, error) {
+	b.calls++
+	b.checked += len(addresses)
+	histories := make([]bool, len(addresses))
+	for i, address := range addresses {
+		histories[i] = b.fakeHistory[address]
+	}
+	return histories, nil
+}
+
+// countingHistory counts the addresses checked one by one.
+type countingHistory struct {
+	fakeHistory
+	checked int
+}
+
+func (c *countingHistory) HasHistory(ctx context.Context, address string) (bool, error) {
+	c.checked++
+	return c.fakeHistory[address], nil
+}
+
+type failingHistory struct{}
+
+func (failingHistory) HasHistory(ctx context.Context, address string) (bool, error) {
+	return false, errors.New("node unavailable")
+}
+
+func TestDiscoverAccounts(t *testing.T) {
+	m, err := NewMnemonic()
+	require.NoError(t, err)
+	h, err := NewHDWallet(m, "", BtcChainRegtest, ChainMainNet)
+	require.NoError(t, err)
+
+	history := fakeHistory{}
+	use := func(account, changeType, index int) {
+		w, err := h.NewNativeSegWitWallet(account, changeType, index)
+		require.NoError(t, err)
+		history[w.DeriveAddress()] = true
+	}
+	use(0, ChangeTypeExternal, 0)
+	use(0, ChangeTypeExternal, 3)
+	use(0, ChangeTypeExternal, 22)
+	use(0, ChangeTypeExternal, 50) // beyond the gap limit
+	use(0, ChangeTypeInternal, 0)
+	use(0, ChangeTypeInternal, 1)
+	use(1, ChangeTypeExternal, 0)
+	use(3, ChangeTypeExternal, 0) // after an unused account
+
+	ctx := context.Background()
+	accounts, err := h.DiscoverAccounts(ctx, history, SymbolBtc, SegWitNative, 0)
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+
+	require.Equal(t, &DiscoveredAccount{AccountIndex: 0,
+		ReceiveIndexes: []int{0, 3, 22}, ChangeIndexes: []int{0, 1},
+		NextReceiveIndex: 23, NextChangeIndex: 2}, accounts[0])
+	require.Equal(t, &DiscoveredAccount{AccountIndex: 1,
+		ReceiveIndexes: []int{0}, NextReceiveIndex: 1}, accounts[1])
+
+	// a smaller gap limit stops before index 22
+	accounts, err = h.DiscoverAccounts(ctx, history, SymbolBtc, SegWitNative, 5)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 3}, accounts[0].ReceiveIndexes)
+
+	// the addresses of another segwit type are unused
+	accounts, err = h.DiscoverAccounts(ctx, history, SymbolBtc, SegWitScript, 0)
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+
+	// a window of addresses per check, and not more addresses than one by one
+	batch := &batchHistory{fakeHistory: history}
+	batched, err := h.DiscoverAccounts(ctx, batch, SymbolBtc, SegWitNative, 0)
+	require.NoError(t, err)
+	counting := &countingHistory{fakeHistory: history}
+	accounts, err = h.DiscoverAccounts(ctx, counting, SymbolBtc, SegWitNative, 0)
+	require.NoError(t, err)
+	require.Equal(t, accounts, batched)
+	require.Equal(t, counting.checked, batch.checked)
+	require.Less(t, batch.calls, 20)
+	fmt.Println("addresses:", batch.checked, "checks:", batch.calls)
+
+	_, err = h.DiscoverAccounts(ctx, failingHistory{}, SymbolEth, SegWitNone, 0)
+	require.Error(t, err)
+}
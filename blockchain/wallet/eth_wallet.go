@@ -7,7 +7,9 @@ import (
 
 	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
 )
@@ -102,6 +104,63 @@ func (w *EthWallet) DeriveNativePrivateKey() *ecdsa.PrivateKey {
 	return w.privateKey
 }
 
+// SignDigest returns the 65-byte [R || S || V] signature of digest, V is 0 or 1.
+func (w *EthWallet) SignDigest(digest []byte) ([]byte, error) {
+	if len(digest) != 32 {
+		return nil, ErrInvalidDigest
+	}
+	return crypto.Sign(digest, w.privateKey)
+}
+
+func (w *EthWallet) VerifyDigest(digest, signature []byte) (bool, error) {
+	if len(digest) != 32 {
+		return false, ErrInvalidDigest
+	}
+	if len(signature) != 64 && len(signature) != 65 {
+		return false, ErrInvalidSignature
+	}
+	return crypto.VerifySignature(crypto.FromECDSAPub(w.publicKey), digest, signature[:64]), nil
+}
+
+// SignMessage signs message as EIP-191 personal_sign and returns the hex
+// encoded signature with V in {27, 28}.
+func (w *EthWallet) SignMessage(message string) (string, error) {
+	sig, err := crypto.Sign(accounts.TextHash([]byte(message)), w.privateKey)
+	if err != nil {
+		return "", err
+	}
+	sig[crypto.RecoveryIDOffset] += 27
+	return hexutil.Encode(sig), nil
+}
+
+func (w *EthWallet) VerifyMessage(message, signature string) (bool, error) {
+	return VerifyEthMessage(w.DeriveAddress(), message, signature)
+}
+
+// VerifyEthMessage verifies a personal_sign signature against address.
+func VerifyEthMessage(address, message, signature string) (bool, error) {
+	if !common.IsHexAddress(address) {
+		return false, errors.New("invalid address")
+	}
+
+	sig, err := hexutil.Decode(signature)
+	if err != nil {
+		return false, err
+	}
+	if len(sig) != crypto.SignatureLength {
+		return false, ErrInvalidSignature
+	}
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+
+	publicKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return false, err
+	}
+	return crypto.PubkeyToAddress(*publicKey) == common.HexToAddress(address), nil
+}
+
 func DerivePublicKey(privateKey *ecdsa.PrivateKey) (*ecdsa.PublicKey, error) {
 	publicKey := privateKey.Public()
 	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
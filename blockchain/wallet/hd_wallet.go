@@ -1,11 +1,11 @@
 package wallet
 
 import (
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
 
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/tyler-smith/go-bip39"
 )
@@ -25,10 +25,31 @@ func NewHDWallet(mnemonic, password string, btcChainId int, ethChainId int) (*HD
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println("seed: ", hex.EncodeToString(seed))
 	return &HDWallet{seed: seed, btcChainId: btcChainId, ethChainId: ethChainId}, nil
 }
 
+// NewHDWalletFromSeed restores a hd wallet from its BIP-32 seed, e.g. one
+// unlocked from a keystore.
+func NewHDWalletFromSeed(seed []byte, btcChainId int, ethChainId int) (*HDWallet, error) {
+	if len(seed) < hdkeychain.MinSeedBytes || len(seed) > hdkeychain.MaxSeedBytes {
+		return nil, hdkeychain.ErrInvalidSeedLen
+	}
+	return &HDWallet{seed: append([]byte(nil), seed...), btcChainId: btcChainId, ethChainId: ethChainId}, nil
+}
+
+// Seed returns a copy of the BIP-32 seed, keep it secret.
+func (h *HDWallet) Seed() []byte {
+	return append([]byte(nil), h.seed...)
+}
+
+func (h *HDWallet) BtcChainId() int {
+	return h.btcChainId
+}
+
+func (h *HDWallet) EthChainId() int {
+	return h.ethChainId
+}
+
 func (h *HDWallet) NewWallet(symbol string, accountIndex, changeType, index int) (Wallet, error) {
 	path, err := MakeBip44Path(symbol, h.btcChainId, accountIndex, changeType, index)
 	if err != nil {
@@ -54,6 +75,14 @@ func (h *HDWallet) NewNativeSegWitWallet(accountIndex, changeType, index int) (W
 	return h.NewWalletByPath(SymbolBtc, path, SegWitNative)
 }
 
+func (h *HDWallet) NewTaprootWallet(accountIndex, changeType, index int) (Wallet, error) {
+	path, err := MakeBip86Path(SymbolBtc, h.btcChainId, accountIndex, changeType, index)
+	if err != nil {
+		return nil, err
+	}
+	return h.NewWalletByPath(SymbolBtc, path, SegWitTaproot)
+}
+
 func (h *HDWallet) NewWalletByPath(symbol string, path string, segWitType SegWitType) (Wallet, error) {
 	var w Wallet
 	var err error
@@ -75,6 +104,58 @@ func (h *HDWallet) NewWalletByPath(symbol string, path string, segWitType SegWit
 	return w, nil
 }
 
+// AccountXpub returns the extended public key of an account, a BIP-44 xpub for
+// ETH, and for BTC the xpub, ypub, zpub or BIP-86 xpub of segWitType (tpub,
+// upub, vpub on the test networks). Watch-only wallets derive the addresses of
+// the account from it.
+func (h *HDWallet) AccountXpub(symbol string, segWitType SegWitType, accountIndex int) (string, error) {
+	var chainId int
+	chainParams := &chaincfg.MainNetParams
+	switch symbol {
+	case SymbolBtc:
+		var err error
+		chainId = h.btcChainId
+		chainParams, err = GetBtcChainParams(chainId)
+		if err != nil {
+			return "", err
+		}
+	case SymbolEth:
+		chainId = h.ethChainId
+		if segWitType != SegWitNone {
+			return "", errors.New("segwit is not supported for ETH")
+		}
+	default:
+		return "", fmt.Errorf("invalid symbol: %s", symbol)
+	}
+
+	version, err := xpubVersion(segWitType, chainParams)
+	if err != nil {
+		return "", err
+	}
+	path, err := MakeBipXAccountPath(bipPurpose[segWitType], symbol, chainId, accountIndex)
+	if err != nil {
+		return "", err
+	}
+
+	masterKey, err := hdkeychain.NewMaster(h.seed, chainParams)
+	if err != nil {
+		return "", err
+	}
+	accountKey, err := DeriveExtendedKeyByPath(masterKey, path, IsFixIssue172)
+	if err != nil {
+		return "", err
+	}
+	accountKey, err = accountKey.Neuter()
+	if err != nil {
+		return "", err
+	}
+	accountKey, err = accountKey.CloneWithVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return accountKey.String(), nil
+}
+
 func MakeBip44Path(symbol string, chainId int, accountIndex, changeType, index int) (string, error) {
 	return MakeBipXPath(44, symbol, chainId, accountIndex, changeType, index)
 }
@@ -87,21 +168,14 @@ func MakeBip84Path(symbol string, chainId int, accountIndex, changeType, index i
 	return MakeBipXPath(84, symbol, chainId, accountIndex, changeType, index)
 }
 
+func MakeBip86Path(symbol string, chainId int, accountIndex, changeType, index int) (string, error) {
+	return MakeBipXPath(86, symbol, chainId, accountIndex, changeType, index)
+}
+
 func MakeBipXPath(bipType int, symbol string, chainId int, accountIndex, changeType, index int) (string, error) {
-	var coinType int
-	switch symbol {
-	case SymbolEth:
-		coinType = 60
-	case SymbolBtc:
-		chainParams, err := GetBtcChainParams(chainId)
-		if err != nil {
-			return "", err
-		}
-		coinType = int(chainParams.HDCoinType)
-	case SymbolTrx:
-		coinType = 195
-	default:
-		return "", fmt.Errorf("invalid symbol: %s", symbol)
+	coinType, err := getCoinType(symbol, chainId)
+	if err != nil {
+		return "", err
 	}
 
 	if accountIndex < 0 || index < 0 {
@@ -113,6 +187,35 @@ func MakeBipXPath(bipType int, symbol string, chainId int, accountIndex, changeT
 	return fmt.Sprintf("m/%d'/%d'/%d'/%d/%d", bipType, coinType, accountIndex, changeType, index), nil
 }
 
+// MakeBipXAccountPath returns the account level path m/purpose'/coin'/account'.
+func MakeBipXAccountPath(bipType int, symbol string, chainId int, accountIndex int) (string, error) {
+	coinType, err := getCoinType(symbol, chainId)
+	if err != nil {
+		return "", err
+	}
+	if accountIndex < 0 {
+		return "", errors.New("invalid account index")
+	}
+	return fmt.Sprintf("m/%d'/%d'/%d'", bipType, coinType, accountIndex), nil
+}
+
+func getCoinType(symbol string, chainId int) (int, error) {
+	switch symbol {
+	case SymbolEth:
+		return 60, nil
+	case SymbolBtc:
+		chainParams, err := GetBtcChainParams(chainId)
+		if err != nil {
+			return 0, err
+		}
+		return int(chainParams.HDCoinType), nil
+	case SymbolTrx:
+		return 195, nil
+	default:
+		return 0, fmt.Errorf("invalid symbol: %s", symbol)
+	}
+}
+
 func GetBtcChainParams(chainId int) (*chaincfg.Params, error) {
 	switch chainId {
 	case BtcChainMainNet:
@@ -134,15 +237,12 @@ func NewMnemonic() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("entropy: ", hex.EncodeToString(entropy))
 
 	// entropy to mnemonic
 	mnemonic, err := bip39.NewMnemonic(entropy)
 	if err != nil {
 		return "", err
 	}
-	fmt.Println("mnemonic: ", mnemonic)
-
 	return mnemonic, nil
 }
 
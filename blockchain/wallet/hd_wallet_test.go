@@ -2,6 +2,7 @@ package wallet
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/btcsuite/btcd/wire"
@@ -51,6 +52,27 @@ func TestNewHDNativeSegWitWallet(t *testing.T) {
 	fmt.Println("chain id: ", w.ChainId())
 }
 
+func TestNewHDTaprootWallet(t *testing.T) {
+	m, err := NewMnemonic()
+	require.NoError(t, err)
+
+	// btc mainnet
+	h, err := NewHDWallet(m, "123", 3652501241, 0)
+	require.NoError(t, err)
+
+	w, err := h.NewTaprootWallet(0, 0, 0)
+	require.NoError(t, err)
+
+	addr := w.DeriveAddress()
+	fmt.Println("address: ", addr)
+	require.True(t, strings.HasPrefix(addr, "bc1p"))
+	require.Len(t, addr, 62)
+
+	path, err := MakeBip86Path(SymbolBtc, 3652501241, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, "m/86'/0'/0'/0/0", path)
+}
+
 func TestGetChainId(t *testing.T) {
 	fmt.Println(int(wire.MainNet))
 }
@@ -0,0 +1,314 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// MultisigWallet is an m-of-n multisig wallet. The address is P2SH for
+// SegWitNone, P2SH-P2WSH for SegWitScript and P2WSH for SegWitNative, the
+// public keys are sorted as BIP-67.
+//
+// It is a txauthor.SecretsSource, GetScript returns the redeem and witness
+// scripts and GetKey returns the keys of the signers added to it. Every
+// signer signs its own copy of the psbt, then the copies are combined.
+type MultisigWallet struct {
+	segWitType  SegWitType
+	chainParams *chaincfg.Params
+	required    int
+	publicKeys  []*btcec.PublicKey
+	script      []byte
+	signers     []*BtcWallet
+}
+
+// NewMultisigWallet creates a watch-only multisig wallet from hex encoded
+// compressed public keys.
+func NewMultisigWallet(required int, publicKeys []string, chainId int, segWitType SegWitType) (*MultisigWallet, error) {
+	chainParams, err := GetBtcChainParams(chainId)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*btcec.PublicKey, 0, len(publicKeys))
+	for _, s := range publicKeys {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		key, err := btcec.ParsePubKey(b)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return newMultisigWallet(required, keys, chainParams, segWitType)
+}
+
+// NewMultisigWalletFromWallets creates a multisig wallet of the wallets' keys,
+// all the wallets are signers.
+func NewMultisigWalletFromWallets(required int, wallets []*BtcWallet, segWitType SegWitType) (*MultisigWallet, error) {
+	if len(wallets) == 0 {
+		return nil, errors.New("wallets are required")
+	}
+
+	keys := make([]*btcec.PublicKey, 0, len(wallets))
+	for _, w := range wallets {
+		if w.chainParams.Net != wallets[0].chainParams.Net {
+			return nil, errors.New("wallets are on different networks")
+		}
+		keys = append(keys, w.publicKey)
+	}
+	m, err := newMultisigWallet(required, keys, wallets[0].chainParams, segWitType)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range wallets {
+		if err = m.AddSigner(w); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// NewMultisigWalletFromXpubs creates a watch-only multisig wallet of the keys
+// at changeType/index of every account xpub.
+func NewMultisigWalletFromXpubs(required int, xpubs []string, changeType, index int,
+	chainId int, segWitType SegWitType) (*MultisigWallet, error) {
+
+	chainParams, err := GetBtcChainParams(chainId)
+	if err != nil {
+		return nil, err
+	}
+	if changeType != ChangeTypeExternal && changeType != ChangeTypeInternal {
+		return nil, errors.New("invalid change type")
+	}
+	if index < 0 {
+		return nil, errors.New("invalid index")
+	}
+
+	keys := make([]*btcec.PublicKey, 0, len(xpubs))
+	for _, xpub := range xpubs {
+		extKey, err := hdkeychain.NewKeyFromString(xpub)
+		if err != nil {
+			return nil, err
+		}
+		if extKey.IsPrivate() {
+			return nil, errors.New("xpub is a private key")
+		}
+		for _, n := range []uint32{uint32(changeType), uint32(index)} {
+			extKey, err = extKey.Derive(n)
+			if err != nil {
+				return nil, err
+			}
+		}
+		key, err := extKey.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return newMultisigWallet(required, keys, chainParams, segWitType)
+}
+
+func newMultisigWallet(required int, keys []*btcec.PublicKey, chainParams *chaincfg.Params,
+	segWitType SegWitType) (*MultisigWallet, error) {
+
+	if segWitType != SegWitNone && segWitType != SegWitScript && segWitType != SegWitNative {
+		return nil, fmt.Errorf("unsupported multisig segwit type: %d", segWitType)
+	}
+	// OP_CHECKMULTISIG in a P2SH redeem script is limited to 15 keys by the
+	// 520 bytes push limit
+	if len(keys) == 0 || len(keys) > 15 || required <= 0 || required > len(keys) {
+		return nil, fmt.Errorf("invalid %d-of-%d multisig", required, len(keys))
+	}
+
+	// BIP-67, lexicographically sorted compressed keys
+	sorted := make([]*btcec.PublicKey, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].SerializeCompressed(), sorted[j].SerializeCompressed()) < 0
+	})
+
+	addrs := make([]*btcutil.AddressPubKey, 0, len(sorted))
+	for i, key := range sorted {
+		if i > 0 && sorted[i-1].IsEqual(key) {
+			return nil, errors.New("duplicate public key")
+		}
+		addr, err := btcutil.NewAddressPubKey(key.SerializeCompressed(), chainParams)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	script, err := txscript.MultiSigScript(addrs, required)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MultisigWallet{segWitType: segWitType, chainParams: chainParams,
+		required: required, publicKeys: sorted, script: script}, nil
+}
+
+// AddSigner adds the key of w, it must be one of the multisig keys.
+func (m *MultisigWallet) AddSigner(w *BtcWallet) error {
+	if w.chainParams.Net != m.chainParams.Net {
+		return errors.New("key network doesn't match")
+	}
+	for _, key := range m.publicKeys {
+		if key.IsEqual(w.publicKey) {
+			for _, signer := range m.signers {
+				if signer.publicKey.IsEqual(w.publicKey) {
+					return nil
+				}
+			}
+			m.signers = append(m.signers, w)
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+func (m *MultisigWallet) ChainId() int {
+	return int(m.chainParams.Net)
+}
+
+func (m *MultisigWallet) ChainParams() *chaincfg.Params {
+	return m.chainParams
+}
+
+func (m *MultisigWallet) Symbol() string {
+	return SymbolBtc
+}
+
+func (m *MultisigWallet) Required() int {
+	return m.required
+}
+
+// PublicKeys returns the hex encoded keys in BIP-67 order.
+func (m *MultisigWallet) PublicKeys() []string {
+	keys := make([]string, 0, len(m.publicKeys))
+	for _, key := range m.publicKeys {
+		keys = append(keys, hex.EncodeToString(key.SerializeCompressed()))
+	}
+	return keys
+}
+
+// MultisigScript returns the m <keys> n OP_CHECKMULTISIG script.
+func (m *MultisigWallet) MultisigScript() []byte {
+	return m.script
+}
+
+// RedeemScript returns the P2SH redeem script, nil for P2WSH.
+func (m *MultisigWallet) RedeemScript() []byte {
+	switch m.segWitType {
+	case SegWitNone:
+		return m.script
+	case SegWitScript:
+		return m.witnessProgram()
+	}
+	return nil
+}
+
+// WitnessScript returns the witness script, nil for P2SH.
+func (m *MultisigWallet) WitnessScript() []byte {
+	if m.segWitType == SegWitNone {
+		return nil
+	}
+	return m.script
+}
+
+func (m *MultisigWallet) DeriveAddress() string {
+	addr := m.DeriveNativeAddress()
+	if addr != nil {
+		return addr.EncodeAddress()
+	}
+	return ""
+}
+
+func (m *MultisigWallet) DeriveNativeAddress() btcutil.Address {
+	var addr btcutil.Address
+	var err error
+	switch m.segWitType {
+	case SegWitNone, SegWitScript:
+		addr, err = btcutil.NewAddressScriptHash(m.RedeemScript(), m.chainParams)
+	case SegWitNative:
+		addr, err = m.witnessAddress()
+	}
+	if err != nil {
+		log.Println("DeriveAddress error:", err)
+		return nil
+	}
+	return addr
+}
+
+// txauthor.SecretsSource
+func (m *MultisigWallet) GetKey(addr btcutil.Address) (*btcec.PrivateKey, bool, error) {
+	for _, signer := range m.signers {
+		switch a := addr.(type) {
+		case *btcutil.AddressPubKey:
+			if a.PubKey().IsEqual(signer.publicKey) {
+				return signer.privateKey, true, nil
+			}
+		default:
+			if signer.DeriveAddress() == addr.EncodeAddress() {
+				return signer.privateKey, true, nil
+			}
+		}
+	}
+	return nil, false, ErrKeyNotFound
+}
+
+func (m *MultisigWallet) GetScript(addr btcutil.Address) ([]byte, error) {
+	switch m.segWitType {
+	case SegWitNone:
+		if addr.EncodeAddress() == m.DeriveAddress() {
+			return m.script, nil
+		}
+	case SegWitScript:
+		// the redeem script of the p2sh address, and the witness script of
+		// the witness program it pays to
+		if addr.EncodeAddress() == m.DeriveAddress() {
+			return m.witnessProgram(), nil
+		}
+		witnessAddr, err := m.witnessAddress()
+		if err != nil {
+			return nil, err
+		}
+		if addr.EncodeAddress() == witnessAddr.EncodeAddress() {
+			return m.script, nil
+		}
+	case SegWitNative:
+		if addr.EncodeAddress() == m.DeriveAddress() {
+			return m.script, nil
+		}
+	}
+	return nil, ErrAddressNotMatch
+}
+
+func (m *MultisigWallet) witnessProgram() []byte {
+	scriptHash := sha256.Sum256(m.script)
+	program, err := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(scriptHash[:]).Script()
+	if err != nil {
+		log.Println("witnessProgram error:", err)
+		return nil
+	}
+	return program
+}
+
+func (m *MultisigWallet) witnessAddress() (*btcutil.AddressWitnessScriptHash, error) {
+	scriptHash := sha256.Sum256(m.script)
+	return btcutil.NewAddressWitnessScriptHash(scriptHash[:], m.chainParams)
+}
@@ -0,0 +1,80 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultisigWallet(t *testing.T) {
+	// BIP-67 test vector
+	keys := []string{
+		"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+		"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+	}
+	m, err := NewMultisigWallet(2, keys, BtcChainMainNet, SegWitNone)
+	require.NoError(t, err)
+	require.Equal(t, []string{keys[1], keys[0]}, m.PublicKeys())
+	require.Equal(t, "522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f"+
+		"2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f852ae",
+		hex.EncodeToString(m.RedeemScript()))
+	require.Equal(t, "39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z", m.DeriveAddress())
+
+	_, err = NewMultisigWallet(3, keys, BtcChainMainNet, SegWitNone)
+	require.Error(t, err)
+	_, err = NewMultisigWallet(1, keys, BtcChainMainNet, SegWitTaproot)
+	require.Error(t, err)
+}
+
+func TestMultisigWalletFromWallets(t *testing.T) {
+	mnemonic, err := NewMnemonic()
+	require.NoError(t, err)
+	h, err := NewHDWallet(mnemonic, "", BtcChainRegtest, ChainMainNet)
+	require.NoError(t, err)
+
+	var wallets []*BtcWallet
+	var keys []string
+	for i := 0; i < 3; i++ {
+		w, err := h.NewNativeSegWitWallet(i, 0, 0)
+		require.NoError(t, err)
+		wallets = append(wallets, w.(*BtcWallet))
+		keys = append(keys, w.DerivePublicKey())
+	}
+
+	for _, segWitType := range []SegWitType{SegWitNone, SegWitScript, SegWitNative} {
+		m, err := NewMultisigWalletFromWallets(2, wallets, segWitType)
+		require.NoError(t, err)
+		fmt.Println("multisig address:", m.DeriveAddress())
+
+		// the order of the keys does not change the address
+		watchOnly, err := NewMultisigWallet(2, []string{keys[2], keys[0], keys[1]}, BtcChainRegtest, segWitType)
+		require.NoError(t, err)
+		require.Equal(t, m.DeriveAddress(), watchOnly.DeriveAddress())
+		_, _, err = watchOnly.GetKey(m.DeriveNativeAddress())
+		require.ErrorIs(t, err, ErrKeyNotFound)
+
+		script, err := m.GetScript(m.DeriveNativeAddress())
+		require.NoError(t, err)
+		switch segWitType {
+		case SegWitNone:
+			require.Equal(t, m.MultisigScript(), script)
+		case SegWitScript:
+			require.Equal(t, m.RedeemScript(), script)
+			witnessAddr, err := btcutil.NewAddressWitnessScriptHash(script[2:], m.ChainParams())
+			require.NoError(t, err)
+			script, err = m.GetScript(witnessAddr)
+			require.NoError(t, err)
+			require.Equal(t, m.WitnessScript(), script)
+		case SegWitNative:
+			require.Equal(t, m.WitnessScript(), script)
+		}
+
+		require.NoError(t, watchOnly.AddSigner(wallets[0]))
+		other, err := h.NewNativeSegWitWallet(5, 0, 0)
+		require.NoError(t, err)
+		require.ErrorIs(t, watchOnly.AddSigner(other.(*BtcWallet)), ErrKeyNotFound)
+	}
+}
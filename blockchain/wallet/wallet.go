@@ -1,5 +1,12 @@
 package wallet
 
+import "errors"
+
+var (
+	ErrInvalidDigest    = errors.New("digest must be 32 bytes")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
 type Wallet interface {
 	ChainId() int
 	Symbol() string
@@ -7,4 +14,17 @@ type Wallet interface {
 	DeriveAddress() string
 	DerivePublicKey() string
 	DerivePrivateKey() string
+
+	// SignDigest signs a raw 32-byte digest with the wallet's private key.
+	SignDigest(digest []byte) ([]byte, error)
+	// VerifyDigest reports whether signature was produced by SignDigest
+	// for digest with the wallet's key.
+	VerifyDigest(digest, signature []byte) (bool, error)
+
+	// SignMessage signs a human-readable message with the chain's message
+	// signing convention and returns the encoded signature.
+	SignMessage(message string) (string, error)
+	// VerifyMessage reports whether signature is a valid signature of
+	// message for the wallet's address.
+	VerifyMessage(message, signature string) (bool, error)
 }
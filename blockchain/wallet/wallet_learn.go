@@ -2,13 +2,13 @@ package wallet
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 
+	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/btcsuite/btcd/chaincfg"
 	"golang.org/x/crypto/ripemd160"
 )
 
@@ -80,6 +80,34 @@ func (w *LegacyWallet) DeriveAddress() string {
 	return address
 }
 
+// btcWallet is the wallet of the key as a P2PKH BtcWallet of mainnet, whose
+// signatures it makes.
+func (w *LegacyWallet) btcWallet() *BtcWallet {
+	privateKey, publicKey := btcec.PrivKeyFromBytes(w.PrivateKey.D.Bytes())
+	return &BtcWallet{symbol: SymbolBtc,
+		chainParams: &chaincfg.MainNetParams, segWitType: SegWitNone,
+		privateKey: privateKey,
+		publicKey:  publicKey}
+}
+
+func (w *LegacyWallet) SignDigest(digest []byte) ([]byte, error) {
+	return w.btcWallet().SignDigest(digest)
+}
+
+func (w *LegacyWallet) VerifyDigest(digest, signature []byte) (bool, error) {
+	return w.btcWallet().VerifyDigest(digest, signature)
+}
+
+// SignMessage signs like BtcWallet, a base64 BIP-137 compact signature of the
+// p2pkh address.
+func (w *LegacyWallet) SignMessage(message string) (string, error) {
+	return w.btcWallet().SignMessage(message)
+}
+
+func (w *LegacyWallet) VerifyMessage(message, signature string) (bool, error) {
+	return w.btcWallet().VerifyMessage(message, signature)
+}
+
 func hashPubKey(pubKey []byte) []byte {
 	publicSHA256 := sha256.Sum256(pubKey)
 
@@ -98,12 +126,10 @@ func checksum(payload []byte) []byte {
 }
 
 func newKeyPair() (ecdsa.PrivateKey, []byte) {
-	// get a curve, 256 here
-	curve := elliptic.P256()
-	// get the private ket based on the curve
-	private, _ := ecdsa.GenerateKey(curve, rand.Reader)
-	// public key
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
-
-	return *private, pubKey
+	// the private key on secp256k1, like bitcoin
+	private, _ := btcec.NewPrivateKey()
+	// compressed public key
+	pubKey := private.PubKey().SerializeCompressed()
+
+	return *private.ToECDSA(), pubKey
 }
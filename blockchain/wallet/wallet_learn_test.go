@@ -1,8 +1,13 @@
 package wallet
 
 import (
+	"encoding/base64"
 	"fmt"
 	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewWallet(t *testing.T) {
@@ -10,3 +15,33 @@ func TestNewWallet(t *testing.T) {
 	addr := w.DeriveAddress()
 	fmt.Println(addr)
 }
+
+func TestLegacyWalletSignMessage(t *testing.T) {
+	w := NewLegacyWallet().(*LegacyWallet)
+	addr, err := btcutil.NewAddressPubKeyHash(hashPubKey(w.PublicKey), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	// a signmessage signature of the p2pkh address, like the ones of BtcWallet
+	sig, err := w.SignMessage("hello")
+	require.NoError(t, err)
+	fmt.Println("signature:", sig)
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	require.NoError(t, err)
+	require.Len(t, raw, 65)
+	ok, err := VerifyBtcMessage(addr.EncodeAddress(), "hello", sig, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = w.VerifyMessage("hello", sig)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = w.VerifyMessage("hello!", sig)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	digest := BtcMessageHash("hello")
+	der, err := w.SignDigest(digest)
+	require.NoError(t, err)
+	ok, err = w.VerifyDigest(digest, der)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletSign(t *testing.T) {
+	m, err := NewMnemonic()
+	require.NoError(t, err)
+
+	h, err := NewHDWallet(m, "", BtcChainMainNet, ChainMainNet)
+	require.NoError(t, err)
+
+	w0, err := h.NewWallet(SymbolBtc, 0, 0, 0)
+	require.NoError(t, err)
+	w1, err := h.NewSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w2, err := h.NewNativeSegWitWallet(0, 0, 0)
+	require.NoError(t, err)
+	w3, err := h.NewWallet(SymbolEth, 0, 0, 0)
+	require.NoError(t, err)
+	other, err := h.NewWallet(SymbolEth, 0, 0, 1)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("digest"))
+	message := "hello backend-learn"
+
+	for _, w := range []Wallet{w0, w1, w2, w3} {
+		sig, err := w.SignDigest(digest[:])
+		require.NoError(t, err)
+		ok, err := w.VerifyDigest(digest[:], sig)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		_, err = w.SignDigest(digest[:31])
+		require.ErrorIs(t, err, ErrInvalidDigest)
+
+		msgSig, err := w.SignMessage(message)
+		require.NoError(t, err)
+		fmt.Println(w.DeriveAddress(), "signature:", msgSig)
+
+		ok, err = w.VerifyMessage(message, msgSig)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = w.VerifyMessage(message+"!", msgSig)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+
+	// signature of another key
+	sig, err := other.SignMessage(message)
+	require.NoError(t, err)
+	ok, err := w3.VerifyMessage(message, sig)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestVerifyBtcMessage(t *testing.T) {
+	chainParams, err := GetBtcChainParams(BtcChainMainNet)
+	require.NoError(t, err)
+
+	w, err := NewBtcWallet("L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k", BtcChainMainNet, SegWitNone)
+	require.NoError(t, err)
+
+	sig, err := w.SignMessage("message")
+	require.NoError(t, err)
+
+	// 65 bytes compact signature, base64 encoded
+	require.Len(t, sig, 88)
+	ok, err := VerifyBtcMessage(w.DeriveAddress(), "message", sig, chainParams)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = VerifyBtcMessage(w.DeriveAddress(), "message", "bm90IGEgc2lnbmF0dXJl", chainParams)
+	require.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestGetEthChainParams(t *testing.T) {
+	params, err := GetEthChainParams(ChainSepolia)
+	require.NoError(t, err)
+	require.Equal(t, int64(ChainSepolia), params.ChainID.Int64())
+
+	for _, chainId := range []int{ChainRopsten, ChainRinkeby, ChainGoerli} {
+		_, err = GetEthChainParams(chainId)
+		require.ErrorIs(t, err, ErrDeprecatedChain)
+		fmt.Println(err)
+	}
+	_, err = GetEthChainParams(2)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrDeprecatedChain)
+}
@@ -0,0 +1,209 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var ErrWatchOnly = errors.New("watch-only wallet has no private key")
+
+// SLIP-132 versions of the segwit extended public keys, plain xpub and tpub
+// come from the chain params.
+var (
+	ypubVersion = []byte{0x04, 0x9d, 0x7c, 0xb2}
+	zpubVersion = []byte{0x04, 0xb2, 0x47, 0x46}
+	upubVersion = []byte{0x04, 0x4a, 0x52, 0x62}
+	vpubVersion = []byte{0x04, 0x5f, 0x1c, 0xf6}
+)
+
+var bipPurpose = map[SegWitType]int{
+	SegWitNone:    44,
+	SegWitScript:  49,
+	SegWitNative:  84,
+	SegWitTaproot: 86,
+}
+
+// accountDepth is the depth of m/purpose'/coin'/account'
+const accountDepth = 3
+
+func xpubVersion(segWitType SegWitType, chainParams *chaincfg.Params) ([]byte, error) {
+	mainNet := chainParams.Net == wire.MainNet
+	switch segWitType {
+	case SegWitNone, SegWitTaproot:
+		return chainParams.HDPublicKeyID[:], nil
+	case SegWitScript:
+		if mainNet {
+			return ypubVersion, nil
+		}
+		return upubVersion, nil
+	case SegWitNative:
+		if mainNet {
+			return zpubVersion, nil
+		}
+		return vpubVersion, nil
+	}
+	return nil, fmt.Errorf("invalid segwit type: %d", segWitType)
+}
+
+// WatchOnlyHDWallet derives the addresses of an account from its extended
+// public key, see HDWallet.AccountXpub.
+type WatchOnlyHDWallet struct {
+	symbol      string
+	chainId     int
+	segWitType  SegWitType
+	chainParams *chaincfg.Params
+	accountKey  *hdkeychain.ExtendedKey
+}
+
+func NewWatchOnlyHDWallet(symbol, xpub string, chainId int, segWitType SegWitType) (*WatchOnlyHDWallet, error) {
+	var chainParams *chaincfg.Params
+	var err error
+	switch symbol {
+	case SymbolBtc:
+		chainParams, err = GetBtcChainParams(chainId)
+		if err != nil {
+			return nil, err
+		}
+	case SymbolEth:
+		if _, err = GetEthChainParams(chainId); err != nil {
+			return nil, err
+		}
+		if segWitType != SegWitNone {
+			return nil, errors.New("segwit is not supported for ETH")
+		}
+		chainParams = &chaincfg.MainNetParams
+	default:
+		return nil, fmt.Errorf("invalid symbol: %s", symbol)
+	}
+
+	accountKey, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if accountKey.IsPrivate() {
+		return nil, errors.New("extended key is private")
+	}
+	if accountKey.Depth() != accountDepth {
+		return nil, errors.New("extended key is not an account key")
+	}
+	version, err := xpubVersion(segWitType, chainParams)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(accountKey.Version(), version) {
+		return nil, errors.New("extended key version doesn't match")
+	}
+
+	return &WatchOnlyHDWallet{symbol: symbol, chainId: chainId,
+		segWitType: segWitType, chainParams: chainParams, accountKey: accountKey}, nil
+}
+
+func (h *WatchOnlyHDWallet) NewWallet(changeType, index int) (*WatchOnlyWallet, error) {
+	if changeType != ChangeTypeExternal && changeType != ChangeTypeInternal {
+		return nil, errors.New("invalid change type")
+	}
+	if index < 0 {
+		return nil, errors.New("invalid index")
+	}
+
+	key, err := h.accountKey.Derive(uint32(changeType))
+	if err != nil {
+		return nil, err
+	}
+	key, err = key.Derive(uint32(index))
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := key.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WatchOnlyWallet{symbol: h.symbol, chainId: h.chainId,
+		segWitType: h.segWitType, chainParams: h.chainParams, publicKey: publicKey}, nil
+}
+
+// WatchOnlyWallet is a Wallet without private key, it derives the address and
+// verifies signatures, signing fails with ErrWatchOnly.
+type WatchOnlyWallet struct {
+	symbol      string
+	chainId     int
+	segWitType  SegWitType
+	chainParams *chaincfg.Params
+	publicKey   *btcec.PublicKey
+}
+
+func (w *WatchOnlyWallet) ChainId() int {
+	if w.symbol == SymbolBtc {
+		return int(w.chainParams.Net)
+	}
+	return w.chainId
+}
+
+func (w *WatchOnlyWallet) Symbol() string {
+	return w.symbol
+}
+
+func (w *WatchOnlyWallet) DeriveAddress() string {
+	if w.symbol == SymbolEth {
+		return crypto.PubkeyToAddress(*w.publicKey.ToECDSA()).Hex()
+	}
+	addr := deriveBtcAddress(w.publicKey, w.segWitType, w.chainParams)
+	if addr != nil {
+		return addr.EncodeAddress()
+	}
+	return ""
+}
+
+func (w *WatchOnlyWallet) DerivePublicKey() string {
+	if w.symbol == SymbolEth {
+		return hex.EncodeToString(crypto.FromECDSAPub(w.publicKey.ToECDSA()))
+	}
+	return hex.EncodeToString(w.publicKey.SerializeCompressed())
+}
+
+// DerivePrivateKey always returns "", there is no private key.
+func (w *WatchOnlyWallet) DerivePrivateKey() string {
+	return ""
+}
+
+func (w *WatchOnlyWallet) SignDigest(digest []byte) ([]byte, error) {
+	return nil, ErrWatchOnly
+}
+
+func (w *WatchOnlyWallet) VerifyDigest(digest, signature []byte) (bool, error) {
+	if len(digest) != 32 {
+		return false, ErrInvalidDigest
+	}
+	if w.symbol == SymbolEth {
+		if len(signature) != 64 && len(signature) != 65 {
+			return false, ErrInvalidSignature
+		}
+		return crypto.VerifySignature(crypto.FromECDSAPub(w.publicKey.ToECDSA()), digest, signature[:64]), nil
+	}
+	sig, err := ecdsa.ParseDERSignature(signature)
+	if err != nil {
+		return false, err
+	}
+	return sig.Verify(digest, w.publicKey), nil
+}
+
+func (w *WatchOnlyWallet) SignMessage(message string) (string, error) {
+	return "", ErrWatchOnly
+}
+
+func (w *WatchOnlyWallet) VerifyMessage(message, signature string) (bool, error) {
+	if w.symbol == SymbolEth {
+		return VerifyEthMessage(w.DeriveAddress(), message, signature)
+	}
+	return VerifyBtcMessage(w.DeriveAddress(), message, signature, w.chainParams)
+}
@@ -0,0 +1,96 @@
+package wallet
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchOnlyWallet(t *testing.T) {
+	m, err := NewMnemonic()
+	require.NoError(t, err)
+
+	digest := sha256.Sum256([]byte("digest"))
+	message := "hello backend-learn"
+
+	for _, btcChainId := range []int{BtcChainMainNet, BtcChainRegtest} {
+		h, err := NewHDWallet(m, "", btcChainId, ChainMainNet)
+		require.NoError(t, err)
+
+		prefixes := map[SegWitType]string{SegWitNone: "xpub", SegWitScript: "ypub", SegWitNative: "zpub", SegWitTaproot: "xpub"}
+		if btcChainId != BtcChainMainNet {
+			prefixes = map[SegWitType]string{SegWitNone: "tpub", SegWitScript: "upub", SegWitNative: "vpub", SegWitTaproot: "tpub"}
+		}
+		newWallets := map[SegWitType]func(int, int, int) (Wallet, error){
+			SegWitNone: func(account, change, index int) (Wallet, error) {
+				return h.NewWallet(SymbolBtc, account, change, index)
+			},
+			SegWitScript:  h.NewSegWitWallet,
+			SegWitNative:  h.NewNativeSegWitWallet,
+			SegWitTaproot: h.NewTaprootWallet,
+		}
+
+		for segWitType, newWallet := range newWallets {
+			xpub, err := h.AccountXpub(SymbolBtc, segWitType, 1)
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(xpub, prefixes[segWitType]), xpub)
+			fmt.Println("account xpub:", xpub)
+
+			watch, err := NewWatchOnlyHDWallet(SymbolBtc, xpub, btcChainId, segWitType)
+			require.NoError(t, err)
+			w, err := newWallet(1, ChangeTypeInternal, 7)
+			require.NoError(t, err)
+			ww, err := watch.NewWallet(ChangeTypeInternal, 7)
+			require.NoError(t, err)
+			require.Equal(t, w.DeriveAddress(), ww.DeriveAddress())
+			require.Equal(t, w.DerivePublicKey(), ww.DerivePublicKey())
+			require.Equal(t, w.ChainId(), ww.ChainId())
+			require.Empty(t, ww.DerivePrivateKey())
+			_, err = ww.SignDigest(digest[:])
+			require.ErrorIs(t, err, ErrWatchOnly)
+
+			sig, err := w.SignDigest(digest[:])
+			require.NoError(t, err)
+			ok, err := ww.VerifyDigest(digest[:], sig)
+			require.NoError(t, err)
+			require.True(t, ok)
+		}
+
+		// the version must match the segwit type
+		ypub, err := h.AccountXpub(SymbolBtc, SegWitScript, 0)
+		require.NoError(t, err)
+		_, err = NewWatchOnlyHDWallet(SymbolBtc, ypub, btcChainId, SegWitNative)
+		require.Error(t, err)
+	}
+
+	h, err := NewHDWallet(m, "", BtcChainMainNet, ChainMainNet)
+	require.NoError(t, err)
+	xpub, err := h.AccountXpub(SymbolEth, SegWitNone, 0)
+	require.NoError(t, err)
+	watch, err := NewWatchOnlyHDWallet(SymbolEth, xpub, ChainMainNet, SegWitNone)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		w, err := h.NewWallet(SymbolEth, 0, ChangeTypeExternal, i)
+		require.NoError(t, err)
+		ww, err := watch.NewWallet(ChangeTypeExternal, i)
+		require.NoError(t, err)
+		require.Equal(t, w.DeriveAddress(), ww.DeriveAddress())
+		require.Equal(t, w.DerivePublicKey(), ww.DerivePublicKey())
+
+		sig, err := w.SignMessage(message)
+		require.NoError(t, err)
+		ok, err := ww.VerifyMessage(message, sig)
+		require.NoError(t, err)
+		require.True(t, ok)
+		_, err = ww.SignMessage(message)
+		require.ErrorIs(t, err, ErrWatchOnly)
+	}
+
+	// a private or non account key
+	masterXprv := "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"
+	_, err = NewWatchOnlyHDWallet(SymbolBtc, masterXprv, BtcChainMainNet, SegWitNone)
+	require.Error(t, err)
+}